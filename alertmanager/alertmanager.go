@@ -0,0 +1,200 @@
+// Package alertmanager posts the threshold violations of the exporter to the Alertmanager API or a compatible webhook
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+)
+
+const (
+	defaultRepeatInterval = 5 * time.Minute
+	defaultTimeout        = 10 * time.Second
+	retryDelay            = 10 * time.Second
+)
+
+// alert is an alert in the JSON format of the Alertmanager API
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Notifier sends the alerts passed to Notify; an alert is sent once per change of its state and firing alerts are
+// repeated, so the Alertmanager does not resolve them
+type Notifier struct {
+	conf   config.AlertWebhook
+	repeat time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mutex sync.Mutex
+	// active are the firing alerts and pending the alerts which have not been sent yet, by their labels
+	active  map[string]prometheus.Alert
+	pending map[string]prometheus.Alert
+	wake    chan struct{}
+}
+
+// New creates a Notifier; conf has to be validated by config.Config.Validate
+func New(conf config.AlertWebhook) *Notifier {
+	n := &Notifier{
+		conf:    conf,
+		repeat:  conf.RepeatInterval.Duration,
+		client:  &http.Client{Timeout: conf.Timeout.Duration},
+		now:     time.Now,
+		active:  make(map[string]prometheus.Alert),
+		pending: make(map[string]prometheus.Alert),
+		wake:    make(chan struct{}, 1),
+	}
+	if n.repeat == 0 {
+		n.repeat = defaultRepeatInterval
+	}
+	if n.client.Timeout == 0 {
+		n.client.Timeout = defaultTimeout
+	}
+	return n
+}
+
+// fingerprint identifies an alert by its labels
+func fingerprint(a prometheus.Alert) string {
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%q,", name, a.Labels[name])
+	}
+	return b.String()
+}
+
+// Notify queues alerts for Run; a queued alert with the same labels is replaced
+func (n *Notifier) Notify(alerts []prometheus.Alert) {
+	n.mutex.Lock()
+	for _, a := range alerts {
+		fp := fingerprint(a)
+		if a.EndsAt.IsZero() {
+			n.active[fp] = a
+		} else {
+			delete(n.active, fp)
+		}
+		n.pending[fp] = a
+	}
+	n.mutex.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the queued alerts and repeats the firing alerts every repeat interval until stop is closed
+func (n *Notifier) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(n.repeat)
+	defer ticker.Stop()
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-n.wake:
+		case <-retry:
+		case <-ticker.C:
+			n.mutex.Lock()
+			for fp, a := range n.active {
+				if _, ok := n.pending[fp]; !ok {
+					n.pending[fp] = a
+				}
+			}
+			n.mutex.Unlock()
+		case <-stop:
+			return
+		}
+		retry = nil
+		if err := n.flush(); err != nil {
+			log.Printf("can not send alerts to %s, retry in %v; err is: %v", n.conf.URL, retryDelay, err)
+			retry = time.After(retryDelay)
+		}
+	}
+}
+
+// flush sends the pending alerts; if that fails they are queued again unless they have been replaced meanwhile
+func (n *Notifier) flush() error {
+	n.mutex.Lock()
+	pending := n.pending
+	n.pending = make(map[string]prometheus.Alert)
+	n.mutex.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := n.Send(pending)
+	if err != nil {
+		n.mutex.Lock()
+		for fp, a := range pending {
+			if _, ok := n.pending[fp]; !ok {
+				n.pending[fp] = a
+			}
+		}
+		n.mutex.Unlock()
+	}
+	return err
+}
+
+// Send posts alerts; firing alerts end after three repeat intervals unless they are sent again
+func (n *Notifier) Send(alerts map[string]prometheus.Alert) error {
+	fps := make([]string, 0, len(alerts))
+	for fp := range alerts {
+		fps = append(fps, fp)
+	}
+	sort.Strings(fps)
+	now := n.now()
+	body := make([]alert, 0, len(alerts))
+	for _, fp := range fps {
+		a := alerts[fp]
+		endsAt := a.EndsAt
+		if endsAt.IsZero() {
+			endsAt = now.Add(3 * n.repeat)
+		}
+		body = append(body, alert{Labels: a.Labels, Annotations: a.Annotations, StartsAt: a.StartsAt, EndsAt: endsAt})
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.conf.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.conf.BasicAuth != nil {
+		req.SetBasicAuth(n.conf.BasicAuth.Username, n.conf.BasicAuth.Password)
+	}
+	if n.conf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+n.conf.BearerToken)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	return fmt.Errorf("POST %s returned %v: %s", n.conf.URL, resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,89 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+)
+
+func TestNotifierSendsChangesAndRepeats(t *testing.T) {
+	received := make(chan []alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected request %v with authorization %q", r.Method, r.Header.Get("Authorization"))
+		}
+		var alerts []alert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Errorf("can not decode the alerts: %v", err)
+		}
+		received <- alerts
+	}))
+	defer server.Close()
+
+	n := New(config.AlertWebhook{URL: server.URL, BearerToken: "secret", RepeatInterval: metav1.Duration{Duration: 100 * time.Millisecond}})
+	now := time.Unix(1000, 0)
+	n.now = func() time.Time { return now }
+	stop := make(chan struct{})
+	defer close(stop)
+	go n.Run(stop)
+
+	firing := prometheus.Alert{Labels: map[string]string{"alertname": "TooHot", "device": "a"}, StartsAt: time.Unix(900, 0)}
+	n.Notify([]prometheus.Alert{firing})
+	select {
+	case alerts := <-received:
+		if len(alerts) != 1 || alerts[0].Labels["alertname"] != "TooHot" || !alerts[0].EndsAt.Equal(now.Add(300*time.Millisecond)) {
+			t.Errorf("unexpected alerts %+v", alerts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the alert was not sent")
+	}
+	select {
+	case alerts := <-received:
+		if len(alerts) != 1 || !alerts[0].StartsAt.Equal(time.Unix(900, 0)) {
+			t.Errorf("unexpected repeated alerts %+v", alerts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the firing alert was not repeated")
+	}
+
+	resolved := firing
+	resolved.EndsAt = time.Unix(1100, 0)
+	n.Notify([]prometheus.Alert{resolved})
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case alerts := <-received:
+			if len(alerts) == 1 && alerts[0].EndsAt.Equal(time.Unix(1100, 0)) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("the resolved alert was not sent")
+		}
+	}
+}
+
+func TestFlushRequeuesOnFailure(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+
+	n := New(config.AlertWebhook{URL: server.URL})
+	n.Notify([]prometheus.Alert{{Labels: map[string]string{"alertname": "TooHot"}}})
+	if err := n.flush(); err == nil || len(n.pending) != 1 {
+		t.Fatalf("got %v with %v pending alerts, want an error and 1 pending alert", err, len(n.pending))
+	}
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := n.flush(); err != nil || len(n.pending) != 0 {
+		t.Errorf("got %v with %v pending alerts", err, len(n.pending))
+	}
+}
@@ -0,0 +1,734 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/units"
+)
+
+// labelName matches the valid prometheus label names
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricName matches the valid prometheus metric names
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Config contains all settings of the exporter
+type Config struct {
+	Server     string `json:"server,omitempty"`
+	KubeConfig string `json:"kubeConfig,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	TLS        TLS    `json:"tls,omitempty"`
+	Watch      Watch  `json:"watch,omitempty"`
+	Render     Render `json:"render,omitempty"`
+	// RemoteWrite are the endpoints the metrics are pushed to with the prometheus remote-write protocol
+	RemoteWrite []RemoteWrite `json:"remoteWrite,omitempty"`
+	// Pushgateway is the Pushgateway the metrics are pushed to; nothing is pushed if it is not set
+	Pushgateway *Pushgateway `json:"pushgateway,omitempty"`
+	// InfluxDB is the InfluxDB the twins are written to; nothing is written if it is not set
+	InfluxDB *InfluxDB `json:"influxDB,omitempty"`
+	// OTLP is the OpenTelemetry receiver the twins are exported to; nothing is exported if it is not set
+	OTLP *OTLP `json:"otlp,omitempty"`
+	// EventBus is the MQTT broker of an edge node the twin updates are received from in addition to the Device objects
+	EventBus *EventBus `json:"eventBus,omitempty"`
+	// History limits the history of the twins which is served on /api/v1/devices/{name}/twins/{prop}/history
+	History History `json:"history,omitempty"`
+	// Thresholds are the ranges the twins are checked against by the exporter itself
+	Thresholds []Threshold `json:"thresholds,omitempty"`
+	// AlertWebhook receives the threshold violations as Alertmanager alerts; nothing is sent if it is not set
+	AlertWebhook *AlertWebhook `json:"alertWebhook,omitempty"`
+	// WriteAPI enables setting the desired values of twins over http; it is disabled if not set
+	WriteAPI *WriteAPI `json:"writeAPI,omitempty"`
+	// Convergence configures the histogram of the time the devices take to apply a new desired value
+	Convergence Convergence `json:"convergence,omitempty"`
+	// Statistics configures the statistics of the reported values of every twin over sliding windows
+	Statistics Statistics `json:"statistics,omitempty"`
+}
+
+// TLS configures the certificate of the webserver; the webserver uses plain http if both files are empty
+type TLS struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// Watch selects the devices which are watched; changing it restarts the informers
+type Watch struct {
+	// Namespaces are the namespaces of the watched devices; an empty string selects all namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+	// LabelSelector filters the watched devices by their labels
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// ExporterConfigs enables watching the ExporterConfig objects of the namespaces, which are merged into Render
+	ExporterConfigs bool `json:"exporterConfigs,omitempty"`
+}
+
+// Render controls the output of the webserver; changing it does not restart the informers
+type Render struct {
+	// Properties is an allowlist of the twin properties which are exported; all properties are exported if it is empty
+	Properties []string `json:"properties,omitempty"`
+	// Labels is an allowlist of device labels which are added as label_<name> to every series of the device
+	Labels []string `json:"labels,omitempty"`
+	// MaxSeries limits the number of twin series per scrape; 0 disables the limit
+	MaxSeries int `json:"maxSeries,omitempty"`
+	// InfluxMeasurement selects the measurement of a twin in the InfluxDB line protocol: property (default) or model
+	InfluxMeasurement string `json:"influxMeasurement,omitempty"`
+	// Aggregate enables the kubeedge_fleet series, which aggregate the devices and twins per namespace, model and node;
+	// they are not exported if it is not set
+	Aggregate *Aggregate `json:"aggregate,omitempty"`
+	// Transforms scale and convert the values of the twin series on /metrics; the first matching transform applies
+	Transforms []Transform `json:"transforms,omitempty"`
+	// RelabelConfigs are applied to the twin series on /metrics after the transforms, like the relabel_configs of
+	// prometheus; __name__ is the name of the metric
+	RelabelConfigs []RelabelConfig `json:"relabelConfigs,omitempty"`
+	// Selections restrict the exported twins in addition to Properties; if it is not empty a twin is only exported if
+	// it matches one of the selections
+	Selections []Selection `json:"selections,omitempty"`
+	// SelectionOnly exports only the twins which are selected by Selections or Scopes; without it the twins of the
+	// namespaces without a Scope are exported if Selections is empty
+	SelectionOnly bool `json:"selectionOnly,omitempty"`
+	// Scopes are the settings of the ExporterConfigs of the cluster; they are not read from the configuration file
+	Scopes []Scope `json:"-"`
+}
+
+// Scope holds the settings of an ExporterConfig, which only apply to the twins of its namespace. Once a namespace has
+// a scope, its twins are only exported if they are selected by one of its scopes or by Render.Selections.
+type Scope struct {
+	Namespace string
+	// LabelSelector and Properties select the twins like a Selection
+	LabelSelector string
+	Properties    []string
+	// Labels, Transforms and RelabelConfigs apply after the ones of Render; the relabel configs can not change the
+	// namespace label
+	Labels         []string
+	Transforms     []Transform
+	RelabelConfigs []RelabelConfig
+	// MaxSeries limits the number of series of the twins selected by the scope; 0 disables the limit
+	MaxSeries int
+}
+
+// Selection selects twins by the namespace and the labels of their device and by their property
+type Selection struct {
+	// Namespaces are the namespaces of the devices; all namespaces are selected if it is empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// LabelSelector selects the devices by their labels
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// Properties are the selected properties; all properties are selected if it is empty
+	Properties []string `json:"properties,omitempty"`
+}
+
+// Transform changes the twin series of the matching twins; a value is scaled, then converted
+type Transform struct {
+	// Model and Property select the twins; an empty value matches every twin
+	Model    string `json:"model,omitempty"`
+	Property string `json:"property,omitempty"`
+	// Scale is the factor the value is multiplied with; the default is 1
+	Scale *float64 `json:"scale,omitempty"`
+	// Offset is added to the scaled value
+	Offset float64 `json:"offset,omitempty"`
+	// FromUnit and ToUnit convert the value between units like fahrenheit and celsius, see units.Names
+	FromUnit string `json:"fromUnit,omitempty"`
+	ToUnit   string `json:"toUnit,omitempty"`
+	// Metric renames the metric of the series
+	Metric string `json:"metric,omitempty"`
+}
+
+// RelabelConfig is a relabeling rule with the semantics of the relabel_configs of prometheus
+type RelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	// Separator joins the values of the source labels; the default is ;
+	Separator string `json:"separator,omitempty"`
+	// Regex is matched against the joined values, or the label names for labelmap, labeldrop and labelkeep;
+	// it is anchored and the default is (.*)
+	Regex       string `json:"regex,omitempty"`
+	TargetLabel string `json:"targetLabel,omitempty"`
+	// Replacement is the value of the target label, which may refer to the groups of Regex; the default is $1
+	Replacement *string `json:"replacement,omitempty"`
+	// Action is one of replace (default), keep, drop, labelmap, labeldrop or labelkeep
+	Action string `json:"action,omitempty"`
+}
+
+// Aggregate selects the fleet aggregates
+type Aggregate struct {
+	// Properties are the properties whose values are aggregated across the devices of a model; all exported
+	// numeric properties are aggregated if it is empty
+	Properties []string `json:"properties,omitempty"`
+}
+
+// RemoteWrite configures one prometheus remote-write endpoint
+type RemoteWrite struct {
+	URL string `json:"url"`
+	// Interval is the time between two pushes; the default is 30s
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// OnChange additionally pushes after every change of a device or node, at most once per second
+	OnChange bool `json:"onChange,omitempty"`
+	// Timeout limits each request; the default is 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// QueueSize is the number of pushes which are kept while the endpoint is unavailable; the default is 100
+	QueueSize int `json:"queueSize,omitempty"`
+	// ExternalLabels are added to every series unless the series already has a label of the same name
+	ExternalLabels map[string]string `json:"externalLabels,omitempty"`
+	BasicAuth      *BasicAuth        `json:"basicAuth,omitempty"`
+	BearerToken    string            `json:"bearerToken,omitempty"`
+}
+
+// Pushgateway configures the push of the metrics to a Pushgateway
+type Pushgateway struct {
+	URL string `json:"url"`
+	Job string `json:"job"`
+	// Grouping are the labels of the grouping key in addition to the job, e.g. site and cluster
+	Grouping map[string]string `json:"grouping,omitempty"`
+	// Interval is the time between two pushes; the default is 30s
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Timeout limits each request; the default is 10s
+	Timeout     metav1.Duration `json:"timeout,omitempty"`
+	BasicAuth   *BasicAuth      `json:"basicAuth,omitempty"`
+	BearerToken string          `json:"bearerToken,omitempty"`
+}
+
+// InfluxDB configures the InfluxDB write API the twins are written to in the line protocol
+type InfluxDB struct {
+	URL string `json:"url"`
+	// Version is the version of the write API: 1 or 2
+	Version int `json:"version"`
+	// Database and RetentionPolicy select the target of version 1
+	Database        string `json:"database,omitempty"`
+	RetentionPolicy string `json:"retentionPolicy,omitempty"`
+	// Org, Bucket and Token select the target of version 2
+	Org    string `json:"org,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	Token  string `json:"token,omitempty"`
+	// BasicAuth contains the user of version 1
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+	// Interval is the time between two writes; the default is 30s
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Timeout limits each request; the default is 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// OTLP configures the export of the twins to an OTLP/HTTP metrics receiver
+type OTLP struct {
+	// Endpoint is the url of the receiver including the path, e.g. http://collector:4318/v1/metrics
+	Endpoint string `json:"endpoint"`
+	// Cluster is the value of the resource attribute k8s.cluster.name
+	Cluster string `json:"cluster,omitempty"`
+	// Headers are added to every request, e.g. for authentication
+	Headers map[string]string `json:"headers,omitempty"`
+	// Interval is the time between two exports; the default is 30s
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Timeout limits each request; the default is 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// EventBus configures the connection to the MQTT broker of the KubeEdge EventBus
+type EventBus struct {
+	// Broker is the address of the broker: tcp://host:port or ssl://host:port
+	Broker   string `json:"broker"`
+	ClientID string `json:"clientID,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Namespace is the namespace of the devices, because the topics only contain the device name; the default is default
+	Namespace string `json:"namespace,omitempty"`
+	// KeepAlive is the keep alive interval of the connection; the default is 60s
+	KeepAlive metav1.Duration `json:"keepAlive,omitempty"`
+}
+
+// History configures the recent values which are kept per twin
+type History struct {
+	// MaxAge is the age after which values are removed; the default is 24h
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+	// MaxSamples is the number of values which are kept per twin; the default is 1000
+	MaxSamples int `json:"maxSamples,omitempty"`
+	// MaxBytes limits the memory of all values, the oldest values are removed first; the default is 16MiB
+	MaxBytes int `json:"maxBytes,omitempty"`
+	// File is the file the history is saved to every minute and on shutdown, and loaded from on start;
+	// the history is only kept in memory if it is empty
+	File string `json:"file,omitempty"`
+}
+
+// Convergence configures kubeedge_twin_convergence_seconds
+type Convergence struct {
+	// Timeout is the time after which a change of a desired value which has not been reported is counted as timed
+	// out; the default is 10m
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// Buckets are the upper bounds of the histogram buckets in seconds; the default is 1, 5, 15, 30, 60, 120, 300 and 600
+	Buckets []float64 `json:"buckets,omitempty"`
+}
+
+// Statistics configures the kubeedge_twin_reported series; they are disabled if Windows is empty
+type Statistics struct {
+	// Windows are the durations of the sliding windows, like 1m and 5m
+	Windows []metav1.Duration `json:"windows,omitempty"`
+	// Quantiles are the quantiles exported per window; the default is 0.5, 0.9 and 0.99
+	Quantiles []float64 `json:"quantiles,omitempty"`
+	// MaxSamples is the number of values kept per twin; older values are dropped even if they are within a window.
+	// The default is 1000
+	MaxSamples int `json:"maxSamples,omitempty"`
+}
+
+// Threshold is a rule for a twin property; a twin violates it if the reported value leaves the range of min and max
+// or differs by more than maxDivergence from the desired value for longer than for
+type Threshold struct {
+	// Name identifies the threshold; it is the alertname of the alerts
+	Name     string `json:"name"`
+	Property string `json:"property"`
+	// LabelSelector restricts the threshold to the devices with matching labels
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	Min           *float64 `json:"min,omitempty"`
+	Max           *float64 `json:"max,omitempty"`
+	MaxDivergence *float64 `json:"maxDivergence,omitempty"`
+	// For is the grace period before a twin violates the threshold
+	For      metav1.Duration `json:"for,omitempty"`
+	Severity string          `json:"severity,omitempty"`
+}
+
+// AlertWebhook configures the endpoint the alerts are posted to in the format of the Alertmanager API,
+// e.g. http://alertmanager:9093/api/v2/alerts
+type AlertWebhook struct {
+	URL string `json:"url"`
+	// RepeatInterval is the time after which firing alerts are sent again; the default is 5m
+	RepeatInterval metav1.Duration `json:"repeatInterval,omitempty"`
+	// Timeout limits each request; the default is 10s
+	Timeout     metav1.Duration `json:"timeout,omitempty"`
+	BasicAuth   *BasicAuth      `json:"basicAuth,omitempty"`
+	BearerToken string          `json:"bearerToken,omitempty"`
+}
+
+// WriteAPI authenticates the callers of the write api with bearer tokens
+type WriteAPI struct {
+	Tokens []APIToken `json:"tokens"`
+}
+
+// APIToken is the bearer token of a caller of the write api; the user is written to the audit log
+type APIToken struct {
+	User  string `json:"user"`
+	Token string `json:"token"`
+}
+
+// BasicAuth contains the credentials for http basic authentication
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+}
+
+// Default returns the configuration which is used if no configuration file is given
+func Default() Config {
+	return Config{
+		Watch: Watch{
+			Namespaces: []string{"default"},
+		},
+	}
+}
+
+// Load reads the YAML configuration file path on top of the default configuration
+func Load(path string) (Config, error) {
+	conf := Default()
+	if path == "" {
+		return conf, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return conf, fmt.Errorf("can not parse %s: %v", path, err)
+	}
+	return conf, nil
+}
+
+// Validate checks that the configuration can be used
+func (c Config) Validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("address is not set")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %v is not in the range 1-65535", c.Port)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls needs both certFile and keyFile")
+	}
+	if len(c.Watch.Namespaces) == 0 {
+		return fmt.Errorf("at least one namespace has to be watched")
+	}
+	for _, ns := range c.Watch.Namespaces {
+		if ns == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("namespace %q is invalid: %v", ns, errs)
+		}
+	}
+	if _, err := labels.Parse(c.Watch.LabelSelector); err != nil {
+		return fmt.Errorf("labelSelector is invalid: %v", err)
+	}
+	for _, prop := range c.Render.Properties {
+		if prop == "" {
+			return fmt.Errorf("render.properties contains an empty property")
+		}
+	}
+	if err := validateLabels(c.Render.Labels); err != nil {
+		return err
+	}
+	if c.Render.Aggregate != nil {
+		for _, prop := range c.Render.Aggregate.Properties {
+			if prop == "" {
+				return fmt.Errorf("render.aggregate.properties contains an empty property")
+			}
+		}
+	}
+	for i, t := range c.Render.Transforms {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("render.transforms[%v]: %v", i, err)
+		}
+	}
+	for i, s := range c.Render.Selections {
+		if _, err := labels.Parse(s.LabelSelector); err != nil {
+			return fmt.Errorf("render.selections[%v]: labelSelector is invalid: %v", i, err)
+		}
+	}
+	for i, r := range c.Render.RelabelConfigs {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("render.relabelConfigs[%v]: %v", i, err)
+		}
+	}
+	if c.Render.MaxSeries < 0 {
+		return fmt.Errorf("render.maxSeries must not be negative")
+	}
+	for _, s := range c.Render.Scopes {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("render.scopes of namespace %v: %v", s.Namespace, err)
+		}
+	}
+	switch c.Render.InfluxMeasurement {
+	case "", "property", "model":
+	default:
+		return fmt.Errorf("render.influxMeasurement must be property or model")
+	}
+	for i, rw := range c.RemoteWrite {
+		if err := rw.validate(); err != nil {
+			return fmt.Errorf("remoteWrite[%v]: %v", i, err)
+		}
+	}
+	if c.Pushgateway != nil {
+		if err := c.Pushgateway.validate(); err != nil {
+			return fmt.Errorf("pushgateway: %v", err)
+		}
+	}
+	if c.InfluxDB != nil {
+		if err := c.InfluxDB.validate(); err != nil {
+			return fmt.Errorf("influxDB: %v", err)
+		}
+	}
+	if c.EventBus != nil {
+		u, err := url.Parse(c.EventBus.Broker)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("eventBus: broker %q is not a valid url", c.EventBus.Broker)
+		}
+		switch u.Scheme {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts":
+		default:
+			return fmt.Errorf("eventBus: the scheme of the broker must be tcp or ssl")
+		}
+		if c.EventBus.KeepAlive.Duration < 0 {
+			return fmt.Errorf("eventBus: keepAlive must not be negative")
+		}
+	}
+	if c.History.MaxAge.Duration < 0 || c.History.MaxSamples < 0 || c.History.MaxBytes < 0 {
+		return fmt.Errorf("history: maxAge, maxSamples and maxBytes must not be negative")
+	}
+	if c.Convergence.Timeout.Duration < 0 {
+		return fmt.Errorf("convergence: timeout must not be negative")
+	}
+	for i, b := range c.Convergence.Buckets {
+		if b <= 0 || i > 0 && b <= c.Convergence.Buckets[i-1] {
+			return fmt.Errorf("convergence: buckets must be positive and increasing")
+		}
+	}
+	for _, w := range c.Statistics.Windows {
+		if w.Duration <= 0 {
+			return fmt.Errorf("statistics: windows must be positive")
+		}
+	}
+	for _, q := range c.Statistics.Quantiles {
+		if q < 0 || q > 1 {
+			return fmt.Errorf("statistics: quantile %v is not between 0 and 1", q)
+		}
+	}
+	if c.Statistics.MaxSamples < 0 {
+		return fmt.Errorf("statistics: maxSamples must not be negative")
+	}
+	names := make(map[string]bool, len(c.Thresholds))
+	for i, t := range c.Thresholds {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("thresholds[%v]: %v", i, err)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("thresholds[%v]: name %q is not unique", i, t.Name)
+		}
+		names[t.Name] = true
+	}
+	if c.AlertWebhook != nil {
+		if err := c.AlertWebhook.validate(); err != nil {
+			return fmt.Errorf("alertWebhook: %v", err)
+		}
+	}
+	if c.WriteAPI != nil {
+		if err := c.WriteAPI.validate(); err != nil {
+			return fmt.Errorf("writeAPI: %v", err)
+		}
+		if c.TLS.CertFile == "" {
+			return fmt.Errorf("writeAPI: tls has to be configured, the bearer tokens would be sent in plain text")
+		}
+	}
+	if c.OTLP != nil {
+		if err := validateURL(c.OTLP.Endpoint); err != nil {
+			return fmt.Errorf("otlp: %v", err)
+		}
+		if c.OTLP.Interval.Duration < 0 || c.OTLP.Timeout.Duration < 0 {
+			return fmt.Errorf("otlp: interval and timeout must not be negative")
+		}
+	}
+	return nil
+}
+
+func (rw RemoteWrite) validate() error {
+	if err := validateURL(rw.URL); err != nil {
+		return err
+	}
+	if rw.Interval.Duration < 0 || rw.Timeout.Duration < 0 || rw.QueueSize < 0 {
+		return fmt.Errorf("interval, timeout and queueSize must not be negative")
+	}
+	for name := range rw.ExternalLabels {
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("external label %q is not a valid prometheus label name", name)
+		}
+	}
+	if rw.BasicAuth != nil && rw.BearerToken != "" {
+		return fmt.Errorf("basicAuth and bearerToken are mutually exclusive")
+	}
+	return nil
+}
+
+func (p Pushgateway) validate() error {
+	if err := validateURL(p.URL); err != nil {
+		return err
+	}
+	if p.Job == "" {
+		return fmt.Errorf("job is not set")
+	}
+	if p.Interval.Duration < 0 || p.Timeout.Duration < 0 {
+		return fmt.Errorf("interval and timeout must not be negative")
+	}
+	for name := range p.Grouping {
+		if !labelName.MatchString(name) || name == "job" {
+			return fmt.Errorf("grouping label %q is not a valid prometheus label name other than job", name)
+		}
+	}
+	if p.BasicAuth != nil && p.BearerToken != "" {
+		return fmt.Errorf("basicAuth and bearerToken are mutually exclusive")
+	}
+	return nil
+}
+
+func (t Transform) validate() error {
+	if (t.FromUnit == "") != (t.ToUnit == "") {
+		return fmt.Errorf("fromUnit and toUnit have to be set together")
+	}
+	if t.FromUnit != "" {
+		if _, err := units.Converter(t.FromUnit, t.ToUnit); err != nil {
+			return err
+		}
+	}
+	if t.Metric != "" && !metricName.MatchString(t.Metric) {
+		return fmt.Errorf("metric %q is not a valid metric name", t.Metric)
+	}
+	return nil
+}
+
+// Validate checks the settings of an ExporterConfig; the errors name the fields of the ExporterConfig
+func (s Scope) Validate() error {
+	if _, err := labels.Parse(s.LabelSelector); err != nil {
+		return fmt.Errorf("labelSelector is invalid: %v", err)
+	}
+	for _, prop := range s.Properties {
+		if prop == "" {
+			return fmt.Errorf("properties contains an empty property")
+		}
+	}
+	if err := validateLabels(s.Labels); err != nil {
+		return err
+	}
+	for i, t := range s.Transforms {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("transforms[%v]: %v", i, err)
+		}
+	}
+	for i, r := range s.RelabelConfigs {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("relabelConfigs[%v]: %v", i, err)
+		}
+	}
+	if s.MaxSeries < 0 {
+		return fmt.Errorf("maxSeries must not be negative")
+	}
+	return nil
+}
+
+func validateLabels(names []string) error {
+	for _, label := range names {
+		if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+			return fmt.Errorf("label %q is invalid: %v", label, errs)
+		}
+	}
+	return nil
+}
+
+func (r RelabelConfig) validate() error {
+	if _, err := regexp.Compile("^(?:" + r.Regex + ")$"); err != nil {
+		return fmt.Errorf("regex is invalid: %v", err)
+	}
+	for _, label := range r.SourceLabels {
+		if !labelName.MatchString(label) {
+			return fmt.Errorf("source label %q is not a valid label name", label)
+		}
+	}
+	switch r.Action {
+	case "", "replace":
+		if r.TargetLabel == "" {
+			return fmt.Errorf("replace needs a targetLabel")
+		}
+	case "keep", "drop":
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("%s needs sourceLabels", r.Action)
+		}
+	case "labelmap", "labeldrop", "labelkeep":
+	default:
+		return fmt.Errorf("action %q is unknown", r.Action)
+	}
+	return nil
+}
+
+func (t Threshold) validate() error {
+	if t.Name == "" || t.Property == "" {
+		return fmt.Errorf("name and property have to be set")
+	}
+	if t.Min == nil && t.Max == nil && t.MaxDivergence == nil {
+		return fmt.Errorf("at least one of min, max and maxDivergence has to be set")
+	}
+	if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+		return fmt.Errorf("min is greater than max")
+	}
+	if t.MaxDivergence != nil && *t.MaxDivergence < 0 {
+		return fmt.Errorf("maxDivergence must not be negative")
+	}
+	if t.For.Duration < 0 {
+		return fmt.Errorf("for must not be negative")
+	}
+	if _, err := labels.Parse(t.LabelSelector); err != nil {
+		return fmt.Errorf("labelSelector is invalid: %v", err)
+	}
+	return nil
+}
+
+func (a AlertWebhook) validate() error {
+	if err := validateURL(a.URL); err != nil {
+		return err
+	}
+	if a.RepeatInterval.Duration < 0 || a.Timeout.Duration < 0 {
+		return fmt.Errorf("repeatInterval and timeout must not be negative")
+	}
+	if a.BasicAuth != nil && a.BearerToken != "" {
+		return fmt.Errorf("basicAuth and bearerToken are mutually exclusive")
+	}
+	return nil
+}
+
+func (w WriteAPI) validate() error {
+	if len(w.Tokens) == 0 {
+		return fmt.Errorf("at least one token is required")
+	}
+	tokens := make(map[string]bool, len(w.Tokens))
+	for i, t := range w.Tokens {
+		if t.User == "" || t.Token == "" {
+			return fmt.Errorf("tokens[%v]: user and token have to be set", i)
+		}
+		if tokens[t.Token] {
+			return fmt.Errorf("tokens[%v]: the token of %s is not unique", i, t.User)
+		}
+		tokens[t.Token] = true
+	}
+	return nil
+}
+
+func (i InfluxDB) validate() error {
+	if err := validateURL(i.URL); err != nil {
+		return err
+	}
+	switch i.Version {
+	case 1:
+		if i.Database == "" {
+			return fmt.Errorf("version 1 needs a database")
+		}
+	case 2:
+		if i.Org == "" || i.Bucket == "" {
+			return fmt.Errorf("version 2 needs an org and a bucket")
+		}
+	default:
+		return fmt.Errorf("version must be 1 or 2")
+	}
+	if i.Interval.Duration < 0 || i.Timeout.Duration < 0 {
+		return fmt.Errorf("interval and timeout must not be negative")
+	}
+	if i.BasicAuth != nil && i.Token != "" {
+		return fmt.Errorf("basicAuth and token are mutually exclusive")
+	}
+	return nil
+}
+
+// validateURL checks that raw is an absolute http or https url
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("url is invalid: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url %q is not an absolute http or https url", raw)
+	}
+	return nil
+}
+
+// Listen returns the listen address of the webserver
+func (c Config) Listen() string {
+	return c.Address + ":" + strconv.Itoa(c.Port)
+}
+
+// ClientsChanged reports whether switching from c to n changes one of the targets the metrics are pushed to,
+// the EventBus, the history, the thresholds, the write api, the convergence histogram or the statistics; they are
+// only applied after a restart
+func (c Config) ClientsChanged(n Config) bool {
+	return !reflect.DeepEqual(c.RemoteWrite, n.RemoteWrite) || !reflect.DeepEqual(c.Pushgateway, n.Pushgateway) ||
+		!reflect.DeepEqual(c.InfluxDB, n.InfluxDB) || !reflect.DeepEqual(c.OTLP, n.OTLP) ||
+		!reflect.DeepEqual(c.EventBus, n.EventBus) || c.History != n.History ||
+		!reflect.DeepEqual(c.Thresholds, n.Thresholds) || !reflect.DeepEqual(c.AlertWebhook, n.AlertWebhook) ||
+		!reflect.DeepEqual(c.WriteAPI, n.WriteAPI) || !reflect.DeepEqual(c.Convergence, n.Convergence) ||
+		!reflect.DeepEqual(c.Statistics, n.Statistics)
+}
+
+// NeedsRestart reports whether switching from c to n requires a restart of the webserver
+func (c Config) NeedsRestart(n Config) bool {
+	return c.Address != n.Address || c.Port != n.Port || c.TLS != n.TLS
+}
+
+// WatchChanged reports whether switching from c to n requires a restart of the informers
+func (c Config) WatchChanged(n Config) bool {
+	return c.Server != n.Server || c.KubeConfig != n.KubeConfig || !reflect.DeepEqual(c.Watch, n.Watch)
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// valid returns a configuration which passes Validate
+func valid() Config {
+	conf := Default()
+	conf.Address = "0.0.0.0"
+	conf.Port = 8080
+	return conf
+}
+
+func TestValidate(t *testing.T) {
+	negative := -1.0
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		err    string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"all namespaces", func(c *Config) { c.Watch.Namespaces = []string{""} }, ""},
+		{"no address", func(c *Config) { c.Address = "" }, "address is not set"},
+		{"port", func(c *Config) { c.Port = 70000 }, "port 70000"},
+		{"tls without key", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "tls needs both"},
+		{"no namespace", func(c *Config) { c.Watch.Namespaces = nil }, "at least one namespace"},
+		{"namespace", func(c *Config) { c.Watch.Namespaces = []string{"Plant_A"} }, `namespace "Plant_A"`},
+		{"label selector", func(c *Config) { c.Watch.LabelSelector = "site in (" }, "labelSelector is invalid"},
+		{"empty property", func(c *Config) { c.Render.Properties = []string{""} }, "render.properties"},
+		{"label", func(c *Config) { c.Render.Labels = []string{"-site"} }, `label "-site"`},
+		{"transform units", func(c *Config) { c.Render.Transforms = []Transform{{FromUnit: "celsius"}} }, "render.transforms[0]: fromUnit and toUnit"},
+		{"transform metric", func(c *Config) { c.Render.Transforms = []Transform{{Metric: "1temperature"}} }, "render.transforms[0]: metric"},
+		{"selection", func(c *Config) { c.Render.Selections = []Selection{{LabelSelector: "!"}} }, "render.selections[0]"},
+		{"relabel action", func(c *Config) { c.Render.RelabelConfigs = []RelabelConfig{{Action: "hashmod"}} }, `render.relabelConfigs[0]: action "hashmod"`},
+		{"relabel regex", func(c *Config) { c.Render.RelabelConfigs = []RelabelConfig{{Action: "labeldrop", Regex: "("}} }, "render.relabelConfigs[0]: regex"},
+		{"relabel target", func(c *Config) { c.Render.RelabelConfigs = []RelabelConfig{{SourceLabels: []string{"site"}}} }, "needs a targetLabel"},
+		{"max series", func(c *Config) { c.Render.MaxSeries = -1 }, "render.maxSeries"},
+		{"scope", func(c *Config) { c.Render.Scopes = []Scope{{Namespace: "plant-a", MaxSeries: -1}} }, "render.scopes of namespace plant-a: maxSeries"},
+		{"influx measurement", func(c *Config) { c.Render.InfluxMeasurement = "device" }, "render.influxMeasurement"},
+		{"remote write url", func(c *Config) { c.RemoteWrite = []RemoteWrite{{URL: "prometheus:9090"}} }, "remoteWrite[0]: url"},
+		{"remote write auth", func(c *Config) {
+			c.RemoteWrite = []RemoteWrite{{URL: "http://prometheus", BasicAuth: &BasicAuth{Username: "user"}, BearerToken: "token"}}
+		}, "mutually exclusive"},
+		{"pushgateway job", func(c *Config) { c.Pushgateway = &Pushgateway{URL: "http://pushgateway"} }, "pushgateway: job is not set"},
+		{"pushgateway grouping", func(c *Config) {
+			c.Pushgateway = &Pushgateway{URL: "http://pushgateway", Job: "edge", Grouping: map[string]string{"job": "x"}}
+		}, "grouping label"},
+		{"influx version", func(c *Config) { c.InfluxDB = &InfluxDB{URL: "http://influx"} }, "influxDB: version"},
+		{"influx database", func(c *Config) { c.InfluxDB = &InfluxDB{URL: "http://influx", Version: 1} }, "needs a database"},
+		{"eventbus scheme", func(c *Config) { c.EventBus = &EventBus{Broker: "http://broker:1883"} }, "eventBus: the scheme"},
+		{"history", func(c *Config) { c.History.MaxSamples = -1 }, "history:"},
+		{"convergence buckets", func(c *Config) { c.Convergence.Buckets = []float64{5, 1} }, "increasing"},
+		{"statistics window", func(c *Config) { c.Statistics.Windows = []metav1.Duration{{}} }, "windows must be positive"},
+		{"statistics quantile", func(c *Config) { c.Statistics.Quantiles = []float64{1.5} }, "quantile 1.5"},
+		{"threshold range", func(c *Config) {
+			c.Thresholds = []Threshold{{Name: "hot", Property: "temperature", MaxDivergence: &negative}}
+		}, "thresholds[0]: maxDivergence"},
+		{"threshold name", func(c *Config) {
+			c.Thresholds = []Threshold{{Name: "hot", Property: "temperature", Min: &negative}, {Name: "hot", Property: "humidity", Min: &negative}}
+		}, `thresholds[1]: name "hot"`},
+		{"alert webhook", func(c *Config) { c.AlertWebhook = &AlertWebhook{URL: "alertmanager"} }, "alertWebhook: url"},
+		{"write api tokens", func(c *Config) {
+			c.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem"}
+			c.WriteAPI = &WriteAPI{Tokens: []APIToken{{User: "a", Token: "t"}, {User: "b", Token: "t"}}}
+		}, "the token of b is not unique"},
+		{"write api without tls", func(c *Config) { c.WriteAPI = &WriteAPI{Tokens: []APIToken{{User: "a", Token: "t"}}} }, "tls has to be configured"},
+		{"otlp", func(c *Config) { c.OTLP = &OTLP{Endpoint: "collector:4318"} }, "otlp: url"},
+	}
+
+	for _, test := range tests {
+		conf := valid()
+		test.modify(&conf)
+		err := conf.Validate()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("can not create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf, err := Load("")
+	if err != nil || len(conf.Watch.Namespaces) != 1 || conf.Watch.Namespaces[0] != "default" {
+		t.Errorf("got %+v, %v without a file, want the default", conf.Watch, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("port: 9100\nwatch:\n  labelSelector: site=a\n"), 0644); err != nil {
+		t.Fatalf("can not write file: %v", err)
+	}
+	conf, err = Load(path)
+	if err != nil || conf.Port != 9100 || conf.Watch.LabelSelector != "site=a" || len(conf.Watch.Namespaces) != 1 {
+		t.Errorf("got %+v, %v; want the file on top of the default", conf, err)
+	}
+
+	// unknown keys are rejected, so typos are not silently ignored
+	if err := ioutil.WriteFile(path, []byte("port: 9100\nwatch:\n  namespace: [plant-a]\n"), 0644); err != nil {
+		t.Fatalf("can not write file: %v", err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "namespace") {
+		t.Errorf("got %v for an unknown key, want an error", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("got no error for a missing file")
+	}
+}
+
+func TestChanged(t *testing.T) {
+	tests := []struct {
+		name                                       string
+		modify                                     func(c *Config)
+		needsRestart, watchChanged, clientsChanged bool
+	}{
+		{"nothing", func(c *Config) {}, false, false, false},
+		{"port", func(c *Config) { c.Port = 9100 }, true, false, false},
+		{"tls", func(c *Config) { c.TLS.CertFile = "cert.pem" }, true, false, false},
+		{"server", func(c *Config) { c.Server = "https://master" }, false, true, false},
+		{"namespaces", func(c *Config) { c.Watch.Namespaces = []string{"plant-a"} }, false, true, false},
+		{"exporter configs", func(c *Config) { c.Watch.ExporterConfigs = true }, false, true, false},
+		{"render", func(c *Config) { c.Render.MaxSeries = 10 }, false, false, false},
+		{"remote write", func(c *Config) { c.RemoteWrite = []RemoteWrite{{URL: "http://prometheus"}} }, false, false, true},
+		{"history", func(c *Config) { c.History.MaxAge = metav1.Duration{Duration: time.Hour} }, false, false, true},
+		{"statistics", func(c *Config) { c.Statistics.Quantiles = []float64{0.5} }, false, false, true},
+	}
+
+	for _, test := range tests {
+		old, next := valid(), valid()
+		test.modify(&next)
+		if got := old.NeedsRestart(next); got != test.needsRestart {
+			t.Errorf("%s: NeedsRestart is %v", test.name, got)
+		}
+		if got := old.WatchChanged(next); got != test.watchChanged {
+			t.Errorf("%s: WatchChanged is %v", test.name, got)
+		}
+		if got := old.ClientsChanged(next); got != test.clientsChanged {
+			t.Errorf("%s: ClientsChanged is %v", test.name, got)
+		}
+	}
+}
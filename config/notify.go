@@ -0,0 +1,52 @@
+package config
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Notify sends on the returned channel whenever the process receives SIGHUP or the modification time of path changes;
+// the file is checked every interval
+func Notify(path string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	trigger := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		var modTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+				log.Printf("received SIGHUP; reload configuration")
+				trigger()
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					log.Printf("can not stat configuration file %s; err is: %v", path, err)
+					continue
+				}
+				if !info.ModTime().Equal(modTime) {
+					modTime = info.ModTime()
+					log.Printf("configuration file %s changed; reload configuration", path)
+					trigger()
+				}
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatalf("can not create file: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	changed := Notify(file.Name(), 10*time.Millisecond)
+	expect := func(what string, want bool) {
+		select {
+		case <-changed:
+			if !want {
+				t.Errorf("got a notification %s", what)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Errorf("got no notification %s", what)
+			}
+		}
+	}
+
+	expect("without a change", false)
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file.Name(), future, future); err != nil {
+		t.Fatalf("can not change the modification time: %v", err)
+	}
+	expect("after the file changed", true)
+	expect("twice for one change", false)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("can not send SIGHUP: %v", err)
+	}
+	expect("after SIGHUP", true)
+}
@@ -0,0 +1,184 @@
+// Package eventbus receives twin updates directly from the MQTT broker of the KubeEdge EventBus on an edge node,
+// without the delay of the synchronisation of the Device objects by the cloud
+package eventbus
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+const (
+	defaultKeepAlive = 60 * time.Second
+	dialTimeout      = 10 * time.Second
+	minBackoff       = time.Second
+	maxBackoff       = 30 * time.Second
+)
+
+// TwinSink receives the twins of the devices and the names of the deleted twins, like prometheus.Exporter
+type TwinSink interface {
+	UpdateTwins(namespace string, device string, twins []typ.Twin, deleted []string)
+}
+
+// Source subscribes to the twin messages of a broker
+type Source struct {
+	conf      config.EventBus
+	keepAlive time.Duration
+	dial      func() (net.Conn, error)
+}
+
+// New creates a Source; conf has to be validated by config.Config.Validate
+func New(conf config.EventBus) *Source {
+	s := &Source{conf: conf, keepAlive: conf.KeepAlive.Duration}
+	if s.keepAlive == 0 {
+		s.keepAlive = defaultKeepAlive
+	}
+	if s.conf.Namespace == "" {
+		s.conf.Namespace = "default"
+	}
+	if s.conf.ClientID == "" {
+		host, _ := os.Hostname()
+		s.conf.ClientID = fmt.Sprintf("cpu-kubeedge-exporter-%s-%d", host, os.Getpid())
+	}
+
+	u, _ := url.Parse(conf.Broker)
+	s.dial = func() (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		switch u.Scheme {
+		case "ssl", "tls", "mqtts":
+			return tls.DialWithDialer(dialer, "tcp", u.Host, &tls.Config{ServerName: u.Hostname()})
+		}
+		return dialer.Dial("tcp", u.Host)
+	}
+	return s
+}
+
+// Run passes the received twins to sink until stop is closed; lost connections are reestablished
+func (s *Source) Run(sink TwinSink, stop <-chan struct{}) {
+	backoff := minBackoff
+	for {
+		connected, err := s.serve(sink, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if connected {
+			backoff = minBackoff
+		}
+		log.Printf("connection to the eventbus %s failed, reconnect in %v; err is: %v", s.conf.Broker, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// serve connects to the broker and handles the messages until the connection fails or stop is closed;
+// connected reports whether the broker has accepted the connection
+func (s *Source) serve(sink TwinSink, stop <-chan struct{}) (connected bool, err error) {
+	nc, err := s.dial()
+	if err != nil {
+		return false, err
+	}
+	c := newConn(nc)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			c.writePacket(packet{typ: packetDisconnect})
+		case <-done:
+		}
+		c.Close()
+	}()
+
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.writePacket(connectPacket(s.conf.ClientID, s.conf.Username, s.conf.Password, s.keepAlive)); err != nil {
+		return false, err
+	}
+	p, err := c.readPacket()
+	if err != nil {
+		return false, err
+	}
+	if p.typ != packetConnack || len(p.body) != 2 {
+		return false, fmt.Errorf("expected connack, got packet type %v", p.typ)
+	}
+	if err := connackError(p.body[1]); err != nil {
+		return false, err
+	}
+	if err := c.writePacket(subscribePacket(1, Topics)); err != nil {
+		return true, err
+	}
+	c.SetDeadline(time.Time{})
+	log.Printf("connected to the eventbus %s", s.conf.Broker)
+
+	go s.ping(c, done)
+	for {
+		// the broker answers the pings, so a silent connection is dead
+		c.SetReadDeadline(time.Now().Add(s.keepAlive * 3 / 2))
+		p, err := c.readPacket()
+		if err != nil {
+			return true, err
+		}
+		switch p.typ {
+		case packetPublish:
+			pub, err := parsePublish(p)
+			if err != nil {
+				return true, err
+			}
+			if pub.qos > 0 {
+				if err := c.writePacket(packet{typ: packetPuback, body: []byte{byte(pub.id >> 8), byte(pub.id)}}); err != nil {
+					return true, err
+				}
+			}
+			device, twins, deleted, err := decode(pub.topic, pub.payload)
+			if err != nil {
+				log.Printf("can not decode the message on %s; err is: %v", pub.topic, err)
+				continue
+			}
+			if len(twins) > 0 || len(deleted) > 0 {
+				sink.UpdateTwins(s.conf.Namespace, device, twins, deleted)
+			}
+		case packetSuback:
+			if len(p.body) < 2 {
+				return true, fmt.Errorf("malformed suback")
+			}
+			for _, code := range p.body[2:] {
+				if code == 0x80 {
+					return true, fmt.Errorf("the broker rejected the subscription of %v", Topics)
+				}
+			}
+		case packetPingresp:
+		default:
+			log.Printf("ignore unexpected packet type %v from the eventbus", p.typ)
+		}
+	}
+}
+
+// ping sends a ping every half keep alive interval until done is closed
+func (s *Source) ping(c *conn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writePacket(packet{typ: packetPingreq}); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
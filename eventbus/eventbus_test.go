@@ -0,0 +1,174 @@
+package eventbus
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+const document = `{"event_id":"1","timestamp":1561975200000,"twin":{
+"temperature":{"last":null,"current":{"expected":{"value":"20","metadata":{"timestamp":1561975100000}},"actual":{"value":"21"},"metadata":{"type":"int"}}},
+"removed":{"last":{"actual":{"value":"1"}},"current":null}}}`
+
+func TestDecode(t *testing.T) {
+	device, twins, deleted, err := decode("$hw/events/device/sensor-tag01/twin/update/document", []byte(document))
+	if err != nil {
+		t.Fatalf("can not decode document: %v", err)
+	}
+	want := []typ.Twin{{
+		Name:    "temperature",
+		Actual:  typ.TwinValue{Value: "21", Metadata: map[string]string{"type": "int", "timestamp": "1561975200000"}},
+		Desired: typ.TwinValue{Value: "20", Metadata: map[string]string{"type": "int", "timestamp": "1561975100000"}},
+	}}
+	if device != "sensor-tag01" || !reflect.DeepEqual(twins, want) || !reflect.DeepEqual(deleted, []string{"removed"}) {
+		t.Errorf("got %v %+v %v, want sensor-tag01 %+v [removed]", device, twins, deleted, want)
+	}
+
+	_, twins, deleted, err = decode("$hw/events/device/counter/twin/update", []byte(`{"twin":{"count":{"actual":{"value":"43","metadata":{"timestamp":5}}},"old":null}}`))
+	want = []typ.Twin{{Name: "count", Actual: typ.TwinValue{Value: "43", Metadata: map[string]string{"timestamp": "5"}}}}
+	if err != nil || !reflect.DeepEqual(twins, want) || !reflect.DeepEqual(deleted, []string{"old"}) {
+		t.Errorf("got %+v %v, %v, want %+v [old]", twins, deleted, err, want)
+	}
+
+	for _, topic := range []string{"$hw/events/node/edge/membership/get", "$hw/events/device//twin/update", "$hw/events/device/a/twin/update/delta"} {
+		if _, _, _, err := decode(topic, []byte("{}")); err == nil {
+			t.Errorf("topic %s was accepted", topic)
+		}
+	}
+}
+
+type sink struct {
+	mutex   sync.Mutex
+	updates []string
+	twins   []typ.Twin
+}
+
+func (s *sink) UpdateTwins(namespace string, device string, twins []typ.Twin, deleted []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updates = append(s.updates, namespace+"/"+device)
+	s.twins = append(s.twins, twins...)
+}
+
+// broker accepts one client, acknowledges its subscription, publishes payload with QoS 1 and reports the received
+// packets on packets
+func broker(l net.Listener, topic string, payload []byte, packets chan<- packet) {
+	nc, err := l.Accept()
+	if err != nil {
+		return
+	}
+	c := newConn(nc)
+	defer c.Close()
+	for {
+		p, err := c.readPacket()
+		if err != nil {
+			return
+		}
+		packets <- p
+		switch p.typ {
+		case packetConnect:
+			c.writePacket(packet{typ: packetConnack, body: []byte{0, 0}})
+		case packetSubscribe:
+			c.writePacket(packet{typ: packetSuback, body: []byte{p.body[0], p.body[1], 1, 1, 1}})
+			body := appendString(nil, topic)
+			body = append(body, 0, 7)
+			c.writePacket(packet{typ: packetPublish, flags: 1 << 1, body: append(body, payload...)})
+		case packetPingreq:
+			c.writePacket(packet{typ: packetPingresp})
+		}
+	}
+}
+
+func TestSourceReceivesTwins(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen: %v", err)
+	}
+	defer l.Close()
+	packets := make(chan packet, 10)
+	go broker(l, "$hw/events/device/sensor-tag01/twin/update/document", []byte(document), packets)
+
+	s := New(config.EventBus{
+		Broker:    "tcp://" + l.Addr().String(),
+		ClientID:  "test",
+		Username:  "edge",
+		Password:  "secret",
+		Namespace: "plant-a",
+		KeepAlive: metav1.Duration{Duration: 10 * time.Second},
+	})
+	recv := &sink{}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(recv, stop)
+		close(done)
+	}()
+
+	timeout := time.After(10 * time.Second)
+	var got []packet
+	for len(got) < 3 {
+		select {
+		case p := <-packets:
+			got = append(got, p)
+		case <-timeout:
+			t.Fatalf("the broker received only %v packets", len(got))
+		}
+	}
+	if got[0].typ != packetConnect || !reflect.DeepEqual(got[0], connectPacket("test", "edge", "secret", 10*time.Second)) {
+		t.Errorf("unexpected connect packet %+v", got[0])
+	}
+	if got[1].typ != packetSubscribe || !reflect.DeepEqual(got[1], subscribePacket(1, Topics)) {
+		t.Errorf("unexpected subscribe packet %+v", got[1])
+	}
+	if got[2].typ != packetPuback || !reflect.DeepEqual(got[2].body, []byte{0, 7}) {
+		t.Errorf("the publish was not acknowledged: %+v", got[2])
+	}
+
+	close(stop)
+	<-done
+	select {
+	case p := <-packets:
+		if p.typ != packetDisconnect {
+			t.Errorf("expected disconnect, got packet type %v", p.typ)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("the client did not disconnect")
+	}
+
+	recv.mutex.Lock()
+	defer recv.mutex.Unlock()
+	if len(recv.updates) != 1 || recv.updates[0] != "plant-a/sensor-tag01" || len(recv.twins) != 1 || recv.twins[0].Actual.Value != "21" {
+		t.Errorf("unexpected updates %v with twins %+v", recv.updates, recv.twins)
+	}
+}
+
+func TestServeReportsRefusedConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen: %v", err)
+	}
+	defer l.Close()
+
+	s := New(config.EventBus{Broker: "tcp://" + l.Addr().String()})
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := newConn(nc)
+		c.readPacket()
+		c.writePacket(packet{typ: packetConnack, body: []byte{0, 5}})
+		c.Close()
+	}()
+	connected, err := s.serve(&sink{}, make(chan struct{}))
+	if connected || err == nil || err.Error() != "not authorized" {
+		t.Errorf("got %v, %v, want a refused connection", connected, err)
+	}
+}
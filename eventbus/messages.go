@@ -0,0 +1,140 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// The messages below are the twin messages of the KubeEdge device twin module on the EventBus
+
+const topicPrefix = "$hw/events/device/"
+
+// Topics are the topics of the twin messages the Source subscribes to
+var Topics = []string{
+	topicPrefix + "+/twin/update",
+	topicPrefix + "+/twin/update/document",
+	topicPrefix + "+/twin/get/result",
+}
+
+type baseMessage struct {
+	EventID string `json:"event_id"`
+	// Timestamp is the time of the message in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+type valueMetadata struct {
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+type twinValue struct {
+	Value    *string        `json:"value,omitempty"`
+	Metadata *valueMetadata `json:"metadata,omitempty"`
+}
+
+type typeMetadata struct {
+	Type string `json:"type,omitempty"`
+}
+
+type msgTwin struct {
+	Expected *twinValue    `json:"expected,omitempty"`
+	Actual   *twinValue    `json:"actual,omitempty"`
+	Metadata *typeMetadata `json:"metadata,omitempty"`
+}
+
+// twinUpdate is sent on twin/update and twin/get/result
+type twinUpdate struct {
+	baseMessage
+	Twin map[string]*msgTwin `json:"twin"`
+}
+
+type twinDoc struct {
+	LastState    *msgTwin `json:"last"`
+	CurrentState *msgTwin `json:"current"`
+}
+
+// twinDocument is sent on twin/update/document after a twin has changed
+type twinDocument struct {
+	baseMessage
+	Twin map[string]*twinDoc `json:"twin"`
+}
+
+// decode returns the device of topic, the twins of payload and the names of the twins which have been deleted, both
+// sorted by name. Values without timestamp get the timestamp of the message.
+func decode(topic string, payload []byte) (string, []typ.Twin, []string, error) {
+	if !strings.HasPrefix(topic, topicPrefix) {
+		return "", nil, nil, fmt.Errorf("unexpected topic %s", topic)
+	}
+	parts := strings.SplitN(topic[len(topicPrefix):], "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, nil, fmt.Errorf("unexpected topic %s", topic)
+	}
+	device := parts[0]
+
+	var base baseMessage
+	twins := make(map[string]*msgTwin)
+	switch parts[1] {
+	case "twin/update", "twin/get/result":
+		var msg twinUpdate
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return "", nil, nil, err
+		}
+		base, twins = msg.baseMessage, msg.Twin
+	case "twin/update/document":
+		var msg twinDocument
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return "", nil, nil, err
+		}
+		base = msg.baseMessage
+		for name, doc := range msg.Twin {
+			if doc != nil {
+				twins[name] = doc.CurrentState
+			}
+		}
+	default:
+		return "", nil, nil, fmt.Errorf("unexpected topic %s", topic)
+	}
+
+	var ret []typ.Twin
+	var deleted []string
+	for name, twin := range twins {
+		if twin == nil {
+			deleted = append(deleted, name)
+			continue
+		}
+		var valueType string
+		if twin.Metadata != nil {
+			valueType = twin.Metadata.Type
+		}
+		ret = append(ret, typ.Twin{
+			Name:    name,
+			Actual:  convertValue(twin.Actual, valueType, base.Timestamp),
+			Desired: convertValue(twin.Expected, valueType, base.Timestamp),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	sort.Strings(deleted)
+	return device, ret, deleted, nil
+}
+
+// convertValue converts v into the model of the Device status; the zero value means that v is not part of the message
+func convertValue(v *twinValue, valueType string, timestamp int64) typ.TwinValue {
+	if v == nil || v.Value == nil {
+		return typ.TwinValue{}
+	}
+	if v.Metadata != nil && v.Metadata.Timestamp != 0 {
+		timestamp = v.Metadata.Timestamp
+	}
+	ret := typ.TwinValue{Value: *v.Value, Metadata: map[string]string{}}
+	if valueType != "" {
+		ret.Metadata["type"] = valueType
+	}
+	if timestamp != 0 {
+		ret.Metadata["timestamp"] = strconv.FormatInt(timestamp, 10)
+	}
+	return ret
+}
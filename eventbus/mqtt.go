@@ -0,0 +1,189 @@
+package eventbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The client implements the subset of MQTT 3.1.1 which is needed to subscribe to topics with QoS 0 and 1
+
+const (
+	packetConnect      = 1
+	packetConnack      = 2
+	packetPublish      = 3
+	packetPuback       = 4
+	packetSubscribe    = 8
+	packetSuback       = 9
+	packetPingreq      = 12
+	packetPingresp     = 13
+	packetDisconnect   = 14
+	maxRemainingLength = 268435455
+)
+
+// packet is a control packet; flags are the lower four bits of the fixed header
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// conn is a connection to a broker
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	// mutex serialises the writers
+	mutex sync.Mutex
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{Conn: c, reader: bufio.NewReader(c)}
+}
+
+func (c *conn) writePacket(p packet) error {
+	if len(p.body) > maxRemainingLength {
+		return fmt.Errorf("packet of %v bytes is too large", len(p.body))
+	}
+	buf := []byte{p.typ<<4 | p.flags}
+	n := len(p.body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, p.body...)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.Write(buf)
+	return err
+}
+
+func (c *conn) readPacket() (packet, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, fmt.Errorf("malformed remaining length")
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return packet{}, err
+	}
+	return packet{typ: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a length prefixed string from b and returns it and the rest of b
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("truncated string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, fmt.Errorf("truncated string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// connectPacket returns the CONNECT packet of a clean session
+func connectPacket(clientID, username, password string, keepAlive time.Duration) packet {
+	body := appendString(nil, "MQTT")
+	flags := byte(0x02)
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	seconds := int(keepAlive / time.Second)
+	body = append(body, 4, flags, byte(seconds>>8), byte(seconds))
+	body = appendString(body, clientID)
+	if username != "" {
+		body = appendString(body, username)
+		if password != "" {
+			body = appendString(body, password)
+		}
+	}
+	return packet{typ: packetConnect, body: body}
+}
+
+// subscribePacket returns the SUBSCRIBE packet for topics with QoS 1
+func subscribePacket(id uint16, topics []string) packet {
+	body := []byte{byte(id >> 8), byte(id)}
+	for _, topic := range topics {
+		body = appendString(body, topic)
+		body = append(body, 1)
+	}
+	return packet{typ: packetSubscribe, flags: 0x02, body: body}
+}
+
+// publish is a received PUBLISH packet
+type publish struct {
+	topic   string
+	qos     byte
+	id      uint16
+	payload []byte
+}
+
+func parsePublish(p packet) (publish, error) {
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return publish{}, err
+	}
+	pub := publish{topic: topic, qos: (p.flags >> 1) & 0x03}
+	if pub.qos > 0 {
+		if len(rest) < 2 {
+			return publish{}, fmt.Errorf("publish without packet identifier")
+		}
+		pub.id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	pub.payload = rest
+	return pub, nil
+}
+
+func connackError(code byte) error {
+	switch code {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("unacceptable protocol version")
+	case 2:
+		return fmt.Errorf("client identifier rejected")
+	case 3:
+		return fmt.Errorf("server unavailable")
+	case 4:
+		return fmt.Errorf("bad user name or password")
+	case 5:
+		return fmt.Errorf("not authorized")
+	}
+	return fmt.Errorf("connection refused with code %v", code)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/kubernetes"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// exporterConfigs merges the watched ExporterConfigs into the render settings of the configuration file
+type exporterConfigs struct {
+	mutex    sync.Mutex
+	base     config.Config
+	items    []typ.ExporterConfig
+	exporter *prometheus.Exporter
+}
+
+// setBase replaces the configuration the ExporterConfigs are merged into and applies the result
+func (c *exporterConfigs) setBase(conf config.Config) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.base = conf
+	c.apply()
+}
+
+// setExporter sets the Exporter whose render settings are replaced
+func (c *exporterConfigs) setExporter(exporter *prometheus.Exporter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.exporter = exporter
+	c.apply()
+}
+
+// handle is the kubernetes.ConfigHandler of the watcher
+func (c *exporterConfigs) handle(items []typ.ExporterConfig) map[string]error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = items
+	return c.apply()
+}
+
+func (c *exporterConfigs) apply() map[string]error {
+	render, errs := kubernetes.MergeExporterConfigs(c.base, c.items)
+	for key, err := range errs {
+		log.Printf("can not apply exporterconfig %s, skip it; err is: %v", key, err)
+	}
+	if c.exporter != nil {
+		c.exporter.SetRender(render)
+	}
+	return errs
+}
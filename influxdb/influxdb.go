@@ -0,0 +1,112 @@
+// Package influxdb writes the twins in the line protocol to the write API of InfluxDB 1.x or 2.x
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// Writer writes the points rendered by source, like prometheus.Exporter.LineProtocol, to InfluxDB
+type Writer struct {
+	source   func() string
+	conf     config.InfluxDB
+	url      string
+	interval time.Duration
+	client   *http.Client
+}
+
+// New creates a Writer; conf has to be validated by config.Config.Validate
+func New(source func() string, conf config.InfluxDB) *Writer {
+	w := &Writer{
+		source:   source,
+		conf:     conf,
+		url:      writeURL(conf),
+		interval: conf.Interval.Duration,
+		client:   &http.Client{Timeout: conf.Timeout.Duration},
+	}
+	if w.interval == 0 {
+		w.interval = defaultInterval
+	}
+	if w.client.Timeout == 0 {
+		w.client.Timeout = defaultTimeout
+	}
+	return w
+}
+
+// writeURL returns the url of the write API of the version of conf; the timestamps are in nanoseconds
+func writeURL(conf config.InfluxDB) string {
+	query := url.Values{"precision": {"ns"}}
+	path := "/write"
+	if conf.Version == 2 {
+		path = "/api/v2/write"
+		query.Set("org", conf.Org)
+		query.Set("bucket", conf.Bucket)
+	} else {
+		query.Set("db", conf.Database)
+		if conf.RetentionPolicy != "" {
+			query.Set("rp", conf.RetentionPolicy)
+		}
+	}
+	return strings.TrimSuffix(conf.URL, "/") + path + "?" + query.Encode()
+}
+
+// Write writes the current points; nothing is sent if there are no points
+func (w *Writer) Write() error {
+	body := w.source()
+	if body == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.conf.BasicAuth != nil {
+		req.SetBasicAuth(w.conf.BasicAuth.Username, w.conf.BasicAuth.Password)
+	}
+	if w.conf.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.conf.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	return fmt.Errorf("write returned %v: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// Run writes the points immediately and then every interval until stop is closed
+func (w *Writer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Write(); err != nil {
+			log.Printf("can not write to influxdb; err is: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const points = "temperature,device=sensor-tag01,namespace=default reported=21,desired=20 1561975200000000000\n"
+
+func TestWrite(t *testing.T) {
+	for _, c := range []struct {
+		conf      config.InfluxDB
+		path      string
+		query     string
+		auth      string
+		basicUser string
+	}{
+		{
+			conf:  config.InfluxDB{Version: 2, Org: "plant", Bucket: "twins", Token: "secret"},
+			path:  "/api/v2/write",
+			query: "bucket=twins&org=plant&precision=ns",
+			auth:  "Token secret",
+		},
+		{
+			conf:      config.InfluxDB{Version: 1, Database: "kubeedge", RetentionPolicy: "year", BasicAuth: &config.BasicAuth{Username: "edge", Password: "pw"}},
+			path:      "/write",
+			query:     "db=kubeedge&precision=ns&rp=year",
+			basicUser: "edge",
+		},
+	} {
+		var got *http.Request
+		var body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			got, body = r, string(data)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		c.conf.URL = server.URL + "/"
+
+		if err := New(func() string { return points }, c.conf).Write(); err != nil {
+			t.Errorf("version %v: write failed: %v", c.conf.Version, err)
+		} else {
+			user, _, _ := got.BasicAuth()
+			if got.URL.Path != c.path || got.URL.RawQuery != c.query || body != points || user != c.basicUser ||
+				c.auth != "" && got.Header.Get("Authorization") != c.auth {
+				t.Errorf("version %v: unexpected request %v %v with body %q", c.conf.Version, got.URL, got.Header, body)
+			}
+		}
+		server.Close()
+	}
+}
+
+func TestWriteReportsErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, `{"code":"invalid","message":"unable to parse"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	conf := config.InfluxDB{URL: server.URL, Version: 1, Database: "kubeedge"}
+	if err := New(func() string { return points }, conf).Write(); err == nil {
+		t.Errorf("the rejected write was not reported")
+	}
+	if err := New(func() string { return "" }, conf).Write(); err != nil || requests != 1 {
+		t.Errorf("an empty write was sent")
+	}
+}
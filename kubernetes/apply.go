@@ -0,0 +1,27 @@
+package kubernetes
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// ApplyDevice creates device or replaces the device with the same name and namespace
+func (w *Watcher) ApplyDevice(device *typ.Device) error {
+	w.stopMutex.Lock()
+	client := w.restClient
+	w.stopMutex.Unlock()
+
+	existing := &typ.Device{}
+	err := client.Get().Namespace(device.Namespace).Resource("devices").Name(device.Name).Do().Into(existing)
+	if errors.IsNotFound(err) {
+		device.ResourceVersion = ""
+		return client.Post().Namespace(device.Namespace).Resource("devices").Body(device).Do().Error()
+	}
+	if err != nil {
+		return err
+	}
+
+	device.ResourceVersion = existing.ResourceVersion
+	return client.Put().Namespace(device.Namespace).Resource("devices").Name(device.Name).Body(device).Do().Error()
+}
@@ -0,0 +1,125 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+var (
+	twinResource   = schema.GroupResource{Group: "devices.kubeedge.io", Resource: "twins"}
+	deviceResource = schema.GroupResource{Group: "devices.kubeedge.io", Resource: "devices"}
+)
+
+// isUnprocessable reports whether err is a status error with the code 422
+func isUnprocessable(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	return ok && status.Status().Code == http.StatusUnprocessableEntity
+}
+
+// patchOperation is an operation of a JSON patch
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// SetDesired sets the desired value of the twin property of the device name in namespace. The value is validated
+// against the type of the twin and the DeviceModel of the device. Only the desired value is patched, or a twin is
+// appended for a property which is only defined by the DeviceModel, and only if the device still has
+// resourceVersion; if resourceVersion is empty, the update is retried with the latest device on conflicts. The status
+// subresource is patched if the CRD enables it. It returns the previous desired value and the resourceVersion of the
+// updated device; the errors are errors.StatusError.
+func (w *Watcher) SetDesired(namespace string, name string, property string, value string, resourceVersion string) (old string, updated string, err error) {
+	w.stopMutex.Lock()
+	client := w.restClient
+	w.stopMutex.Unlock()
+
+	update := func() error {
+		device := &typ.Device{}
+		if err := client.Get().Namespace(namespace).Resource("devices").Name(name).Do().Into(device); err != nil {
+			return err
+		}
+		if resourceVersion != "" && resourceVersion != device.ResourceVersion {
+			return errors.NewConflict(deviceResource, name, fmt.Errorf("the device has been modified; resourceVersion is %s", device.ResourceVersion))
+		}
+
+		var model *typ.DeviceModel
+		if device.Spec.DeviceModelRef != nil {
+			model = &typ.DeviceModel{}
+			err := client.Get().Namespace(namespace).Resource("devicemodels").Name(device.Spec.DeviceModelRef.Name).Do().Into(model)
+			if errors.IsNotFound(err) {
+				model = nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		reported := len(device.Status.Twins)
+		i := typ.DesiredTwin(device, model, property)
+		if i < 0 {
+			return errors.NewNotFound(twinResource, property)
+		}
+		if errs := typ.ValidateDesired(device, model, i, value); len(errs) > 0 {
+			return errors.NewInvalid(schema.GroupKind{Group: "devices.kubeedge.io", Kind: "Device"}, name, errs)
+		}
+		old = device.Status.Twins[i].Desired.Value
+
+		device.Status.Twins[i].Desired.Value = value
+		twin := device.Status.Twins[i]
+		// the test of the resourceVersion makes the patch fail if the device has been modified since the get
+		operations := []patchOperation{{Op: "test", Path: "/metadata/resourceVersion", Value: device.ResourceVersion}}
+		switch {
+		case i < reported:
+			path := fmt.Sprintf("/status/twins/%v", i)
+			operations = append(operations,
+				patchOperation{Op: "test", Path: path + "/propertyName", Value: property},
+				patchOperation{Op: "add", Path: path + "/desired", Value: twin.Desired})
+		case reported == 0:
+			// the property is only defined by the model and the device has no twins; the status has no other fields
+			operations = append(operations, patchOperation{Op: "add", Path: "/status", Value: typ.DeviceStatus{Twins: []typ.Twin{twin}}})
+		default:
+			// the property is only defined by the model, so its twin is appended
+			operations = append(operations, patchOperation{Op: "add", Path: "/status/twins/-", Value: twin})
+		}
+		patch, err := json.Marshal(operations)
+		if err != nil {
+			return err
+		}
+		result := &typ.Device{}
+		err = client.Patch(types.JSONPatchType).Namespace(namespace).Resource("devices").Name(name).SubResource("status").Body(patch).Do().Into(result)
+		if errors.IsNotFound(err) {
+			// the CRD does not enable the status subresource
+			err = client.Patch(types.JSONPatchType).Namespace(namespace).Resource("devices").Name(name).Body(patch).Do().Into(result)
+		}
+		if isUnprocessable(err) {
+			// a failed test is reported as an invalid patch
+			current := &typ.Device{}
+			if getErr := client.Get().Namespace(namespace).Resource("devices").Name(name).Do().Into(current); getErr == nil && current.ResourceVersion != device.ResourceVersion {
+				return errors.NewConflict(deviceResource, name, fmt.Errorf("the device has been modified; resourceVersion is %s", current.ResourceVersion))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		updated = result.ResourceVersion
+		return nil
+	}
+
+	if resourceVersion != "" {
+		err = update()
+	} else {
+		err = retry.RetryOnConflict(retry.DefaultRetry, update)
+	}
+	if _, ok := err.(errors.APIStatus); err != nil && !ok {
+		err = errors.NewInternalError(err)
+	}
+	return old, updated, err
+}
@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func TestSetDesiredPatchesTheTwin(t *testing.T) {
+	device := typ.Device{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "devices.kubeedge.io/v1alpha1", Kind: "Device"},
+		ObjectMeta: metav1.ObjectMeta{Name: "sensor", Namespace: "plant-a", ResourceVersion: "42"},
+		Status: typ.DeviceStatus{Twins: []typ.Twin{
+			{Name: "humidity", Actual: typ.TwinValue{Value: "40", Metadata: map[string]string{"type": "int"}}},
+			{Name: "temperature", Actual: typ.TwinValue{Value: "20", Metadata: map[string]string{"type": "int"}}, Desired: typ.TwinValue{Value: "20", Metadata: map[string]string{"type": "int"}}},
+		}},
+	}
+	var patches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devices/sensor":
+			json.NewEncoder(w).Encode(device)
+		case r.Method == http.MethodPatch && r.URL.Path == "/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devices/sensor/status":
+			// the status subresource is not enabled
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Code: http.StatusNotFound, Reason: metav1.StatusReasonNotFound})
+		case r.Method == http.MethodPatch && r.URL.Path == "/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devices/sensor":
+			if r.Header.Get("Content-Type") != "application/json-patch+json" {
+				t.Errorf("got content type %s", r.Header.Get("Content-Type"))
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			patches = append(patches, string(body))
+			updated := device
+			updated.ResourceVersion = "43"
+			json.NewEncoder(w).Encode(updated)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	w, err := NewWatcher(nil, nil, fakeClients(t, server))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	old, updated, err := w.SetDesired("plant-a", "sensor", "temperature", "25", "")
+	if err != nil || old != "20" || updated != "43" {
+		t.Fatalf("got %q %q %v", old, updated, err)
+	}
+	want := `[{"op":"test","path":"/metadata/resourceVersion","value":"42"},{"op":"test","path":"/status/twins/1/propertyName","value":"temperature"},{"op":"add","path":"/status/twins/1/desired","value":{"metadata":{"type":"int"},"value":"25"}}]`
+	if len(patches) != 1 || patches[0] != want {
+		t.Errorf("got patches %v, want %s", patches, want)
+	}
+
+	if _, _, err := w.SetDesired("plant-a", "sensor", "temperature", "25", "41"); !errors.IsConflict(err) {
+		t.Errorf("got %v for an outdated resourceVersion, want a conflict", err)
+	}
+	if len(patches) != 1 {
+		t.Errorf("a device with an outdated resourceVersion was patched")
+	}
+}
+
+func TestSetDesiredModelProperty(t *testing.T) {
+	device := typ.Device{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "devices.kubeedge.io/v1alpha1", Kind: "Device"},
+		ObjectMeta: metav1.ObjectMeta{Name: "sensor", Namespace: "plant-a", ResourceVersion: "42"},
+		Spec:       typ.DeviceSpec{DeviceModelRef: &v1.LocalObjectReference{Name: "thermostat"}},
+	}
+	model := typ.DeviceModel{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "devices.kubeedge.io/v1alpha1", Kind: "DeviceModel"},
+		ObjectMeta: metav1.ObjectMeta{Name: "thermostat", Namespace: "plant-a"},
+		Spec: typ.DeviceModelSpec{Properties: []typ.DeviceProperty{
+			{Name: "setpoint", Type: typ.PropertyType{Int: &typ.PropertyTypeInt64{AccessMode: typ.ReadWrite}}},
+			{Name: "display", Type: typ.PropertyType{Int: &typ.PropertyTypeInt64{AccessMode: typ.ReadOnly}}},
+		}},
+	}
+	var patches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devices/sensor":
+			json.NewEncoder(w).Encode(device)
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devicemodels/thermostat":
+			json.NewEncoder(w).Encode(model)
+		case r.Method == http.MethodPatch && r.URL.Path == "/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devices/sensor/status":
+			body, _ := ioutil.ReadAll(r.Body)
+			patches = append(patches, string(body))
+			updated := device
+			updated.ResourceVersion = "43"
+			json.NewEncoder(w).Encode(updated)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	w, err := NewWatcher(nil, nil, fakeClients(t, server))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+
+	// the device has no twins, so the status is created
+	if _, _, err := w.SetDesired("plant-a", "sensor", "setpoint", "22", ""); err != nil {
+		t.Fatalf("can not set the desired value: %v", err)
+	}
+	want := `[{"op":"test","path":"/metadata/resourceVersion","value":"42"},{"op":"add","path":"/status","value":{"twins":[{"propertyName":"setpoint","reported":{},"desired":{"metadata":{"type":"int"},"value":"22"}}]}}]`
+	if len(patches) != 1 || patches[0] != want {
+		t.Errorf("got patches %v, want %s", patches, want)
+	}
+
+	// the twin of the property is appended to the twins of the device
+	device.Status.Twins = []typ.Twin{{Name: "humidity", Actual: typ.TwinValue{Value: "40", Metadata: map[string]string{"type": "int"}}}}
+	patches = nil
+	if _, _, err := w.SetDesired("plant-a", "sensor", "setpoint", "22", ""); err != nil {
+		t.Fatalf("can not set the desired value: %v", err)
+	}
+	want = `[{"op":"test","path":"/metadata/resourceVersion","value":"42"},{"op":"add","path":"/status/twins/-","value":{"propertyName":"setpoint","reported":{},"desired":{"metadata":{"type":"int"},"value":"22"}}}]`
+	if len(patches) != 1 || patches[0] != want {
+		t.Errorf("got patches %v, want %s", patches, want)
+	}
+
+	patches = nil
+	for _, c := range []struct {
+		property, value string
+	}{
+		{"setpoint", "warm"},
+		{"display", "22"},
+	} {
+		if _, _, err := w.SetDesired("plant-a", "sensor", c.property, c.value, ""); !errors.IsInvalid(err) {
+			t.Errorf("got %v for %s=%s, want an invalid error", err, c.property, c.value)
+		}
+	}
+	if _, _, err := w.SetDesired("plant-a", "sensor", "pressure", "1", ""); !errors.IsNotFound(err) {
+		t.Errorf("got %v for an unknown property, want not found", err)
+	}
+	if len(patches) != 0 {
+		t.Errorf("an invalid desired value was patched: %v", patches)
+	}
+}
@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"log"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// component is the source of the events created by the exporter
+const component = "cpu-kubeedge-exporter"
+
+// Warning creates a warning event for device; the event is created in the background so the caller is not blocked
+func (w *Watcher) Warning(device *typ.Device, reason string, message string) {
+	w.event(device, v1.EventTypeWarning, reason, message)
+}
+
+// Normal creates a normal event for device in the background
+func (w *Watcher) Normal(device *typ.Device, reason string, message string) {
+	w.event(device, v1.EventTypeNormal, reason, message)
+}
+
+func (w *Watcher) event(device *typ.Device, eventType string, reason string, message string) {
+	w.stopMutex.Lock()
+	clientset := w.clientset
+	w.stopMutex.Unlock()
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: device.Name + ".",
+			Namespace:    device.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion:      "devices.kubeedge.io/v1alpha1",
+			Kind:            "Device",
+			Name:            device.Name,
+			Namespace:       device.Namespace,
+			UID:             device.UID,
+			ResourceVersion: device.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Source:         v1.EventSource{Component: component},
+	}
+
+	go func() {
+		if _, err := clientset.CoreV1().Events(device.Namespace).Create(event); err != nil {
+			log.Printf("can not create event for device %s/%s; err is: %v", device.Namespace, device.Name, err)
+		}
+	}()
+}
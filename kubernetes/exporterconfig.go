@@ -0,0 +1,168 @@
+package kubernetes
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	awatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// ConfigHandler applies the ExporterConfigs in the order of MergeExporterConfigs; it returns the errors of the
+// configs which could not be applied by namespace/name. It is called with nil when the watcher stops watching them.
+type ConfigHandler func(configs []typ.ExporterConfig) map[string]error
+
+// WithExporterConfigs sets the handler of the ExporterConfigs; they are only watched if config.Watch.ExporterConfigs
+// is set
+func WithExporterConfigs(handler ConfigHandler) Option {
+	return func(w *Watcher) {
+		w.configHandler = handler
+	}
+}
+
+// runExporterConfigs passes all ExporterConfigs to the handler on every change and reports the result in their
+// status until stop is closed
+func (w *Watcher) runExporterConfigs(events <-chan awatch.Event, client rest.Interface, stop <-chan struct{}) {
+	configs := make(map[string]*typ.ExporterConfig)
+	for {
+		select {
+		case <-stop:
+			return
+		case ev := <-events:
+			c, ok := ev.Object.(*typ.ExporterConfig)
+			if !ok {
+				log.Printf("in exporterconfigs: can not convert ev.Object to *typ.ExporterConfig")
+				continue
+			}
+			key := c.Namespace + "/" + c.Name
+			if ev.Type == awatch.Deleted {
+				delete(configs, key)
+			} else {
+				configs[key] = c
+			}
+
+			items := make([]typ.ExporterConfig, 0, len(configs))
+			for _, c := range configs {
+				items = append(items, *c)
+			}
+			sortExporterConfigs(items)
+			errs := w.configHandler(items)
+			for _, c := range items {
+				if err := updateStatus(client, c, errs[c.Namespace+"/"+c.Name]); err != nil {
+					log.Printf("can not update the status of exporterconfig %s/%s; err is: %v", c.Namespace, c.Name, err)
+				}
+			}
+		}
+	}
+}
+
+// updateStatus sets the Applied condition of c to the result err; c is only updated if the condition changes
+func updateStatus(client rest.Interface, c typ.ExporterConfig, err error) error {
+	condition := typ.ExporterConfigCondition{
+		Type:               typ.ExporterConfigConditionApplied,
+		Status:             v1.ConditionTrue,
+		ObservedGeneration: c.Generation,
+		Reason:             "Applied",
+		Message:            fmt.Sprintf("merged with priority %v", c.Spec.Priority),
+	}
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = v1.ConditionFalse, "Invalid", err.Error()
+	}
+
+	var conditions []typ.ExporterConfigCondition
+	for _, existing := range c.Status.Conditions {
+		if existing.Type != condition.Type {
+			conditions = append(conditions, existing)
+			continue
+		}
+		if existing.Status == condition.Status && existing.ObservedGeneration == condition.ObservedGeneration &&
+			existing.Reason == condition.Reason && existing.Message == condition.Message {
+			return nil
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+	}
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	updated := c.DeepCopy()
+	updated.Status.Conditions = append(conditions, condition)
+	return client.Put().Namespace(c.Namespace).Resource("exporterconfigs").Name(c.Name).SubResource("status").Body(updated).Do().Error()
+}
+
+// sortExporterConfigs sorts configs by ascending priority, then by namespace and name
+func sortExporterConfigs(configs []typ.ExporterConfig) {
+	sort.Slice(configs, func(i, j int) bool {
+		a, b := configs[i], configs[j]
+		if a.Spec.Priority != b.Spec.Priority {
+			return a.Spec.Priority < b.Spec.Priority
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// MergeExporterConfigs merges configs into the render settings of base by ascending priority, then by namespace and
+// name. Every config adds a config.Scope with its selection, labels, transforms, relabel configs and maxSeries, which
+// only apply to the devices of its namespace. A config which makes base invalid is skipped and its error is returned
+// by namespace/name.
+func MergeExporterConfigs(base config.Config, configs []typ.ExporterConfig) (config.Render, map[string]error) {
+	sorted := append([]typ.ExporterConfig(nil), configs...)
+	sortExporterConfigs(sorted)
+
+	render := base.Render
+	render.Scopes = nil
+	errs := make(map[string]error)
+	for _, c := range sorted {
+		scope := exporterScope(c)
+		if err := scope.Validate(); err != nil {
+			errs[c.Namespace+"/"+c.Name] = err
+			continue
+		}
+		render.Scopes = append(render.Scopes, scope)
+	}
+	return render, errs
+}
+
+// exporterScope returns the settings of c
+func exporterScope(c typ.ExporterConfig) config.Scope {
+	spec := c.Spec
+	scope := config.Scope{
+		Namespace:     c.Namespace,
+		LabelSelector: spec.LabelSelector,
+		Properties:    spec.Properties,
+		Labels:        spec.Labels,
+		MaxSeries:     spec.MaxSeries,
+	}
+	for _, t := range spec.Transforms {
+		scope.Transforms = append(scope.Transforms, config.Transform{
+			Model:    t.Model,
+			Property: t.Property,
+			Scale:    t.Scale,
+			Offset:   t.Offset,
+			FromUnit: t.FromUnit,
+			ToUnit:   t.ToUnit,
+			Metric:   t.Metric,
+		})
+	}
+	for _, rc := range spec.RelabelConfigs {
+		scope.RelabelConfigs = append(scope.RelabelConfigs, config.RelabelConfig{
+			SourceLabels: rc.SourceLabels,
+			Separator:    rc.Separator,
+			Regex:        rc.Regex,
+			TargetLabel:  rc.TargetLabel,
+			Replacement:  rc.Replacement,
+			Action:       rc.Action,
+		})
+	}
+	return scope
+}
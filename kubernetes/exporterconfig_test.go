@@ -0,0 +1,132 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	awatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func exporterConfig(namespace, name string, spec typ.ExporterConfigSpec) typ.ExporterConfig {
+	return typ.ExporterConfig{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: 1}, Spec: spec}
+}
+
+func TestMergeExporterConfigs(t *testing.T) {
+	base := config.Default()
+	base.Address, base.Port = "localhost", 8080
+	base.Render.Labels = []string{"site"}
+	base.Render.MaxSeries = 100
+	configs := []typ.ExporterConfig{
+		exporterConfig("plant-b", "late", typ.ExporterConfigSpec{Priority: 10, Labels: []string{"line", "site"}, MaxSeries: 50}),
+		exporterConfig("plant-a", "invalid", typ.ExporterConfigSpec{LabelSelector: "a in (", Properties: []string{"pressure"}}),
+		exporterConfig("plant-a", "early", typ.ExporterConfigSpec{
+			LabelSelector:  "line=3",
+			Properties:     []string{"temperature"},
+			RelabelConfigs: []typ.ExporterRelabelConfig{{SourceLabels: []string{"sensor"}, Regex: "tmp.*", Action: "drop"}},
+			MaxSeries:      200,
+		}),
+	}
+
+	render, errs := MergeExporterConfigs(base, configs)
+	if len(errs) != 1 || errs["plant-a/invalid"] == nil {
+		t.Errorf("got errors %v, want one for plant-a/invalid", errs)
+	}
+	if len(render.Scopes) != 2 {
+		t.Fatalf("got scopes %+v, want the ones of plant-a/early and plant-b/late", render.Scopes)
+	}
+	early, late := render.Scopes[0], render.Scopes[1]
+	if early.Namespace != "plant-a" || early.LabelSelector != "line=3" || !equal(early.Properties, []string{"temperature"}) ||
+		len(early.RelabelConfigs) != 1 || early.RelabelConfigs[0].Action != "drop" || early.MaxSeries != 200 {
+		t.Errorf("got scope %+v", early)
+	}
+	if late.Namespace != "plant-b" || !equal(late.Labels, []string{"line", "site"}) || late.MaxSeries != 50 {
+		t.Errorf("got scope %+v", late)
+	}
+	// the settings of the configuration file apply to all namespaces
+	if len(render.Selections) != 0 || !equal(render.Labels, []string{"site"}) || render.MaxSeries != 100 {
+		t.Errorf("the settings of the configuration file were changed: %+v", render)
+	}
+	if len(base.Render.Scopes) != 0 {
+		t.Errorf("the base configuration was modified: %+v", base.Render)
+	}
+}
+
+func TestExporterConfigStatus(t *testing.T) {
+	updates := make(chan typ.ExporterConfig, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasSuffix(r.URL.Path, "/namespaces/plant-a/exporterconfigs/early/status") {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		var c typ.ExporterConfig
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Errorf("can not decode the status update: %v", err)
+		}
+		updates <- c
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}))
+	defer server.Close()
+	client, err := NewExporterConfigRESTClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("can not create client: %v", err)
+	}
+
+	handled := make(chan []typ.ExporterConfig, 10)
+	w := &Watcher{configHandler: func(configs []typ.ExporterConfig) map[string]error {
+		handled <- configs
+		return map[string]error{"plant-a/early": errInvalid}
+	}}
+	events, stop := make(chan awatch.Event), make(chan struct{})
+	defer close(stop)
+	go w.runExporterConfigs(events, client, stop)
+
+	c := exporterConfig("plant-a", "early", typ.ExporterConfigSpec{})
+	events <- awatch.Event{Type: awatch.Added, Object: &c}
+	select {
+	case configs := <-handled:
+		if len(configs) != 1 || configs[0].Name != "early" {
+			t.Errorf("got configs %+v", configs)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the handler was not called")
+	}
+	var updated typ.ExporterConfig
+	select {
+	case updated = <-updates:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the status was not updated")
+	}
+	if len(updated.Status.Conditions) != 1 {
+		t.Fatalf("got conditions %+v", updated.Status.Conditions)
+	}
+	cond := updated.Status.Conditions[0]
+	if cond.Type != typ.ExporterConfigConditionApplied || cond.Status != v1.ConditionFalse || cond.Reason != "Invalid" || cond.Message != errInvalid.Error() || cond.ObservedGeneration != 1 {
+		t.Errorf("got condition %+v", cond)
+	}
+
+	// an unchanged condition is not written again
+	events <- awatch.Event{Type: awatch.Modified, Object: &updated}
+	<-handled
+	events <- awatch.Event{Type: awatch.Deleted, Object: &updated}
+	if configs := <-handled; len(configs) != 0 {
+		t.Errorf("got configs %+v after the deletion", configs)
+	}
+	select {
+	case c := <-updates:
+		t.Errorf("unexpected status update %+v", c.Status)
+	default:
+	}
+}
+
+var errInvalid = errors.New("invalid labelSelector")
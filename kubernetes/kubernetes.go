@@ -1,7 +1,10 @@
 package kubernetes
 
 import (
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -10,7 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	awatch "k8s.io/apimachinery/pkg/watch"
 
-	//"k8s.io/api/core/v1"
+	"k8s.io/api/core/v1"
 
 	//corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -19,25 +22,33 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	//"k8s.io/client-go/tools/watch"
 
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
 	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
 )
 
 type ResourceEventHandler struct {
+	kind   string
 	events chan awatch.Event
+	// no event is passed once stop is closed; fence is held for reading while an event is passed
+	stop  <-chan struct{}
+	fence *sync.RWMutex
 }
 
 func (r ResourceEventHandler) OnAdd(obj interface{}) {
-	log.Printf("adding new device")
+	log.Printf("adding new %s", r.kind)
 	r.obj2Event(awatch.Added, obj)
 }
 
 func (r ResourceEventHandler) OnUpdate(odlObj, obj interface{}) {
-	log.Printf("modify device")
+	log.Printf("modify %s", r.kind)
 	r.obj2Event(awatch.Modified, obj)
 }
 
 func (r ResourceEventHandler) OnDelete(obj interface{}) {
-	log.Printf("delete device")
+	log.Printf("delete %s", r.kind)
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
 	r.obj2Event(awatch.Deleted, obj)
 }
 
@@ -47,87 +58,390 @@ func (r ResourceEventHandler) obj2Event(typ awatch.EventType, obj interface{}) {
 		log.Printf("unknow type: %T, ignore", obj)
 		return
 	}
-	r.events <- awatch.Event{Type: typ, Object: eventObj}
+	r.fence.RLock()
+	defer r.fence.RUnlock()
+	select {
+	case <-r.stop:
+		return
+	default:
+	}
+	select {
+	case r.events <- awatch.Event{Type: typ, Object: eventObj}:
+	case <-r.stop:
+	}
+}
+
+// informerStatus tracks the sync state and watch health of one informer
+type informerStatus struct {
+	synced   cache.InformerSynced
+	lastSync time.Time
+	restarts uint64
+	watches  uint64
+	lastErr  error
+}
+
+// WatchStatus reports the sync state and the health of the informers of a Watcher
+type WatchStatus struct {
+	mutex     sync.RWMutex
+	informers map[string]*informerStatus
+}
+
+func newWatchStatus() *WatchStatus {
+	return &WatchStatus{informers: make(map[string]*informerStatus)}
 }
 
-var kubernetesRestClient *rest.RESTClient
+// HasSynced returns true once the device and the node informer have completed their initial list
+func (s *WatchStatus) HasSynced() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.informers) == 0 {
+		return false
+	}
+	for _, inf := range s.informers {
+		if inf.synced == nil || !inf.synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Healthy returns an error if the last list or watch call of an informer has failed
+func (s *WatchStatus) Healthy() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for name, inf := range s.informers {
+		if inf.lastErr != nil {
+			return fmt.Errorf("watch of %s failed: %v", name, inf.lastErr)
+		}
+	}
+	return nil
+}
+
+// LastSync returns the time of the last successful list per informer
+func (s *WatchStatus) LastSync() map[string]time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := make(map[string]time.Time, len(s.informers))
+	for name, inf := range s.informers {
+		ret[name] = inf.lastSync
+	}
+	return ret
+}
+
+// WatchRestarts returns how often the watch of each informer had to be re-established
+func (s *WatchStatus) WatchRestarts() map[string]uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := make(map[string]uint64, len(s.informers))
+	for name, inf := range s.informers {
+		ret[name] = inf.restarts
+	}
+	return ret
+}
+
+func (s *WatchStatus) reset() {
+	s.mutex.Lock()
+	s.informers = make(map[string]*informerStatus)
+	s.mutex.Unlock()
+}
+
+// newListWatch creates a ListWatch of resource which records list times, watch restarts and errors of the informer
+// name
+func (s *WatchStatus) newListWatch(name string, c cache.Getter, resource string, namespace string, labelSelector string) *cache.ListWatch {
+	return s.recordListWatch(name, cache.NewFilteredListWatchFromClient(c, resource, namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.Everything().String()
+		options.LabelSelector = labelSelector
+	}))
+}
+
+// recordListWatch changes lw to record list times, watch restarts and errors of the informer name
+func (s *WatchStatus) recordListWatch(name string, lw *cache.ListWatch) *cache.ListWatch {
+	inf := &informerStatus{}
+	s.mutex.Lock()
+	s.informers[name] = inf
+	s.mutex.Unlock()
+
+	list, watch := lw.ListFunc, lw.WatchFunc
+	lw.ListFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		obj, err := list(options)
+		s.mutex.Lock()
+		inf.lastErr = err
+		if err == nil {
+			inf.lastSync = time.Now()
+		}
+		s.mutex.Unlock()
+		return obj, err
+	}
+	lw.WatchFunc = func(options metav1.ListOptions) (awatch.Interface, error) {
+		wi, err := watch(options)
+		s.mutex.Lock()
+		if inf.watches > 0 {
+			inf.restarts++
+		}
+		inf.watches++
+		inf.lastErr = err
+		s.mutex.Unlock()
+		return wi, err
+	}
+	return lw
+}
+
+func (s *WatchStatus) runInformer(name string, lw *cache.ListWatch, objType runtime.Object, handler ResourceEventHandler, stop chan struct{}) {
+	si := cache.NewSharedInformer(lw, objType, 0)
+	si.AddEventHandler(handler)
+	s.mutex.Lock()
+	s.informers[name].synced = si.HasSynced
+	s.mutex.Unlock()
+	go si.Run(stop)
+}
+
+// ExporterConfigGroupVersion is the API group of the ExporterConfig resource
+var ExporterConfigGroupVersion = schema.GroupVersion{Group: "exporter.kubeedge.io", Version: "v1alpha1"}
 
 func createScheme(scheme *runtime.Scheme) error {
-	scheme.AddKnownTypes(schema.GroupVersion{Group: "devices.kubeedge.io", Version: "v1alpha1"}, &typ.Device{}, &typ.DeviceList{})
+	scheme.AddKnownTypes(schema.GroupVersion{Group: "devices.kubeedge.io", Version: "v1alpha1"}, &typ.Device{}, &typ.DeviceList{}, &typ.DeviceModel{}, &typ.DeviceModelList{})
 	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Group: "devices.kubeedge.io", Version: "v1alpha1"})
+	scheme.AddKnownTypes(ExporterConfigGroupVersion, &typ.ExporterConfig{}, &typ.ExporterConfigList{})
+	metav1.AddToGroupVersion(scheme, ExporterConfigGroupVersion)
 
 	return nil
 }
 
-// Init will initialise the connection to kubernetes api server
-// kubeMaster is the url of the master
-// kubeConfig is the path to the kubeconfig
-func Init(kubeMaster string, kubeConfig string, events chan awatch.Event, ev chan awatch.Event) error {
+// Watcher watches the devices and nodes of a cluster and passes their changes as events
+type Watcher struct {
+	kubeMaster string
+	kubeConfig string
+	watch      config.Watch
+
+	events chan awatch.Event
+	ev     chan awatch.Event
+
+	restClient rest.Interface
+	clientset  kubernetes.Interface
+	// configClient serves the exporter.kubeedge.io/v1alpha1 API; it is only used if configHandler is set
+	configClient  rest.Interface
+	configHandler ConfigHandler
+	// injected is set if the clients were passed as options and must not be replaced by connect
+	injected bool
+	status   *WatchStatus
+
+	// stopMutex guards stop and the clients
+	stopMutex sync.Mutex
+	stop      chan struct{}
+	// configsDone is closed when runExporterConfigs returns; it is nil if the ExporterConfigs are not watched
+	configsDone chan struct{}
+	// fence is held by the event handlers while they pass an event, so Stop can wait for them
+	fence sync.RWMutex
+}
+
+// Option configures a Watcher
+type Option func(*Watcher)
+
+// WithServer sets the url of the kubernetes api server
+func WithServer(kubeMaster string) Option {
+	return func(w *Watcher) {
+		w.kubeMaster = kubeMaster
+	}
+}
+
+// WithKubeConfig sets the path to the kubeconfig
+func WithKubeConfig(kubeConfig string) Option {
+	return func(w *Watcher) {
+		w.kubeConfig = kubeConfig
+	}
+}
+
+// WithWatch selects the namespaces and the labels of the watched devices
+func WithWatch(watch config.Watch) Option {
+	return func(w *Watcher) {
+		w.watch = watch
+	}
+}
+
+// WithClients sets the clients which are used instead of connecting to the configured api server;
+// restClient has to serve the devices.kubeedge.io/v1alpha1 API, see NewDeviceRESTClient
+func WithClients(clientset kubernetes.Interface, restClient rest.Interface) Option {
+	return func(w *Watcher) {
+		w.clientset = clientset
+		w.restClient = restClient
+		w.injected = true
+	}
+}
+
+// WithConfigClient sets the client of the ExporterConfigs which is used instead of connecting to the configured api
+// server, see NewExporterConfigRESTClient
+func WithConfigClient(configClient rest.Interface) Option {
+	return func(w *Watcher) {
+		w.configClient = configClient
+	}
+}
+
+// NewWatcher will initialise the connection to kubernetes api server;
+// the device events are sent on events and the node events on ev once Start is called
+func NewWatcher(events chan awatch.Event, ev chan awatch.Event, opts ...Option) (*Watcher, error) {
+	w := &Watcher{
+		watch:  config.Default().Watch,
+		events: events,
+		ev:     ev,
+		status: newWatchStatus(),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewDeviceRESTClient creates a REST client for the devices.kubeedge.io/v1alpha1 API of the api server configured by conf
+func NewDeviceRESTClient(conf *rest.Config) (*rest.RESTClient, error) {
+	return newRESTClient(conf, schema.GroupVersion{Group: "devices.kubeedge.io", Version: "v1alpha1"})
+}
+
+// NewExporterConfigRESTClient creates a REST client for the exporter.kubeedge.io/v1alpha1 API of the api server
+// configured by conf
+func NewExporterConfigRESTClient(conf *rest.Config) (*rest.RESTClient, error) {
+	return newRESTClient(conf, ExporterConfigGroupVersion)
+}
+
+func newRESTClient(conf *rest.Config, gv schema.GroupVersion) (*rest.RESTClient, error) {
 	scheme := runtime.NewScheme()
 	schemeBuilder := runtime.NewSchemeBuilder(createScheme)
 
 	err := schemeBuilder.AddToScheme(scheme)
 	if err != nil {
 		log.Printf("can not build scheme; err is: %v", err)
-		return err
-	}
-
-	conf, err := clientcmd.BuildConfigFromFlags(kubeMaster, kubeConfig)
-	if err != nil {
-		log.Fatalf("can not connect to kubernetes api server: %v", err)
-		return err
+		return nil, err
 	}
 
+	conf = rest.CopyConfig(conf)
 	conf.ContentType = runtime.ContentTypeJSON
 	conf.APIPath = "/apis"
-	conf.GroupVersion = &schema.GroupVersion{Group: "devices.kubeedge.io", Version: "v1alpha1"}
+	conf.GroupVersion = &gv
 	conf.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
 
-	kubernetesRestClient, err = rest.RESTClientFor(conf)
+	return rest.RESTClientFor(conf)
+}
+
+// connect creates the clients for the configured api server unless they were injected
+func (w *Watcher) connect() error {
+	if w.injected {
+		return nil
+	}
+
+	conf, err := clientcmd.BuildConfigFromFlags(w.kubeMaster, w.kubeConfig)
 	if err != nil {
-		log.Fatalf("can not create REST client, error is: %v", err)
+		log.Printf("can not connect to kubernetes api server: %v", err)
 		return err
 	}
 
-	lw := cache.NewListWatchFromClient(kubernetesRestClient, "devices", "default", fields.Everything())
-	si := cache.NewSharedInformer(lw, &typ.Device{}, 0)
-	reh := ResourceEventHandler{events: events}
-	si.AddEventHandler(reh)
-	stopNever := make(chan struct{})
-	go si.Run(stopNever)
+	clientset, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		log.Printf("can not create node clientset; err is: %v", err)
+		return err
+	}
 
-	watchNodes(kubeMaster, kubeConfig, ev)
+	restClient, err := NewDeviceRESTClient(conf)
+	if err != nil {
+		log.Printf("can not create REST client, error is: %v", err)
+		return err
+	}
 
+	configClient, err := NewExporterConfigRESTClient(conf)
+	if err != nil {
+		log.Printf("can not create REST client, error is: %v", err)
+		return err
+	}
+
+	w.stopMutex.Lock()
+	w.clientset = clientset
+	w.restClient = restClient
+	w.configClient = configClient
+	w.stopMutex.Unlock()
 	return nil
 }
 
-func watchNodes(kubeMaster string, kubeConfig string, ev chan awatch.Event) {
-	config, err := clientcmd.BuildConfigFromFlags(kubeMaster, kubeConfig)
-	if err != nil {
-		panic(err.Error())
+// Status returns the WatchStatus of the informers of w
+func (w *Watcher) Status() *WatchStatus {
+	return w.status
+}
+
+// Start starts the device and node informers, and the ExporterConfig informers if they are enabled; it does nothing
+// if they are already running
+func (w *Watcher) Start() {
+	w.stopMutex.Lock()
+	defer w.stopMutex.Unlock()
+	if w.stop != nil {
+		return
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
+	w.stop = make(chan struct{})
+	for _, ns := range w.watch.Namespaces {
+		name := "devices/" + ns
+		w.status.runInformer(name, w.status.newListWatch(name, w.restClient, "devices", ns, w.watch.LabelSelector), &typ.Device{}, w.handler(name, w.events), w.stop)
+	}
+	// the nodes are listed with the typed client, which the fake clientset implements as well
+	nodes := w.clientset.CoreV1().Nodes()
+	w.status.runInformer("nodes", w.status.recordListWatch("nodes", &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return nodes.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (awatch.Interface, error) {
+			return nodes.Watch(options)
+		},
+	}), &v1.Node{}, w.handler("nodes", w.ev), w.stop)
+	if w.watch.ExporterConfigs && w.configHandler != nil {
+		configs := make(chan awatch.Event)
+		for _, ns := range w.watch.Namespaces {
+			name := "exporterconfigs/" + ns
+			w.status.runInformer(name, w.status.newListWatch(name, w.configClient, "exporterconfigs", ns, ""), &typ.ExporterConfig{}, w.handler(name, configs), w.stop)
+		}
+		done, client, stop := make(chan struct{}), w.configClient, w.stop
+		w.configsDone = done
+		go func() {
+			defer close(done)
+			w.runExporterConfigs(configs, client, stop)
+		}()
 	}
+}
 
-	go passEvent(ev, clientset)
+// handler returns the event handler of the informer name, which passes its events to events until w is stopped
+func (w *Watcher) handler(name string, events chan awatch.Event) ResourceEventHandler {
+	return ResourceEventHandler{kind: name, events: events, stop: w.stop, fence: &w.fence}
 }
 
-func passEvent(ev chan awatch.Event, clientset *kubernetes.Clientset) {
-	watchInterface, err := clientset.CoreV1().Nodes().Watch(metav1.ListOptions{})
-	if err != nil {
-		panic(err.Error())
-	}
-	for {
-		wI := <-watchInterface.ResultChan()
-		if wI.Object == nil {
-			watchInterface, err = clientset.CoreV1().Nodes().Watch(metav1.ListOptions{})
-			if err != nil {
-				panic(err.Error())
-			}
-		}
-		ev <- wI
+// Stop stops all informers started by Start; no event of them is passed after it returns and the ExporterConfigs they
+// watched are removed
+func (w *Watcher) Stop() {
+	w.stopMutex.Lock()
+	defer w.stopMutex.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+		// no event of the stopped informers is passed once the handlers which are passing one have returned
+		w.fence.Lock()
+		w.fence.Unlock()
+	}
+	if w.configsDone != nil {
+		// the ExporterConfigs of the stopped informers do not apply anymore, e.g. if the next Start does not watch
+		// them or their namespaces
+		<-w.configsDone
+		w.configsDone = nil
+		w.configHandler(nil)
 	}
+	w.status.reset()
+}
+
+// Reconfigure stops the informers, applies opts and starts the informers again
+func (w *Watcher) Reconfigure(opts ...Option) error {
+	w.Stop()
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	w.Start()
+	return nil
 }
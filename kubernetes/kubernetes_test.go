@@ -0,0 +1,339 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	awatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ktesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// fakeAPIServer serves the fixtures in testdata; watches stay open without events until the client goes away
+func fakeAPIServer(t *testing.T) *httptest.Server {
+	devices := &typ.DeviceList{}
+	models := &typ.DeviceModelList{}
+	nodes := &v1.NodeList{}
+	for path, obj := range map[string]interface{}{"../testdata/devices.yaml": devices, "../testdata/devicemodels.yaml": models, "../testdata/nodes.yaml": nodes} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("can not read %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, obj); err != nil {
+			t.Fatalf("can not decode %s: %v", path, err)
+		}
+	}
+
+	byNamespace := func(ns string) *typ.DeviceList {
+		list := &typ.DeviceList{TypeMeta: devices.TypeMeta}
+		for _, dev := range devices.Items {
+			if dev.Namespace == ns {
+				list.Items = append(list.Items, dev)
+			}
+		}
+		return list
+	}
+
+	mux := http.NewServeMux()
+	serve := func(path string, obj func() interface{}) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("watch") == "true" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(obj()); err != nil {
+				t.Errorf("can not encode response: %v", err)
+			}
+		})
+	}
+	serve("/apis/devices.kubeedge.io/v1alpha1/namespaces/default/devices", func() interface{} { return byNamespace("default") })
+	serve("/apis/devices.kubeedge.io/v1alpha1/namespaces/plant-a/devices", func() interface{} { return byNamespace("plant-a") })
+	serve("/apis/devices.kubeedge.io/v1alpha1/devices", func() interface{} { return devices })
+	serve("/apis/devices.kubeedge.io/v1alpha1/devicemodels", func() interface{} { return models })
+	serve("/api/v1/nodes", func() interface{} { return nodes })
+	serve("/apis/exporter.kubeedge.io/v1alpha1/namespaces/plant-a/exporterconfigs", func() interface{} {
+		return &typ.ExporterConfigList{
+			TypeMeta: metav1.TypeMeta{APIVersion: "exporter.kubeedge.io/v1alpha1", Kind: "ExporterConfigList"},
+			Items:    []typ.ExporterConfig{exporterConfig("plant-a", "line-3", typ.ExporterConfigSpec{LabelSelector: "line=3"})},
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func fakeClients(t *testing.T, server *httptest.Server) Option {
+	conf := &rest.Config{Host: server.URL}
+	clientset, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		t.Fatalf("can not create clientset: %v", err)
+	}
+	restClient, err := NewDeviceRESTClient(conf)
+	if err != nil {
+		t.Fatalf("can not create device client: %v", err)
+	}
+	return WithClients(clientset, restClient)
+}
+
+func TestWatcherListsFixtures(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+
+	events := make(chan awatch.Event)
+	ev := make(chan awatch.Event)
+	w, err := NewWatcher(events, ev, fakeClients(t, server), WithWatch(config.Watch{Namespaces: []string{"default", "plant-a"}}))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	if w.Status().HasSynced() {
+		t.Errorf("watcher has synced before it was started")
+	}
+	w.Start()
+	defer w.Stop()
+
+	var devices, nodes []string
+	timeout := time.After(10 * time.Second)
+	for len(devices) < 3 || len(nodes) < 2 {
+		select {
+		case e := <-events:
+			dev := e.Object.(*typ.Device)
+			devices = append(devices, dev.Namespace+"/"+dev.Name)
+		case e := <-ev:
+			nodes = append(nodes, e.Object.(*v1.Node).Name)
+		case <-timeout:
+			t.Fatalf("timed out; got devices %v and nodes %v", devices, nodes)
+		}
+	}
+	sort.Strings(devices)
+	sort.Strings(nodes)
+	if want := []string{"default/counter", "default/sensor-tag01", "plant-a/broken"}; !equal(devices, want) {
+		t.Errorf("got devices %v, want %v", devices, want)
+	}
+	if want := []string{"edge-node-1", "edge-node-2"}; !equal(nodes, want) {
+		t.Errorf("got nodes %v, want %v", nodes, want)
+	}
+
+	for !w.Status().HasSynced() {
+		select {
+		case <-timeout:
+			t.Fatalf("informers did not sync")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := w.Status().Healthy(); err != nil {
+		t.Errorf("watcher is not healthy: %v", err)
+	}
+	if last := w.Status().LastSync(); last["nodes"].IsZero() || last["devices/default"].IsZero() {
+		t.Errorf("last sync times are missing: %v", last)
+	}
+}
+
+func TestWatcherWatchesNodes(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+	restClient, err := NewDeviceRESTClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("can not create device client: %v", err)
+	}
+	tracker := ktesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	if err := tracker.Add(testNode("edge-node-1")); err != nil {
+		t.Fatalf("can not add node: %v", err)
+	}
+	// like fake.NewSimpleClientset, but the watch is signalled, since nodes created before it would be lost
+	clientset := &fake.Clientset{}
+	clientset.AddReactor("*", "*", ktesting.ObjectReaction(tracker))
+	watching := make(chan struct{})
+	var once sync.Once
+	clientset.AddWatchReactor("*", func(action ktesting.Action) (bool, awatch.Interface, error) {
+		watch, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+		once.Do(func() { close(watching) })
+		return true, watch, err
+	})
+
+	ev := make(chan awatch.Event)
+	w, err := NewWatcher(make(chan awatch.Event, 10), ev, WithClients(clientset, restClient))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	next := func() awatch.Event {
+		select {
+		case e := <-ev:
+			return e
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for a node event")
+			return awatch.Event{}
+		}
+	}
+	if e := next(); e.Type != awatch.Added || e.Object.(*v1.Node).Name != "edge-node-1" {
+		t.Errorf("got %v %v, want the listed node", e.Type, e.Object.(*v1.Node).Name)
+	}
+	<-watching
+	if _, err := clientset.CoreV1().Nodes().Create(testNode("edge-node-2")); err != nil {
+		t.Fatalf("can not create node: %v", err)
+	}
+	if e := next(); e.Type != awatch.Added || e.Object.(*v1.Node).Name != "edge-node-2" {
+		t.Errorf("got %v %v, want the created node", e.Type, e.Object.(*v1.Node).Name)
+	}
+	if err := clientset.CoreV1().Nodes().Delete("edge-node-1", nil); err != nil {
+		t.Fatalf("can not delete node: %v", err)
+	}
+	if e := next(); e.Type != awatch.Deleted || e.Object.(*v1.Node).Name != "edge-node-1" {
+		t.Errorf("got %v %v, want the deleted node", e.Type, e.Object.(*v1.Node).Name)
+	}
+}
+
+func testNode(name string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func TestWatcherPassesNoEventsAfterStop(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+
+	// nobody receives the events, so the handlers block
+	events := make(chan awatch.Event)
+	ev := make(chan awatch.Event)
+	w, err := NewWatcher(events, ev, fakeClients(t, server), WithWatch(config.Watch{Namespaces: []string{"default"}}))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	w.Start()
+	timeout := time.After(10 * time.Second)
+	for !w.Status().HasSynced() {
+		select {
+		case <-timeout:
+			t.Fatalf("informers did not sync")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	w.Stop()
+
+	select {
+	case e := <-events:
+		t.Errorf("got device event %v after stop", e.Type)
+	case e := <-ev:
+		t.Errorf("got node event %v after stop", e.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatcherResetsExporterConfigsOnStop(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+	configClient, err := NewExporterConfigRESTClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("can not create client: %v", err)
+	}
+
+	handled := make(chan []typ.ExporterConfig, 10)
+	handler := func(configs []typ.ExporterConfig) map[string]error {
+		handled <- configs
+		return nil
+	}
+	w, err := NewWatcher(make(chan awatch.Event), make(chan awatch.Event), fakeClients(t, server), WithConfigClient(configClient),
+		WithExporterConfigs(handler), WithWatch(config.Watch{Namespaces: []string{"plant-a"}, ExporterConfigs: true}))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	w.Start()
+	select {
+	case configs := <-handled:
+		if len(configs) != 1 || configs[0].Name != "line-3" {
+			t.Errorf("got configs %+v", configs)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the handler was not called")
+	}
+
+	// the handler is called with no configs before Stop returns
+	w.Stop()
+	if len(handled) == 0 {
+		t.Fatalf("the handler was not called on stop")
+	}
+	var last []typ.ExporterConfig
+	for len(handled) > 0 {
+		last = <-handled
+	}
+	if len(last) != 0 {
+		t.Errorf("got configs %+v on stop, want none", last)
+	}
+}
+
+func TestWatcherReportsListErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	w, err := NewWatcher(make(chan awatch.Event), make(chan awatch.Event), fakeClients(t, server))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	timeout := time.After(10 * time.Second)
+	for w.Status().Healthy() == nil {
+		select {
+		case <-timeout:
+			t.Fatalf("watcher did not report the failing api server")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if w.Status().HasSynced() {
+		t.Errorf("watcher has synced although the api server fails")
+	}
+}
+
+func TestListDevicesAndModels(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+
+	w, err := NewWatcher(nil, nil, fakeClients(t, server))
+	if err != nil {
+		t.Fatalf("can not create watcher: %v", err)
+	}
+	devices, err := w.ListDevices("")
+	if err != nil || len(devices.Items) != 3 {
+		t.Errorf("got %v devices, %v", len(devices.Items), err)
+	}
+	models, err := w.ListDeviceModels("")
+	if err != nil || len(models.Items) != 2 || models.Items[0].Spec.Properties[0].Type.Int.Maximum != 60 {
+		t.Errorf("got models %+v, %v", models.Items, err)
+	}
+	if devices, err = w.ListDevices("plant-a"); err != nil || len(devices.Items) != 1 {
+		t.Errorf("got %v devices in plant-a, %v", len(devices.Items), err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
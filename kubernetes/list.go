@@ -0,0 +1,27 @@
+package kubernetes
+
+import (
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// ListDevices returns the devices of namespace; an empty namespace lists the devices of all namespaces
+func (w *Watcher) ListDevices(namespace string) (*typ.DeviceList, error) {
+	w.stopMutex.Lock()
+	client := w.restClient
+	w.stopMutex.Unlock()
+
+	list := &typ.DeviceList{}
+	err := client.Get().Namespace(namespace).Resource("devices").Do().Into(list)
+	return list, err
+}
+
+// ListDeviceModels returns the device models of namespace; an empty namespace lists the models of all namespaces
+func (w *Watcher) ListDeviceModels(namespace string) (*typ.DeviceModelList, error) {
+	w.stopMutex.Lock()
+	client := w.restClient
+	w.stopMutex.Unlock()
+
+	list := &typ.DeviceModelList{}
+	err := client.Get().Namespace(namespace).Resource("devicemodels").Do().Into(list)
+	return list, err
+}
@@ -3,32 +3,161 @@ package main
 import (
 	"log"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"k8s.io/apimachinery/pkg/watch"
 
 	flag "github.com/jessevdk/go-flags"
 
+	"github.com/subpathdev/cpu-kubeedge-exporter/alertmanager"
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/eventbus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/influxdb"
 	"github.com/subpathdev/cpu-kubeedge-exporter/kubernetes"
+	"github.com/subpathdev/cpu-kubeedge-exporter/otlp"
 	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/pushgateway"
+	"github.com/subpathdev/cpu-kubeedge-exporter/record"
+	"github.com/subpathdev/cpu-kubeedge-exporter/remotewrite"
 )
 
 var opts struct {
 	Server     string `short:"s" long:"server" required:"no" description:"kubernetes address of the kubernetes api server"`
 	ConfigPath string `short:"c" long:"configPath" required:"no" description:"path of the kuberentes config"`
-	Address    string `short:"a" long:"address" required:"yes" description:"listen address of the webserver"`
-	Port       int    `short:"p" long:"port" required:"yes" description:"listen port of the webserver"`
+	Address    string `short:"a" long:"address" required:"no" description:"listen address of the webserver"`
+	Port       int    `short:"p" long:"port" required:"no" description:"listen port of the webserver"`
+	Config     string `short:"f" long:"config" required:"no" description:"path of the YAML configuration file; the other flags override its settings"`
+	Record     string `long:"record" required:"no" description:"write every received device and node event to this JSON lines file"`
+
+	Replay        replayCommand        `command:"replay" description:"feed a recording into the exporter without an api server"`
+	Simulate      simulateCommand      `command:"simulate" description:"serve synthetic devices and nodes"`
+	GenerateRules generateRulesCommand `command:"generate-rules" description:"write prometheus alerting rules for the twins of the devices"`
+}
+
+// readConfig reads the configuration file and applies the flags on top of it without validating the result
+func readConfig() (config.Config, error) {
+	conf, err := config.Load(opts.Config)
+	if err != nil {
+		return conf, err
+	}
+	if opts.Server != "" {
+		conf.Server = opts.Server
+	}
+	if opts.ConfigPath != "" {
+		conf.KubeConfig = opts.ConfigPath
+	}
+	if opts.Address != "" {
+		conf.Address = opts.Address
+	}
+	if opts.Port != 0 {
+		conf.Port = opts.Port
+	}
+	return conf, nil
+}
+
+// loadConfig reads and validates the configuration
+func loadConfig() (config.Config, error) {
+	conf, err := readConfig()
+	if err != nil {
+		return conf, err
+	}
+	return conf, conf.Validate()
+}
+
+// reload applies configuration changes until the process exits
+func reload(conf config.Config, watcher *kubernetes.Watcher, exporter *prometheus.Exporter, configs *exporterConfigs) {
+	for range config.Notify(opts.Config, 5*time.Second) {
+		next, err := loadConfig()
+		if err != nil {
+			log.Printf("can not reload configuration, keep the old one; err is: %v", err)
+			continue
+		}
+		if conf.NeedsRestart(next) {
+			log.Printf("the webserver settings changed; they are applied after a restart")
+		}
+		if conf.ClientsChanged(next) {
+			log.Printf("the push, eventbus, history, threshold, write api, convergence or statistics settings changed; they are applied after a restart")
+		}
+		if conf.WatchChanged(next) {
+			log.Printf("the watch settings changed; restart the informers")
+			watcher.Stop()
+			exporter.Reset()
+			if err := watcher.Reconfigure(kubernetes.WithServer(next.Server), kubernetes.WithKubeConfig(next.KubeConfig), kubernetes.WithWatch(next.Watch)); err != nil {
+				log.Printf("can not restart the informers; err is: %v", err)
+			}
+		}
+		configs.setBase(next)
+		conf = next
+	}
+}
+
+// onShutdown calls fns in order when the process receives SIGINT or SIGTERM and exits afterwards
+func onShutdown(fns []func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Printf("received %v; shut down", s)
+		for _, fn := range fns {
+			fn()
+		}
+		os.Exit(0)
+	}()
+}
+
+// loadHistory restores the history of the twins from path if it exists
+func loadHistory(exporter *prometheus.Exporter, path string) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("can not open the history; err is: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := exporter.LoadHistory(f); err != nil {
+		log.Printf("can not load the history, start without it; err is: %v", err)
+	}
+}
+
+// saveHistory writes the history of the twins to a temporary file which replaces path afterwards
+func saveHistory(exporter *prometheus.Exporter, path string) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("can not save the history; err is: %v", err)
+		return
+	}
+	err = exporter.SaveHistory(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("can not save the history; err is: %v", err)
+		os.Remove(tmp)
+	}
 }
 
 func main() {
-	_, err := flag.Parse(&opts)
+	parser := flag.NewParser(&opts, flag.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.Parse()
 	if err != nil {
+		if _, ok := err.(*flag.Error); !ok {
+			log.Fatalf("%s failed; err is: %v", parser.Active.Name, err)
+		}
 		print := flag.WroteHelp(err)
 		args := []string{
 			"-h",
 		}
 		if !print {
-			_, err := flag.ParseArgs(&opts, args)
+			_, err := parser.ParseArgs(args)
 			if err != nil {
 				log.Panicf("unexpected error, err is: %v", err)
 			}
@@ -38,15 +167,100 @@ func main() {
 		}
 	}
 
-	b10 := []byte(":")
-	b10 = strconv.AppendInt(b10, int64(opts.Port), 10)
-	listen := opts.Address + string(b10)
+	if parser.Active != nil {
+		// the command has been executed by the parser
+		return
+	}
+
+	conf, err := loadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration; err is: %v", err)
+	}
 
 	events := make(chan watch.Event)
 	ev := make(chan watch.Event)
+	watched, watchedEv := events, ev
+	if opts.Record != "" {
+		f, err := os.Create(opts.Record)
+		if err != nil {
+			log.Fatalf("can not create recording; err is: %v", err)
+		}
+		defer f.Close()
+		w := record.NewWriter(f)
+		watched, watchedEv = make(chan watch.Event), make(chan watch.Event)
+		go record.Tee(w, record.Device, watched, events)
+		go record.Tee(w, record.Node, watchedEv, ev)
+	}
 
-	if err := kubernetes.Init(opts.Server, opts.ConfigPath, events, ev); err != nil {
+	configs := &exporterConfigs{base: conf}
+	watcher, err := kubernetes.NewWatcher(watched, watchedEv, kubernetes.WithServer(conf.Server), kubernetes.WithKubeConfig(conf.KubeConfig), kubernetes.WithWatch(conf.Watch), kubernetes.WithExporterConfigs(configs.handle))
+	if err != nil {
 		log.Panicf("clould not run successfully")
 	}
-	prometheus.Init(events, listen, ev)
+	exporterOpts := []prometheus.Option{
+		prometheus.WithStatus(watcher.Status()),
+		prometheus.WithRecorder(watcher),
+		prometheus.WithRender(conf.Render),
+		prometheus.WithHistory(conf.History),
+		prometheus.WithThresholds(conf.Thresholds),
+		prometheus.WithConvergence(conf.Convergence),
+		prometheus.WithStatistics(conf.Statistics),
+	}
+	if conf.WriteAPI != nil {
+		exporterOpts = append(exporterOpts, prometheus.WithWriteAPI(watcher, *conf.WriteAPI))
+	}
+	if conf.AlertWebhook != nil {
+		notifier := alertmanager.New(*conf.AlertWebhook)
+		go notifier.Run(make(chan struct{}))
+		exporterOpts = append(exporterOpts, prometheus.WithNotifier(notifier))
+	}
+	exporter := prometheus.NewExporter(exporterOpts...)
+	configs.setExporter(exporter)
+	var shutdown []func()
+	if conf.History.File != "" {
+		loadHistory(exporter, conf.History.File)
+		go func() {
+			for range time.Tick(time.Minute) {
+				saveHistory(exporter, conf.History.File)
+			}
+		}()
+		shutdown = append(shutdown, func() {
+			saveHistory(exporter, conf.History.File)
+		})
+	}
+	go exporter.Run(events, ev, make(chan struct{}))
+	if len(conf.RemoteWrite) > 0 {
+		go remotewrite.New(exporter.RemoteWriteMetrics, conf.RemoteWrite).Run(exporter.Subscribe(), make(chan struct{}))
+	}
+	if conf.InfluxDB != nil {
+		go influxdb.New(exporter.LineProtocol, *conf.InfluxDB).Run(make(chan struct{}))
+	}
+	if conf.OTLP != nil {
+		go otlp.New(exporter.OTLP, *conf.OTLP).Run(make(chan struct{}))
+	}
+	if conf.Pushgateway != nil {
+		stop, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			pushgateway.New(exporter.Metrics, *conf.Pushgateway).Run(stop)
+			close(done)
+		}()
+		shutdown = append(shutdown, func() {
+			close(stop)
+			<-done
+		})
+	}
+	if conf.EventBus != nil {
+		go eventbus.New(*conf.EventBus).Run(exporter, make(chan struct{}))
+	}
+	if len(shutdown) > 0 {
+		onShutdown(shutdown)
+	}
+	watcher.Start()
+
+	if opts.Config != "" {
+		go reload(conf, watcher, exporter, configs)
+	}
+	if err := prometheus.ListenAndServe(conf, exporter); err != nil {
+		log.Printf("could not run list and serve; error is: %v", err)
+	}
 }
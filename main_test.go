@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	flag "github.com/jessevdk/go-flags"
+)
+
+func TestFlagsOverrideConfigFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatalf("can not create file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("server: https://master\naddress: 127.0.0.1\nport: 9100\n"); err != nil {
+		t.Fatalf("can not write file: %v", err)
+	}
+	file.Close()
+
+	saved := opts
+	defer func() { opts = saved }()
+	parser := flag.NewParser(&opts, flag.None)
+	parser.SubcommandsOptional = true
+	if _, err := parser.ParseArgs([]string{"-f", file.Name(), "-p", "8080"}); err != nil {
+		t.Fatalf("can not parse flags: %v", err)
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		t.Fatalf("can not load configuration: %v", err)
+	}
+	if conf.Port != 8080 || conf.Address != "127.0.0.1" || conf.Server != "https://master" {
+		t.Errorf("got %v %v %v, want the port of the flag and the rest of the file", conf.Port, conf.Address, conf.Server)
+	}
+}
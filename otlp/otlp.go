@@ -0,0 +1,85 @@
+// Package otlp exports the twins to an OpenTelemetry OTLP/HTTP metrics receiver using the JSON encoding
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// Pusher sends the requests rendered by source, like prometheus.Exporter.OTLP, to a receiver
+type Pusher struct {
+	source   func(cluster string) []byte
+	conf     config.OTLP
+	interval time.Duration
+	client   *http.Client
+}
+
+// New creates a Pusher; conf has to be validated by config.Config.Validate
+func New(source func(cluster string) []byte, conf config.OTLP) *Pusher {
+	p := &Pusher{
+		source:   source,
+		conf:     conf,
+		interval: conf.Interval.Duration,
+		client:   &http.Client{Timeout: conf.Timeout.Duration},
+	}
+	if p.interval == 0 {
+		p.interval = defaultInterval
+	}
+	if p.client.Timeout == 0 {
+		p.client.Timeout = defaultTimeout
+	}
+	return p
+}
+
+// Push sends the current twins
+func (p *Pusher) Push() error {
+	req, err := http.NewRequest(http.MethodPost, p.conf.Endpoint, bytes.NewReader(p.source(p.conf.Cluster)))
+	if err != nil {
+		return err
+	}
+	for k, v := range p.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		// a partial success is reported in the body, but the rejected data points can not be sent again anyway
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	return fmt.Errorf("receiver returned %v: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// Run pushes the twins immediately and then every interval until stop is closed
+func (p *Pusher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Push(); err != nil {
+			log.Printf("can not export to the otlp receiver; err is: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package otlp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+func TestPush(t *testing.T) {
+	var got *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(data)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	var cluster string
+	source := func(c string) []byte {
+		cluster = c
+		return []byte(`{"resourceMetrics":[]}`)
+	}
+	p := New(source, config.OTLP{
+		Endpoint: server.URL + "/v1/metrics",
+		Cluster:  "edge-1",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+	})
+	if err := p.Push(); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if cluster != "edge-1" || got.URL.Path != "/v1/metrics" || body != `{"resourceMetrics":[]}` {
+		t.Errorf("unexpected request %v for cluster %q with body %q", got.URL, cluster, body)
+	}
+	if got.Header.Get("Content-Type") != "application/json" || got.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected headers %v", got.Header)
+	}
+}
+
+func TestPushReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+	}))
+	defer server.Close()
+
+	p := New(func(string) []byte { return nil }, config.OTLP{Endpoint: server.URL})
+	if err := p.Push(); err == nil {
+		t.Errorf("the rejected export was not reported")
+	}
+}
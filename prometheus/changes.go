@@ -0,0 +1,165 @@
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// deletedRetention is the time the counters of a deleted device are kept, so its last events can be scraped
+const deletedRetention = time.Hour
+
+// twinChanges counts the changes of the values of a twin
+type twinChanges struct {
+	reported, desired           string
+	reportedTotal, desiredTotal uint64
+	// timestamp is the timestamp of the reported value
+	timestamp string
+}
+
+// deviceChanges counts the events of a Device object
+type deviceChanges struct {
+	resourceVersion string
+	events          map[watch.EventType]uint64
+	twins           map[string]*twinChanges
+	// deleted is the time the device has been deleted; it is zero while the device exists
+	deleted time.Time
+}
+
+// changes keeps the change counters of the devices and their twins; the counters are kept when the informers resync
+// or restart, since the Device objects they send again have the resourceVersion which has already been counted
+type changes struct {
+	mutex   sync.Mutex
+	devices map[string]*deviceChanges
+}
+
+func (c *changes) device(key string) *deviceChanges {
+	d, ok := c.devices[key]
+	if !ok {
+		d = &deviceChanges{events: make(map[watch.EventType]uint64), twins: make(map[string]*twinChanges)}
+		c.devices[key] = d
+	}
+	return d
+}
+
+// event counts an event of the device key with resourceVersion; it returns false if the event repeats the last one,
+// like the events of a resync
+func (c *changes) event(key string, resourceVersion string, typ watch.EventType, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	d := c.device(key)
+	if resourceVersion != "" && resourceVersion == d.resourceVersion && (typ == watch.Deleted) == !d.deleted.IsZero() {
+		return false
+	}
+	d.resourceVersion = resourceVersion
+	d.events[typ]++
+	if typ == watch.Deleted {
+		d.deleted = now
+	} else {
+		d.deleted = time.Time{}
+	}
+	return true
+}
+
+// record counts the twins of devs whose reported or desired value differs from the last one; the first values of a
+// twin are not counted and the counters of the twins which are not in devs anymore are removed. It returns the twins
+// with a new reported value or timestamp, including the ones which are seen the first time.
+func (c *changes) record(key string, devs []Dev) []Dev {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	d := c.device(key)
+	names := make(map[string]bool, len(devs))
+	for _, v := range devs {
+		names[v.Name] = true
+	}
+	for name := range d.twins {
+		if !names[name] {
+			delete(d.twins, name)
+		}
+	}
+	var reported []Dev
+	for _, v := range devs {
+		timestamp := v.Actual.Metadata["timestamp"]
+		t, ok := d.twins[v.Name]
+		if !ok {
+			d.twins[v.Name] = &twinChanges{reported: v.Actual.Value, desired: v.Expected.Value, timestamp: timestamp}
+			reported = append(reported, v)
+			continue
+		}
+		if v.Actual.Value != t.reported || timestamp != t.timestamp {
+			reported = append(reported, v)
+			t.timestamp = timestamp
+		}
+		if v.Actual.Value != t.reported {
+			t.reported = v.Actual.Value
+			t.reportedTotal++
+		}
+		if v.Expected.Value != t.desired {
+			t.desired = v.Expected.Value
+			t.desiredTotal++
+		}
+	}
+	return reported
+}
+
+// prune removes the counters of the devices which have been deleted for longer than deletedRetention
+func (c *changes) prune(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, d := range c.devices {
+		if !d.deleted.IsZero() && now.Sub(d.deleted) > deletedRetention {
+			delete(c.devices, key)
+		}
+	}
+}
+
+// write appends kubeedge_device_events_total, kubeedge_twin_reported_changes_total and
+// kubeedge_twin_desired_changes_total to message
+func (c *changes) write(message *strings.Builder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.devices) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(c.devices))
+	for key := range c.devices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	message.WriteString("# HELP kubeedge_device_events_total events of the Device object, without the events of resyncs\n")
+	message.WriteString("# TYPE kubeedge_device_events_total counter\n")
+	for _, key := range keys {
+		d := c.devices[key]
+		for _, typ := range []watch.EventType{watch.Added, watch.Modified, watch.Deleted} {
+			if count, ok := d.events[typ]; ok {
+				fmt.Fprintf(message, "kubeedge_device_events_total{namespace=\"%v\",device=\"%v\",type=\"%v\"} %v\n", escapeLabel(deviceNamespace(key)), escapeLabel(deviceName(key)), typ, count)
+			}
+		}
+	}
+	for _, metric := range []struct {
+		name, help string
+		total      func(t *twinChanges) uint64
+	}{
+		{"kubeedge_twin_reported_changes_total", "changes of the reported value of the twin", func(t *twinChanges) uint64 { return t.reportedTotal }},
+		{"kubeedge_twin_desired_changes_total", "changes of the desired value of the twin", func(t *twinChanges) uint64 { return t.desiredTotal }},
+	} {
+		fmt.Fprintf(message, "# HELP %v %v\n", metric.name, metric.help)
+		fmt.Fprintf(message, "# TYPE %v counter\n", metric.name)
+		for _, key := range keys {
+			d := c.devices[key]
+			props := make([]string, 0, len(d.twins))
+			for prop := range d.twins {
+				props = append(props, prop)
+			}
+			sort.Strings(props)
+			for _, prop := range props {
+				fmt.Fprintf(message, "%v{namespace=\"%v\",device=\"%v\",property=\"%v\"} %v\n", metric.name, escapeLabel(deviceNamespace(key)), escapeLabel(deviceName(key)), escapeLabel(prop), metric.total(d.twins[prop]))
+			}
+		}
+	}
+}
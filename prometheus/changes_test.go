@@ -0,0 +1,59 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestChangesSurviveResync(t *testing.T) {
+	e := NewExporter()
+	events := make(chan watch.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.Run(events, make(chan watch.Event), stop)
+	send := func(typ watch.EventType, resourceVersion string, value int, desired string) {
+		device := testDevice("a", value)
+		device.ResourceVersion = resourceVersion
+		device.Status.Twins[0].Desired.Value = desired
+		events <- watch.Event{Type: typ, Object: device}
+		// the second event ensures that the first one has been processed
+		events <- watch.Event{Type: watch.Error, Object: device}
+	}
+
+	send(watch.Added, "1", 20, "20")
+	send(watch.Modified, "2", 21, "20")
+	send(watch.Modified, "3", 22, "25")
+	// a resync and a restart of the informers send the same object again
+	send(watch.Modified, "3", 22, "25")
+	e.Reset()
+	send(watch.Added, "3", 22, "25")
+
+	metrics := e.Metrics()
+	for _, want := range []string{
+		`kubeedge_device_events_total{namespace="default",device="a",type="ADDED"} 1`,
+		`kubeedge_device_events_total{namespace="default",device="a",type="MODIFIED"} 2`,
+		`kubeedge_twin_reported_changes_total{namespace="default",device="a",property="temperature"} 2`,
+		`kubeedge_twin_desired_changes_total{namespace="default",device="a",property="temperature"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+
+	// the counters of a removed twin are dropped while the device exists
+	device := testDevice("a", 22)
+	device.ResourceVersion = "4"
+	device.Status.Twins = nil
+	events <- watch.Event{Type: watch.Modified, Object: device}
+	events <- watch.Event{Type: watch.Error, Object: device}
+	if metrics := e.Metrics(); strings.Contains(metrics, `kubeedge_twin_reported_changes_total{`) {
+		t.Errorf("the counters of the removed twin are kept:\n%s", metrics)
+	}
+
+	send(watch.Deleted, "3", 22, "25")
+	if want := `kubeedge_device_events_total{namespace="default",device="a",type="DELETED"} 1`; !strings.Contains(e.Metrics(), want) {
+		t.Errorf("metrics do not contain %s", want)
+	}
+}
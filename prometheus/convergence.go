@@ -0,0 +1,213 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const defaultConvergenceTimeout = 10 * time.Minute
+
+var defaultConvergenceBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600}
+
+// WithConvergence configures the histogram of the time the devices take to apply a new desired value; conf has to be
+// validated by config.Config.Validate
+func WithConvergence(conf config.Convergence) Option {
+	return func(e *Exporter) {
+		e.convergence = newConvergence(conf)
+	}
+}
+
+// convergenceLabels are the labels of the series of kubeedge_twin_convergence_seconds
+type convergenceLabels struct {
+	model, property, node string
+}
+
+// change is a desired value which has not been reported yet
+type change struct {
+	labels  convergenceLabels
+	desired string
+	since   time.Time
+}
+
+type histogram struct {
+	// counts are the observations per bucket, not cumulative; the last one is the +Inf bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// convergence measures the time from a change of the desired value of a twin until the reported value matches it
+type convergence struct {
+	mutex   sync.Mutex
+	timeout time.Duration
+	buckets []float64
+	// desired are the last desired values and pending the unconverged changes, by device key and property
+	desired    map[string]string
+	pending    map[string]*change
+	histograms map[convergenceLabels]*histogram
+	timeouts   map[convergenceLabels]uint64
+	// series are the labels of all changes; their counters and pending gauges are written even if they are 0
+	series map[convergenceLabels]bool
+}
+
+func newConvergence(conf config.Convergence) *convergence {
+	c := &convergence{
+		timeout:    conf.Timeout.Duration,
+		buckets:    conf.Buckets,
+		desired:    make(map[string]string),
+		pending:    make(map[string]*change),
+		histograms: make(map[convergenceLabels]*histogram),
+		timeouts:   make(map[convergenceLabels]uint64),
+		series:     make(map[convergenceLabels]bool),
+	}
+	if c.timeout == 0 {
+		c.timeout = defaultConvergenceTimeout
+	}
+	if len(c.buckets) == 0 {
+		c.buckets = defaultConvergenceBuckets
+	}
+	return c
+}
+
+// update starts a change for every twin of devs whose desired value differs from the last one and observes the
+// changes whose reported value matches the desired value now; the first desired value of a twin only sets the baseline
+func (c *convergence) update(key string, devs []Dev, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, v := range devs {
+		name := key + "/" + v.Name
+		if v.Expected.Value == "" {
+			continue
+		}
+		last, known := c.desired[name]
+		c.desired[name] = v.Expected.Value
+		if known && last != v.Expected.Value {
+			// a change which is replaced before it converged is not observed
+			labels := convergenceLabels{model: v.Model, property: v.Name, node: strings.Join(nodeValues(v), ",")}
+			c.pending[name] = &change{labels: labels, desired: v.Expected.Value, since: now}
+			c.series[labels] = true
+		}
+		p, ok := c.pending[name]
+		if ok && converged(v, p.desired) {
+			c.observe(p.labels, now.Sub(p.since).Seconds())
+			delete(c.pending, name)
+		}
+	}
+}
+
+// converged reports whether the reported value of v matches desired; numbers are compared by their value
+func converged(v Dev, desired string) bool {
+	if v.Actual.Value == desired {
+		return true
+	}
+	actual, expected := historyValue(v.ValueTyp, v.Actual.Value), historyValue(v.ValueTyp, desired)
+	return !math.IsNaN(actual) && actual == expected
+}
+
+func (c *convergence) histogram(labels convergenceLabels) *histogram {
+	h, ok := c.histograms[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets)+1)}
+		c.histograms[labels] = h
+	}
+	return h
+}
+
+func (c *convergence) observe(labels convergenceLabels, seconds float64) {
+	h := c.histogram(labels)
+	i := sort.SearchFloat64s(c.buckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// expire counts the changes which have not converged within the timeout; they are not observed by the histogram,
+// which only contains the converged changes
+func (c *convergence) expire(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for name, p := range c.pending {
+		if now.Sub(p.since) < c.timeout {
+			continue
+		}
+		c.timeouts[p.labels]++
+		delete(c.pending, name)
+	}
+}
+
+// forget removes the twins of the device key
+func (c *convergence) forget(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	prefix := key + "/"
+	for name := range c.desired {
+		if strings.HasPrefix(name, prefix) {
+			delete(c.desired, name)
+		}
+	}
+	for name := range c.pending {
+		if strings.HasPrefix(name, prefix) {
+			delete(c.pending, name)
+		}
+	}
+}
+
+// write appends kubeedge_twin_convergence_seconds, kubeedge_twin_convergence_timeouts_total and
+// kubeedge_twin_convergence_pending to message
+func (c *convergence) write(message *strings.Builder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.series) == 0 {
+		return
+	}
+
+	series := make([]convergenceLabels, 0, len(c.series))
+	for l := range c.series {
+		series = append(series, l)
+	}
+	sortLabels(series)
+	pending := make(map[convergenceLabels]int)
+	for _, p := range c.pending {
+		pending[p.labels]++
+	}
+	message.WriteString("# HELP kubeedge_twin_convergence_seconds time from a change of the desired value until the reported value matches it\n")
+	message.WriteString("# TYPE kubeedge_twin_convergence_seconds histogram\n")
+	for _, l := range series {
+		h, ok := c.histograms[l]
+		if !ok {
+			continue
+		}
+		var cumulative uint64
+		for i, b := range c.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(message, "kubeedge_twin_convergence_seconds_bucket{%v,le=\"%v\"} %v\n", l, b, cumulative)
+		}
+		fmt.Fprintf(message, "kubeedge_twin_convergence_seconds_bucket{%v,le=\"+Inf\"} %v\n", l, h.count)
+		fmt.Fprintf(message, "kubeedge_twin_convergence_seconds_sum{%v} %v\n", l, h.sum)
+		fmt.Fprintf(message, "kubeedge_twin_convergence_seconds_count{%v} %v\n", l, h.count)
+	}
+	message.WriteString("# HELP kubeedge_twin_convergence_timeouts_total changes of the desired value which were not reported within the timeout\n")
+	message.WriteString("# TYPE kubeedge_twin_convergence_timeouts_total counter\n")
+	for _, l := range series {
+		fmt.Fprintf(message, "kubeedge_twin_convergence_timeouts_total{%v} %v\n", l, c.timeouts[l])
+	}
+	message.WriteString("# HELP kubeedge_twin_convergence_pending changes of the desired value which have not been reported yet\n")
+	message.WriteString("# TYPE kubeedge_twin_convergence_pending gauge\n")
+	for _, l := range series {
+		fmt.Fprintf(message, "kubeedge_twin_convergence_pending{%v} %v\n", l, pending[l])
+	}
+}
+
+func (l convergenceLabels) String() string {
+	return fmt.Sprintf("model=\"%v\",property=\"%v\",node=\"%v\"", escapeLabel(l.model), escapeLabel(l.property), escapeLabel(l.node))
+}
+
+func sortLabels(labels []convergenceLabels) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].String() < labels[j].String() })
+}
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func TestConvergence(t *testing.T) {
+	c := newConvergence(config.Convergence{Timeout: metav1.Duration{Duration: time.Minute}, Buckets: []float64{10, 30}})
+	now := time.Unix(1000, 0)
+	twin := func(actual, desired string) []Dev {
+		return []Dev{{Name: "temperature", Model: "sensor", ValueTyp: "int", Node: [][]string{{"edge-node"}},
+			Actual: typ.TwinValue{Value: actual}, Expected: typ.TwinValue{Value: desired}}}
+	}
+
+	// the first desired value is the baseline
+	c.update("default/a", twin("20", "20"), now)
+	c.update("default/a", twin("20", "25"), now)
+	c.update("default/a", twin("25.0", "25"), now.Add(20*time.Second))
+	// this change never converges
+	c.update("default/a", twin("25", "30"), now.Add(30*time.Second))
+
+	var message strings.Builder
+	c.write(&message)
+	labels := `model="sensor",property="temperature",node="edge-node"`
+	for _, want := range []string{
+		`kubeedge_twin_convergence_seconds_bucket{` + labels + `,le="10"} 0`,
+		`kubeedge_twin_convergence_seconds_bucket{` + labels + `,le="30"} 1`,
+		`kubeedge_twin_convergence_seconds_count{` + labels + `} 1`,
+		`kubeedge_twin_convergence_pending{` + labels + `} 1`,
+	} {
+		if !strings.Contains(message.String(), want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, message.String())
+		}
+	}
+
+	c.expire(now.Add(2 * time.Minute))
+	message.Reset()
+	c.write(&message)
+	for _, want := range []string{
+		`kubeedge_twin_convergence_seconds_bucket{` + labels + `,le="30"} 1`,
+		`kubeedge_twin_convergence_seconds_bucket{` + labels + `,le="+Inf"} 1`,
+		`kubeedge_twin_convergence_seconds_sum{` + labels + `} 20`,
+		`kubeedge_twin_convergence_seconds_count{` + labels + `} 1`,
+		`kubeedge_twin_convergence_timeouts_total{` + labels + `} 1`,
+		`kubeedge_twin_convergence_pending{` + labels + `} 0`,
+	} {
+		if !strings.Contains(message.String(), want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, message.String())
+		}
+	}
+
+	// a model whose changes only time out has no histogram
+	c.update("default/b", []Dev{{Name: "mode", Model: "pump", Expected: typ.TwinValue{Value: "on"}}}, now)
+	c.update("default/b", []Dev{{Name: "mode", Model: "pump", Expected: typ.TwinValue{Value: "off"}}}, now)
+	c.expire(now.Add(2 * time.Minute))
+	message.Reset()
+	c.write(&message)
+	pump := `model="pump",property="mode",node=""`
+	if !strings.Contains(message.String(), `kubeedge_twin_convergence_timeouts_total{`+pump+`} 1`) || strings.Contains(message.String(), `kubeedge_twin_convergence_seconds_count{`+pump) {
+		t.Errorf("unexpected metrics of a timed out change:\n%s", message.String())
+	}
+}
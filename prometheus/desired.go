@@ -0,0 +1,149 @@
+package prometheus
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+// maxDesiredBody limits the size of the requests of the write api
+const maxDesiredBody = 64 << 10
+
+// DesiredWriter sets the desired values of twins, like kubernetes.Watcher; the errors should be errors.StatusError,
+// whose code is returned to the caller
+type DesiredWriter interface {
+	SetDesired(namespace string, name string, property string, value string, resourceVersion string) (old string, updated string, err error)
+}
+
+// WithWriteAPI enables PUT /api/v1/devices/{namespace}/{name}/twins/{property}/desired, which sets desired values
+// with writer for the callers authenticated by conf
+func WithWriteAPI(writer DesiredWriter, conf config.WriteAPI) Option {
+	return func(e *Exporter) {
+		e.writer = writer
+		e.writeAPI = conf
+	}
+}
+
+// handleDevices serves the history and the write api below /api/v1/devices/
+func (e *Exporter) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/desired") {
+		e.handleDesired(w, r)
+		return
+	}
+	e.handleHistory(w, r)
+}
+
+// user returns the user of the bearer token of r, or false if the token is unknown
+func (e *Exporter) user(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range e.writeAPI.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+			return t.User, true
+		}
+	}
+	return "", false
+}
+
+// desiredRequest is the body of the write api; value may be a JSON string, number or boolean
+type desiredRequest struct {
+	Value           json.RawMessage `json:"value"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+}
+
+// handleDesired sets the desired value of a twin; the resourceVersion of the body or the If-Match header makes the
+// update fail with 409 if the device has been modified since
+func (e *Exporter) handleDesired(w http.ResponseWriter, r *http.Request) {
+	if e.writer == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, "only PUT is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/devices/"), "/")
+	if len(parts) != 5 || parts[2] != "twins" || parts[0] == "" || parts[1] == "" || parts[3] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	namespace, name, property := parts[0], parts[1], parts[3]
+
+	user, ok := e.user(r)
+	if !ok {
+		log.Printf("audit: rejected unauthenticated request from %s to set the desired value of twin %s of device %s/%s", r.RemoteAddr, property, namespace, name)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cpu-kubeedge-exporter"`)
+		http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+		return
+	}
+
+	var req desiredRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxDesiredBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return
+	}
+	value, err := desiredValue(req.Value)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid value: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ResourceVersion == "" {
+		req.ResourceVersion = strings.Trim(r.Header.Get("If-Match"), `"`)
+	}
+
+	old, updated, err := e.writer.SetDesired(namespace, name, property, value, req.ResourceVersion)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if status, ok := err.(errors.APIStatus); ok && status.Status().Code != 0 {
+			code = int(status.Status().Code)
+		}
+		log.Printf("audit: user %s failed to set the desired value of twin %s of device %s/%s to %q; err is: %v", user, property, namespace, name, value, err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	log.Printf("audit: user %s set the desired value of twin %s of device %s/%s from %q to %q, resourceVersion %s", user, property, namespace, name, old, value, updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+updated+`"`)
+	resp := map[string]string{
+		"namespace":       namespace,
+		"device":          name,
+		"property":        property,
+		"oldValue":        old,
+		"value":           value,
+		"resourceVersion": updated,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("could not write message; error is: %v", err)
+	}
+}
+
+// desiredValue converts the JSON value of a request into the string of a twin value
+func desiredValue(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", fmt.Errorf("value is missing")
+	}
+	switch raw[0] {
+	case '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case '{', '[', 'n':
+		return "", fmt.Errorf("value has to be a string, a number or a boolean")
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,78 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+type fakeWriter struct {
+	calls []string
+	err   error
+}
+
+func (w *fakeWriter) SetDesired(namespace, name, property, value, resourceVersion string) (string, string, error) {
+	w.calls = append(w.calls, strings.Join([]string{namespace, name, property, value, resourceVersion}, " "))
+	if w.err != nil {
+		return "", "", w.err
+	}
+	return "20", "43", nil
+}
+
+func TestHandleDesired(t *testing.T) {
+	writer := &fakeWriter{}
+	e := NewExporter(WithWriteAPI(writer, config.WriteAPI{Tokens: []config.APIToken{{User: "alice", Token: "secret"}}}))
+	put := func(method, token, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/devices/plant-a/sensor/twins/temperature/desired", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := put("PUT", "wrong", `{"value": 25}`, nil); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("got %v for an unknown token, want 401", rec.Code)
+	}
+	if rec := put("POST", "secret", `{"value": 25}`, nil); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "PUT" {
+		t.Errorf("got %v for POST, want 405", rec.Code)
+	}
+	if rec := put("PUT", "secret", `{"value": {}}`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("got %v for an object value, want 400", rec.Code)
+	}
+	if len(writer.calls) != 0 {
+		t.Fatalf("rejected requests were passed to the writer: %v", writer.calls)
+	}
+
+	rec := put("PUT", "secret", `{"value": 25}`, map[string]string{"If-Match": `"42"`})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %v: %s", rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["oldValue"] != "20" || resp["value"] != "25" || resp["resourceVersion"] != "43" {
+		t.Errorf("unexpected response %v", resp)
+	}
+	put("PUT", "secret", `{"value": "on", "resourceVersion": "43"}`, nil)
+	if want := "plant-a sensor temperature 25 42|plant-a sensor temperature on 43"; strings.Join(writer.calls, "|") != want {
+		t.Errorf("got calls %v, want %v", writer.calls, want)
+	}
+
+	writer.err = errors.NewConflict(schema.GroupResource{Group: "devices.kubeedge.io", Resource: "devices"}, "sensor", nil)
+	if rec := put("PUT", "secret", `{"value": 25, "resourceVersion": "1"}`, nil); rec.Code != http.StatusConflict {
+		t.Errorf("got %v for a conflict, want 409", rec.Code)
+	}
+}
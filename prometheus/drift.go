@@ -0,0 +1,43 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// writeNodes appends the time of the last event of every node of snap to message
+func writeNodes(message *strings.Builder, snap *snapshot) {
+	message.WriteString("# HELP kubeedge_node_last_update_timestamp_seconds time of the last event of the node\n")
+	message.WriteString("# TYPE kubeedge_node_last_update_timestamp_seconds gauge\n")
+	for _, name := range snap.nodeNames() {
+		fmt.Fprintf(message, "kubeedge_node_last_update_timestamp_seconds{node=\"%v\"} %v\n", escapeLabel(name), snap.nodes[name])
+	}
+}
+
+// writeDrift appends the difference between the reported and the desired value of every twin of snap in exported
+// which has numeric values to message; so the twins whose series are dropped by the relabel configs or above
+// maxSeries have no drift either. The values are transformed by rules like the twin series
+func writeDrift(message *strings.Builder, rules *renderRules, snap *snapshot, exported map[string]map[string]bool) {
+	message.WriteString("# HELP kubeedge_twin_drift reported minus desired value of the twin\n")
+	message.WriteString("# TYPE kubeedge_twin_drift gauge\n")
+	for _, key := range snap.deviceKeys() {
+		for _, v := range snap.devices[key] {
+			if !exported[key][v.Name] {
+				continue
+			}
+			actual, ok := sampleValue(v.ValueTyp, v.Actual.Value)
+			if !ok {
+				continue
+			}
+			desired, ok := sampleValue(v.ValueTyp, v.Expected.Value)
+			if !ok {
+				continue
+			}
+			a, _ := strconv.ParseFloat(actual, 64)
+			d, _ := strconv.ParseFloat(desired, 64)
+			a, d = rules.value(v, a), rules.value(v, d)
+			fmt.Fprintf(message, "kubeedge_twin_drift{namespace=\"%v\",device=\"%v\",property=\"%v\"} %v\n", escapeLabel(v.Namespace), escapeLabel(deviceName(key)), escapeLabel(v.Name), a-d)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+// fleetDevices are the labels of kubeedge_fleet_devices
+type fleetDevices struct {
+	namespace, model, node string
+}
+
+// fleetTwins are the labels of the aggregates of a property across the devices of a model
+type fleetTwins struct {
+	namespace, model, property string
+}
+
+// fleetValues aggregates the values of a property
+type fleetValues struct {
+	twins, outOfSync int
+	// min, max and sum are only set if count is not 0
+	min, max, sum float64
+	count         int
+}
+
+// writeFleet appends the aggregates of the devices of snap to message if they are enabled by conf; the reported values
+// are transformed by rules like the twin series
+func writeFleet(message *strings.Builder, conf config.Render, rules *renderRules, snap *snapshot) {
+	if conf.Aggregate == nil {
+		return
+	}
+	aggregated := make(map[string]bool, len(conf.Aggregate.Properties))
+	for _, prop := range conf.Aggregate.Properties {
+		aggregated[prop] = true
+	}
+
+	devices := make(map[fleetDevices]int)
+	twins := make(map[fleetTwins]*fleetValues)
+	// the devices are counted from their keys, so devices without twins are counted as well
+	for _, info := range snap.info {
+		if rules.exportsDevice(info) {
+			devices[fleetDevices{namespace: info.Namespace, model: info.Model, node: strings.Join(nodeValues(info), ",")}]++
+		}
+	}
+	for _, devs := range snap.devices {
+		for _, v := range devs {
+			if !rules.exports(v) {
+				continue
+			}
+			l := fleetTwins{namespace: v.Namespace, model: v.Model, property: v.Name}
+			agg, ok := twins[l]
+			if !ok {
+				agg = &fleetValues{}
+				twins[l] = agg
+			}
+			agg.twins++
+			if v.Expected.Value != "" && !converged(v, v.Expected.Value) {
+				agg.outOfSync++
+			}
+			if len(aggregated) > 0 && !aggregated[v.Name] {
+				continue
+			}
+			value := rules.value(v, historyValue(v.ValueTyp, v.Actual.Value))
+			if math.IsNaN(value) {
+				continue
+			}
+			if agg.count == 0 || value < agg.min {
+				agg.min = value
+			}
+			if agg.count == 0 || value > agg.max {
+				agg.max = value
+			}
+			agg.sum += value
+			agg.count++
+		}
+	}
+
+	deviceLabels := make([]fleetDevices, 0, len(devices))
+	for l := range devices {
+		deviceLabels = append(deviceLabels, l)
+	}
+	sort.Slice(deviceLabels, func(i, j int) bool {
+		a, b := deviceLabels[i], deviceLabels[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.model != b.model {
+			return a.model < b.model
+		}
+		return a.node < b.node
+	})
+	message.WriteString("# HELP kubeedge_fleet_devices number of devices per namespace, model and node\n")
+	message.WriteString("# TYPE kubeedge_fleet_devices gauge\n")
+	for _, l := range deviceLabels {
+		fmt.Fprintf(message, "kubeedge_fleet_devices{namespace=\"%v\",model=\"%v\",node=\"%v\"} %v\n", escapeLabel(l.namespace), escapeLabel(l.model), escapeLabel(l.node), devices[l])
+	}
+
+	twinLabels := make([]fleetTwins, 0, len(twins))
+	for l := range twins {
+		twinLabels = append(twinLabels, l)
+	}
+	sort.Slice(twinLabels, func(i, j int) bool {
+		a, b := twinLabels[i], twinLabels[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.model != b.model {
+			return a.model < b.model
+		}
+		return a.property < b.property
+	})
+	message.WriteString("# HELP kubeedge_fleet_twins number of twins of the property per namespace and model\n")
+	message.WriteString("# TYPE kubeedge_fleet_twins gauge\n")
+	for _, l := range twinLabels {
+		fmt.Fprintf(message, "kubeedge_fleet_twins{%v} %v\n", l, twins[l].twins)
+	}
+	message.WriteString("# HELP kubeedge_fleet_twins_out_of_sync number of twins whose reported value differs from the desired value\n")
+	message.WriteString("# TYPE kubeedge_fleet_twins_out_of_sync gauge\n")
+	for _, l := range twinLabels {
+		fmt.Fprintf(message, "kubeedge_fleet_twins_out_of_sync{%v} %v\n", l, twins[l].outOfSync)
+	}
+	for _, metric := range []struct {
+		name, help string
+		value      func(agg *fleetValues) float64
+	}{
+		{"kubeedge_fleet_twin_min", "minimum of the reported values of the property across the devices of the model", func(agg *fleetValues) float64 { return agg.min }},
+		{"kubeedge_fleet_twin_max", "maximum of the reported values of the property across the devices of the model", func(agg *fleetValues) float64 { return agg.max }},
+		{"kubeedge_fleet_twin_avg", "mean of the reported values of the property across the devices of the model", func(agg *fleetValues) float64 { return agg.sum / float64(agg.count) }},
+	} {
+		fmt.Fprintf(message, "# HELP %v %v\n", metric.name, metric.help)
+		fmt.Fprintf(message, "# TYPE %v gauge\n", metric.name)
+		for _, l := range twinLabels {
+			if agg := twins[l]; agg.count > 0 {
+				fmt.Fprintf(message, "%v{%v} %v\n", metric.name, l, metric.value(agg))
+			}
+		}
+	}
+}
+
+func (l fleetTwins) String() string {
+	return fmt.Sprintf("namespace=\"%v\",model=\"%v\",property=\"%v\"", escapeLabel(l.namespace), escapeLabel(l.model), escapeLabel(l.property))
+}
@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+func TestFleetAggregates(t *testing.T) {
+	e := NewExporter(WithRender(config.Render{Aggregate: &config.Aggregate{}}))
+	e.store.update(func(next *snapshot) {
+		for name, value := range map[string]int{"a": 10, "b": 20, "c": 30} {
+			device := testDevice(name, value)
+			device.Spec.DeviceModelRef = &v1.LocalObjectReference{Name: "sensor"}
+			next.devices["default/"+name] = buildDevs(device)
+			next.info["default/"+name] = deviceInfo(device)
+		}
+		// a device without twins is counted as well
+		device := testDevice("d", 0)
+		device.Spec.DeviceModelRef = &v1.LocalObjectReference{Name: "sensor"}
+		device.Status.Twins = nil
+		next.devices["default/d"] = buildDevs(device)
+		next.info["default/d"] = deviceInfo(device)
+	})
+
+	metrics := e.Metrics()
+	for _, want := range []string{
+		`kubeedge_fleet_devices{namespace="default",model="sensor",node="edge-node"} 4`,
+		`kubeedge_fleet_twins{namespace="default",model="sensor",property="temperature"} 3`,
+		`kubeedge_fleet_twins_out_of_sync{namespace="default",model="sensor",property="temperature"} 2`,
+		`kubeedge_fleet_twin_min{namespace="default",model="sensor",property="temperature"} 10`,
+		`kubeedge_fleet_twin_max{namespace="default",model="sensor",property="temperature"} 30`,
+		`kubeedge_fleet_twin_avg{namespace="default",model="sensor",property="temperature"} 20`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+
+	e.SetRender(config.Render{Aggregate: &config.Aggregate{Properties: []string{"humidity"}}})
+	metrics = e.Metrics()
+	if strings.Contains(metrics, "kubeedge_fleet_twin_avg{") || !strings.Contains(metrics, "kubeedge_fleet_twins{") {
+		t.Errorf("the value aggregates of temperature are exported although it is not selected")
+	}
+
+	// devices which are not selected are not counted
+	e.SetRender(config.Render{Aggregate: &config.Aggregate{}, Selections: []config.Selection{{Namespaces: []string{"plant-b"}}}})
+	metrics = e.Metrics()
+	if strings.Contains(metrics, "kubeedge_fleet_devices{") {
+		t.Errorf("the devices are counted although they are not selected")
+	}
+
+	// the aggregates use the units of the twin series
+	scale := 0.1
+	e.SetRender(config.Render{Aggregate: &config.Aggregate{}, Transforms: []config.Transform{{Property: "temperature", Scale: &scale}}})
+	metrics = e.Metrics()
+	for _, want := range []string{
+		`kubeedge_fleet_twin_min{namespace="default",model="sensor",property="temperature"} 1`,
+		`kubeedge_fleet_twin_max{namespace="default",model="sensor",property="temperature"} 3`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
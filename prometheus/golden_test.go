@@ -0,0 +1,150 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// selfMetrics are the prefixes of the metrics whose values change between runs
+var selfMetrics = []string{"go_", "process_", "cpu_kubeedge_exporter_"}
+
+// loadFixtures reads the devices and nodes of the YAML fixtures in testdata
+func loadFixtures(t *testing.T) (*typ.DeviceList, *v1.NodeList) {
+	devices := &typ.DeviceList{}
+	nodes := &v1.NodeList{}
+	for path, obj := range map[string]interface{}{"../testdata/devices.yaml": devices, "../testdata/nodes.yaml": nodes} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("can not read %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, obj); err != nil {
+			t.Fatalf("can not decode %s: %v", path, err)
+		}
+	}
+	return devices, nodes
+}
+
+// fixtureExporter returns an Exporter which has processed all fixtures
+func fixtureExporter(t *testing.T) *Exporter {
+	devices, nodes := loadFixtures(t)
+
+	e := NewExporter()
+	e.now = func() time.Time {
+		return time.Unix(1500000000, 0)
+	}
+	events := make(chan watch.Event)
+	eve := make(chan watch.Event)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		e.Run(events, eve, stop)
+		close(done)
+	}()
+	for i := range nodes.Items {
+		eve <- watch.Event{Type: watch.Added, Object: &nodes.Items[i]}
+	}
+	for i := range devices.Items {
+		events <- watch.Event{Type: watch.Added, Object: &devices.Items[i]}
+	}
+	close(stop)
+	<-done
+	return e
+}
+
+// withoutSelfMetrics removes the metrics about the exporter itself from the text format in metrics
+func withoutSelfMetrics(metrics string) string {
+	var ret []string
+lines:
+	for _, line := range strings.Split(metrics, "\n") {
+		name := line
+		if strings.HasPrefix(line, "# ") {
+			fields := strings.Fields(line)
+			if len(fields) > 2 {
+				name = fields[2]
+			}
+		}
+		for _, prefix := range selfMetrics {
+			if strings.HasPrefix(name, prefix) {
+				continue lines
+			}
+		}
+		ret = append(ret, line)
+	}
+	return strings.Join(ret, "\n")
+}
+
+func compareGolden(t *testing.T, path string, got string) {
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("can not update %s: %v", path, err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("can not read %s: %v", path, err)
+	}
+	if !bytes.Equal(want, []byte(got)) {
+		t.Errorf("output differs from %s; run go test -update to accept it\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func get(t *testing.T, h http.Handler, path string) string {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s returned %v: %s", path, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func TestGoldenMetrics(t *testing.T) {
+	e := fixtureExporter(t)
+	compareGolden(t, "../testdata/metrics.golden", withoutSelfMetrics(get(t, e, "/metrics")))
+	if got := e.DeviceMetrics(); got != withoutSelfMetrics(e.Metrics()) {
+		t.Errorf("the device metrics differ from the metrics without the self metrics:\n%s", got)
+	}
+}
+
+func TestGoldenRemoteWrite(t *testing.T) {
+	e := fixtureExporter(t)
+	compareGolden(t, "../testdata/remotewrite.golden", e.RemoteWriteMetrics())
+}
+
+func TestGoldenIndex(t *testing.T) {
+	e := fixtureExporter(t)
+	compareGolden(t, "../testdata/index.golden", get(t, e, "/"))
+}
+
+func TestGoldenInflux(t *testing.T) {
+	e := fixtureExporter(t)
+	compareGolden(t, "../testdata/influx.golden", get(t, e, "/influx"))
+
+	e.SetRender(config.Render{InfluxMeasurement: "model", Labels: []string{"site"}})
+	compareGolden(t, "../testdata/influx-model.golden", e.LineProtocol())
+}
+
+func TestGoldenOTLP(t *testing.T) {
+	e := fixtureExporter(t)
+	var out bytes.Buffer
+	if err := json.Indent(&out, e.OTLP("edge-1"), "", "  "); err != nil {
+		t.Fatalf("the request is no valid JSON: %v", err)
+	}
+	out.WriteByte('\n')
+	compareGolden(t, "../testdata/otlp.golden", out.String())
+}
@@ -0,0 +1,491 @@
+package prometheus
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const (
+	defaultHistoryMaxAge     = 24 * time.Hour
+	defaultHistoryMaxSamples = 1000
+	defaultHistoryMaxBytes   = 16 << 20
+	// pointSize is the memory used by one point
+	pointSize = 24
+)
+
+// point is a sample of a twin; missing values are NaN
+type point struct {
+	T        int64 // milliseconds since the epoch
+	Actual   float64
+	Expected float64
+}
+
+// ring keeps the latest points of a twin in the order of their time
+type ring struct {
+	points []point
+	start  int
+	n      int
+}
+
+func (r *ring) at(i int) point {
+	return r.points[(r.start+i)%len(r.points)]
+}
+
+func (r *ring) last() (point, bool) {
+	if r.n == 0 {
+		return point{}, false
+	}
+	return r.at(r.n - 1), true
+}
+
+// push appends p; if the ring has reached capacity the oldest point is overwritten and evicted is true
+func (r *ring) push(p point, capacity int) (evicted bool) {
+	if r.n == len(r.points) && len(r.points) < capacity {
+		// the ring grows by doubling until it reaches its capacity
+		size := 2 * len(r.points)
+		if size < 4 {
+			size = 4
+		}
+		if size > capacity {
+			size = capacity
+		}
+		points := make([]point, size)
+		copy(points, r.points[r.start:])
+		copy(points[len(r.points)-r.start:], r.points[:r.start])
+		r.points, r.start = points, 0
+	}
+	if r.n < len(r.points) {
+		r.points[(r.start+r.n)%len(r.points)] = p
+		r.n++
+		return false
+	}
+	r.points[r.start] = p
+	r.start = (r.start + 1) % len(r.points)
+	return true
+}
+
+// dropFront removes the k oldest points
+func (r *ring) dropFront(k int) {
+	r.start = (r.start + k) % len(r.points)
+	r.n -= k
+	if r.n == 0 {
+		r.points, r.start = nil, 0
+	}
+}
+
+// historyRing is the ring of a twin in the history
+type historyRing struct {
+	ring
+	key string
+	// index is the position of the ring in history.oldest
+	index int
+}
+
+// ringHeap orders the rings by their oldest point; the rings are never empty
+type ringHeap []*historyRing
+
+func (h ringHeap) Len() int           { return len(h) }
+func (h ringHeap) Less(i, j int) bool { return h[i].at(0).T < h[j].at(0).T }
+func (h ringHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *ringHeap) Push(x interface{}) {
+	r := x.(*historyRing)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *ringHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return r
+}
+
+// history keeps the recent values of every numeric twin, limited by age, by number per twin and by memory
+type history struct {
+	mutex      sync.Mutex
+	maxAge     time.Duration
+	maxSamples int
+	maxBytes   int
+	// rings are stored under the device key and the property name separated by a slash
+	rings map[string]*historyRing
+	// oldest orders the rings by their oldest point, to remove the oldest points above the memory limit
+	oldest ringHeap
+	total  int
+}
+
+func newHistory(conf config.History) *history {
+	h := &history{
+		maxAge:     conf.MaxAge.Duration,
+		maxSamples: conf.MaxSamples,
+		maxBytes:   conf.MaxBytes,
+		rings:      make(map[string]*historyRing),
+	}
+	if h.maxAge == 0 {
+		h.maxAge = defaultHistoryMaxAge
+	}
+	if h.maxSamples == 0 {
+		h.maxSamples = defaultHistoryMaxSamples
+	}
+	if h.maxBytes == 0 {
+		h.maxBytes = defaultHistoryMaxBytes
+	}
+	return h
+}
+
+// WithHistory sets the retention limits of the history of the twins
+func WithHistory(conf config.History) Option {
+	return func(e *Exporter) {
+		e.history = newHistory(conf)
+	}
+}
+
+// record appends the values of the twins devs of the device key if they have changed; the time of a value is taken
+// from the timestamp of the reported metadata, or now. Only the written rings are checked for expired points, the
+// others are checked by prune.
+func (h *history) record(key string, devs []Dev, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	cutoff := h.cutoff(now)
+	for _, v := range devs {
+		p := point{T: now.UnixNano() / int64(time.Millisecond), Actual: historyValue(v.ValueTyp, v.Actual.Value), Expected: historyValue(v.ValueTyp, v.Expected.Value)}
+		if math.IsNaN(p.Actual) && math.IsNaN(p.Expected) {
+			continue
+		}
+		if ts, err := strconv.ParseInt(v.Actual.Metadata["timestamp"], 10, 64); err == nil {
+			p.T = ts
+		}
+
+		r := h.rings[key+"/"+v.Name]
+		if last, ok := r.lastPoint(); ok && (p.T < last.T || sameFloat(p.Actual, last.Actual) && sameFloat(p.Expected, last.Expected)) {
+			// unchanged values and values which are older than the last one, e.g. from an outdated Device object
+			continue
+		}
+		r = h.push(key+"/"+v.Name, p)
+		h.expireRing(r, cutoff)
+	}
+	h.limitBytes()
+}
+
+// lastPoint returns the newest point of r, which may be nil
+func (r *historyRing) lastPoint() (point, bool) {
+	if r == nil {
+		return point{}, false
+	}
+	return r.last()
+}
+
+// push appends p to the ring of the twin and returns the ring
+func (h *history) push(twin string, p point) *historyRing {
+	r, ok := h.rings[twin]
+	if !ok {
+		r = &historyRing{key: twin}
+		h.rings[twin] = r
+	}
+	if r.push(p, h.maxSamples) {
+		// the oldest point has been overwritten
+		heap.Fix(&h.oldest, r.index)
+		return r
+	}
+	h.total++
+	if !ok {
+		heap.Push(&h.oldest, r)
+	}
+	return r
+}
+
+// cutoff returns the time in milliseconds before which points are expired
+func (h *history) cutoff(now time.Time) int64 {
+	return now.Add(-h.maxAge).UnixNano() / int64(time.Millisecond)
+}
+
+// dropFront removes the k oldest points of r and removes r if it is empty afterwards
+func (h *history) dropFront(r *historyRing, k int) {
+	r.dropFront(k)
+	h.total -= k
+	if r.n == 0 {
+		heap.Remove(&h.oldest, r.index)
+		delete(h.rings, r.key)
+		return
+	}
+	heap.Fix(&h.oldest, r.index)
+}
+
+// expireRing removes the points of r which are older than cutoff
+func (h *history) expireRing(r *historyRing, cutoff int64) {
+	k := 0
+	for k < r.n && r.at(k).T < cutoff {
+		k++
+	}
+	if k > 0 {
+		h.dropFront(r, k)
+	}
+}
+
+// limitBytes removes the oldest points of all twins above the memory limit
+func (h *history) limitBytes() {
+	for h.total*pointSize > h.maxBytes && len(h.oldest) > 0 {
+		h.dropFront(h.oldest[0], 1)
+	}
+}
+
+// expire removes the points of all twins which are older than the maximum age and the oldest points above the
+// memory limit
+func (h *history) expire(now time.Time) {
+	cutoff := h.cutoff(now)
+	for _, r := range h.rings {
+		h.expireRing(r, cutoff)
+	}
+	h.limitBytes()
+}
+
+// prune removes the expired points
+func (h *history) prune(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.expire(now)
+}
+
+// forget removes the history of the device key
+func (h *history) forget(key string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for k, r := range h.rings {
+		if strings.HasPrefix(k, key+"/") {
+			h.dropFront(r, r.n)
+		}
+	}
+}
+
+// query returns the points of the twin in the time range [start, end]; if step is positive the points are
+// downsampled into buckets of step, which are aggregated with agg: last, avg, min or max
+func (h *history) query(twin string, start, end time.Time, step time.Duration, agg string) ([]point, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	r, ok := h.rings[twin]
+	if !ok {
+		return nil, false
+	}
+	from, to := start.UnixNano()/int64(time.Millisecond), end.UnixNano()/int64(time.Millisecond)
+	stepMs := int64(step / time.Millisecond)
+
+	ret := []point{}
+	var bucket []point
+	flush := func() {
+		if len(bucket) == 0 {
+			return
+		}
+		p := point{T: bucket[0].T - (bucket[0].T-from)%stepMs}
+		p.Actual = aggregate(bucket, agg, func(p point) float64 { return p.Actual })
+		p.Expected = aggregate(bucket, agg, func(p point) float64 { return p.Expected })
+		ret = append(ret, p)
+		bucket = bucket[:0]
+	}
+	for i := 0; i < r.n; i++ {
+		p := r.at(i)
+		if p.T < from || p.T > to {
+			continue
+		}
+		if stepMs <= 0 {
+			ret = append(ret, p)
+			continue
+		}
+		if len(bucket) > 0 && (p.T-from)/stepMs != (bucket[0].T-from)/stepMs {
+			flush()
+		}
+		bucket = append(bucket, p)
+	}
+	if stepMs > 0 {
+		flush()
+	}
+	return ret, true
+}
+
+// aggregate combines the values of points selected by value; NaN values are ignored
+func aggregate(points []point, agg string, value func(point) float64) float64 {
+	ret, n := math.NaN(), 0
+	for _, p := range points {
+		v := value(p)
+		if math.IsNaN(v) {
+			continue
+		}
+		n++
+		switch {
+		case n == 1 || agg == "last":
+			ret = v
+		case agg == "min":
+			ret = math.Min(ret, v)
+		case agg == "max":
+			ret = math.Max(ret, v)
+		default:
+			ret += v
+		}
+	}
+	if agg == "avg" && n > 0 {
+		ret /= float64(n)
+	}
+	return ret
+}
+
+// historyValue converts a twin value into a number; values which are not numbers are NaN
+func historyValue(valueTyp string, value string) float64 {
+	sample, ok := sampleValue(valueTyp, value)
+	if !ok {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(sample, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func sameFloat(a, b float64) bool {
+	return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
+
+// SaveHistory writes the history of all twins to w; it can be restored with LoadHistory
+func (e *Exporter) SaveHistory(w io.Writer) error {
+	e.history.mutex.Lock()
+	data := make(map[string][]point, len(e.history.rings))
+	for key, r := range e.history.rings {
+		points := make([]point, r.n)
+		for i := range points {
+			points[i] = r.at(i)
+		}
+		data[key] = points
+	}
+	e.history.mutex.Unlock()
+	return gob.NewEncoder(w).Encode(data)
+}
+
+// LoadHistory replaces the history with the history saved by SaveHistory; the current limits are applied
+func (e *Exporter) LoadHistory(r io.Reader) error {
+	var data map[string][]point
+	if err := gob.NewDecoder(r).Decode(&data); err != nil {
+		return err
+	}
+	h := e.history
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.rings = make(map[string]*historyRing, len(data))
+	h.oldest = nil
+	h.total = 0
+	for key, points := range data {
+		for _, p := range points {
+			h.push(key, p)
+		}
+	}
+	h.expire(e.now())
+	return nil
+}
+
+// historyPoint is a point in the response of the history endpoint
+type historyPoint struct {
+	Time     time.Time `json:"time"`
+	Actual   *float64  `json:"actual,omitempty"`
+	Expected *float64  `json:"expected,omitempty"`
+}
+
+// handleHistory serves /api/v1/devices/{name}/twins/{prop}/history?namespace=&start=&end=&step=&aggregation=;
+// start and end are RFC 3339 times or unix timestamps, step is a duration like 5m
+func (e *Exporter) handleHistory(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/devices/")
+	parts := strings.SplitN(path, "/twins/", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[0], "/") || !strings.HasSuffix(parts[1], "/history") {
+		http.NotFound(w, r)
+		return
+	}
+	device, prop := parts[0], strings.TrimSuffix(parts[1], "/history")
+
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+	now := e.now()
+	start, err := parseTime(query.Get("start"), time.Unix(0, 0))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid start: %v", err), http.StatusBadRequest)
+		return
+	}
+	end, err := parseTime(query.Get("end"), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid end: %v", err), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if s := query.Get("step"); s != "" {
+		if step, err = time.ParseDuration(s); err != nil || step < time.Millisecond {
+			http.Error(w, "invalid step: it has to be a duration of at least 1ms", http.StatusBadRequest)
+			return
+		}
+	}
+	agg := query.Get("aggregation")
+	switch agg {
+	case "":
+		agg = "avg"
+	case "avg", "last", "min", "max":
+	default:
+		http.Error(w, "invalid aggregation: it has to be avg, last, min or max", http.StatusBadRequest)
+		return
+	}
+
+	points, ok := e.history.query(namespace+"/"+device+"/"+prop, start, end, step, agg)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no history for twin %s of device %s/%s", prop, namespace, device), http.StatusNotFound)
+		return
+	}
+	resp := struct {
+		Namespace string         `json:"namespace"`
+		Device    string         `json:"device"`
+		Property  string         `json:"property"`
+		Points    []historyPoint `json:"points"`
+	}{namespace, device, prop, make([]historyPoint, 0, len(points))}
+	for _, p := range points {
+		resp.Points = append(resp.Points, historyPoint{
+			Time:     time.Unix(0, p.T*int64(time.Millisecond)).UTC(),
+			Actual:   optionalFloat(p.Actual),
+			Expected: optionalFloat(p.Expected),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("could not write message; error is: %v", err)
+	}
+}
+
+// parseTime parses an RFC 3339 time or a unix timestamp in seconds; def is returned for an empty string
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func optionalFloat(f float64) *float64 {
+	if math.IsNaN(f) {
+		return nil
+	}
+	return &f
+}
@@ -0,0 +1,171 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func historyDev(value string, ts string) []Dev {
+	return []Dev{{Name: "temperature", ValueTyp: "int", Actual: withTimestamp(value, ts), Expected: typ.TwinValue{Value: "20"}}}
+}
+
+func TestHistoryLimits(t *testing.T) {
+	now := time.Unix(20, 0)
+	h := newHistory(config.History{MaxSamples: 3, MaxAge: metav1.Duration{Duration: 50 * time.Second}})
+	for i, v := range []string{"1", "1", "2", "3", "4", "5"} {
+		h.record("default/a", historyDev(v, fmt.Sprint(10000+i)), now)
+	}
+	points, _ := h.query("default/a/temperature", time.Unix(0, 0), now, 0, "")
+	if len(points) != 3 || points[0].Actual != 3 || points[2].Actual != 5 || points[2].Expected != 20 {
+		t.Errorf("unexpected points %+v", points)
+	}
+
+	// the values are older than maxAge
+	h.prune(time.Unix(61, 0))
+	if _, ok := h.query("default/a/temperature", time.Unix(0, 0), now, 0, ""); ok || h.total != 0 {
+		t.Errorf("expired values were kept, %v remain", h.total)
+	}
+
+	now = time.Unix(100, 0)
+	h = newHistory(config.History{MaxBytes: 3 * pointSize})
+	h.record("default/a", historyDev("1", "1000"), now)
+	h.record("default/b", historyDev("1", "2000"), now)
+	h.record("default/a", historyDev("2", "3000"), now)
+	h.record("default/b", historyDev("2", "4000"), now)
+	if _, ok := h.rings["default/a/temperature"]; h.total != 3 || h.rings["default/a/temperature"].at(0).T != 3000 || !ok {
+		t.Errorf("the oldest value was not removed: %v values", h.total)
+	}
+	h.forget("default/a")
+	if _, ok := h.rings["default/a/temperature"]; ok || h.total != 2 {
+		t.Errorf("the history of a deleted device was kept")
+	}
+}
+
+func TestHistoryEviction(t *testing.T) {
+	now := time.Unix(100, 0)
+	h := newHistory(config.History{MaxBytes: 10 * pointSize, MaxAge: metav1.Duration{Duration: time.Minute}})
+	// the points of the devices interleave, so the oldest point moves between the rings
+	for i := 0; i < 40; i++ {
+		h.record(fmt.Sprint("default/", i%4), historyDev(fmt.Sprint(i), fmt.Sprint(50000+i*1000)), now)
+	}
+	if h.total != 10 || len(h.oldest) != len(h.rings) {
+		t.Fatalf("got %v points in %v rings and %v heap entries", h.total, len(h.rings), len(h.oldest))
+	}
+	for key, r := range h.rings {
+		if r.at(0).T < 80000 {
+			t.Errorf("%s keeps the point at %v although newer points were removed", key, r.at(0).T)
+		}
+	}
+
+	// recording only expires the written twin, the others expire with prune
+	now = time.Unix(200, 0)
+	h.record("default/0", historyDev("100", "190000"), now)
+	if _, ok := h.rings["default/1/temperature"]; !ok || h.total != 9 {
+		t.Errorf("got %v points, want the new point and the 8 points of the other twins", h.total)
+	}
+	h.prune(now)
+	if h.total != 1 || len(h.oldest) != 1 {
+		t.Errorf("got %v points and %v heap entries after prune, want 1", h.total, len(h.oldest))
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	h := newHistory(config.History{})
+	now := time.Unix(100, 0)
+	for i, v := range []string{"1", "3", "2", "8"} {
+		h.record("default/a", historyDev(v, fmt.Sprint((i+1)*10000)), now)
+	}
+	for _, tc := range []struct {
+		agg  string
+		want []point
+	}{
+		{"avg", []point{{T: 0, Actual: 1, Expected: 20}, {T: 20000, Actual: 2.5, Expected: 20}, {T: 40000, Actual: 8, Expected: 20}}},
+		{"max", []point{{T: 0, Actual: 1, Expected: 20}, {T: 20000, Actual: 3, Expected: 20}, {T: 40000, Actual: 8, Expected: 20}}},
+		{"last", []point{{T: 0, Actual: 1, Expected: 20}, {T: 20000, Actual: 2, Expected: 20}, {T: 40000, Actual: 8, Expected: 20}}},
+	} {
+		points, _ := h.query("default/a/temperature", time.Unix(0, 0), now, 20*time.Second, tc.agg)
+		if len(points) != len(tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.agg, points, tc.want)
+			continue
+		}
+		for i := range points {
+			if points[i] != tc.want[i] {
+				t.Errorf("%s: got %+v, want %+v", tc.agg, points, tc.want)
+				break
+			}
+		}
+	}
+	points, _ := h.query("default/a/temperature", time.Unix(20, 0), time.Unix(30, 0), 0, "")
+	if len(points) != 2 || points[0].Actual != 3 || points[1].Actual != 2 {
+		t.Errorf("the time range was not applied: %+v", points)
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	e := NewExporter()
+	e.now = func() time.Time { return time.Unix(100, 0) }
+	e.history.record("plant-a/sensor", historyDev("21", "10000"), e.now())
+	e.history.record("plant-a/sensor", historyDev("22", "20000"), e.now())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/devices/sensor/twins/temperature/history?namespace=plant-a&start=15", nil))
+	var resp struct {
+		Device string
+		Points []struct {
+			Time     time.Time
+			Actual   *float64
+			Expected *float64
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("can not decode %s: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusOK || resp.Device != "sensor" || len(resp.Points) != 1 || *resp.Points[0].Actual != 22 || !resp.Points[0].Time.Equal(time.Unix(20, 0)) {
+		t.Errorf("unexpected response %v %s", rec.Code, rec.Body)
+	}
+
+	for url, code := range map[string]int{
+		"/api/v1/devices/sensor/twins/temperature/history":                                   http.StatusNotFound,
+		"/api/v1/devices/sensor/twins/temperature":                                           http.StatusNotFound,
+		"/api/v1/devices/sensor/twins/temperature/history?namespace=plant-a&step=abc":        http.StatusBadRequest,
+		"/api/v1/devices/sensor/twins/temperature/history?namespace=plant-a&end=x":           http.StatusBadRequest,
+		"/api/v1/devices/sensor/twins/temperature/history?namespace=plant-a&aggregation=sum": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != code {
+			t.Errorf("%s: got %v, want %v", url, rec.Code, code)
+		}
+	}
+}
+
+func TestSaveAndLoadHistory(t *testing.T) {
+	e := NewExporter()
+	e.now = func() time.Time { return time.Unix(100, 0) }
+	e.history.record("default/a", []Dev{{Name: "temperature", ValueTyp: "int", Actual: withTimestamp("21", "10000")}}, e.now())
+	e.history.record("default/a", historyDev("22", "20000"), e.now())
+
+	var buf bytes.Buffer
+	if err := e.SaveHistory(&buf); err != nil {
+		t.Fatalf("can not save the history: %v", err)
+	}
+	loaded := NewExporter(WithHistory(config.History{MaxSamples: 1}))
+	loaded.now = e.now
+	if err := loaded.LoadHistory(&buf); err != nil {
+		t.Fatalf("can not load the history: %v", err)
+	}
+	points, _ := loaded.history.query("default/a/temperature", time.Unix(0, 0), e.now(), 0, "")
+	if len(points) != 1 || points[0].Actual != 22 || points[0].Expected != 20 || loaded.history.total != 1 {
+		t.Errorf("unexpected points %+v", points)
+	}
+}
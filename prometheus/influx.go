@@ -0,0 +1,150 @@
+package prometheus
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// influxMeasurement escapes measurements
+	influxMeasurement = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	// influxKey escapes tag keys and tag values
+	influxKey = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	// influxString escapes string field values
+	influxString = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`)
+)
+
+func (e *Exporter) handleInflux(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := w.Write([]byte(e.LineProtocol())); err != nil {
+		log.Printf("could not write message; error is: %v", err)
+	}
+}
+
+// LineProtocol renders the twins in the InfluxDB line protocol, as served on /influx. Every twin is one point with
+// the fields reported and desired, named by influxField; its timestamp is taken from the timestamp in milliseconds of
+// the reported metadata, points without timestamp get the time of the write from InfluxDB.
+func (e *Exporter) LineProtocol() string {
+	r := e.loadRender()
+	conf, rules := r.conf, r.rules
+	snap := e.store.load()
+	var b strings.Builder
+	for _, key := range snap.deviceKeys() {
+		for _, v := range snap.devices[key] {
+			if !rules.exports(v) {
+				continue
+			}
+			var fields []string
+			for _, f := range []struct{ name, value string }{{"reported", v.Actual.Value}, {"desired", v.Expected.Value}} {
+				value, ok := influxValue(v.ValueTyp, f.value)
+				if !ok {
+					continue
+				}
+				fields = append(fields, influxField(f.name, v.ValueTyp)+"="+value)
+			}
+
+			tags := map[string]string{
+				"namespace": v.Namespace,
+				"device":    deviceName(key),
+				"node":      strings.Join(nodeValues(v), ","),
+			}
+			measurement := v.Name
+			if conf.InfluxMeasurement == "model" {
+				measurement = v.Model
+				tags["property"] = v.Name
+			} else {
+				tags["model"] = v.Model
+			}
+			for _, label := range rules.labels(v.Namespace) {
+				if value, ok := v.Labels[label]; ok {
+					tags["label_"+sanitizeLabel(label)] = value
+				}
+			}
+			if measurement == "" || len(fields) == 0 {
+				continue
+			}
+
+			b.WriteString(influxMeasurement.Replace(measurement))
+			writeTags(&b, tags)
+			b.WriteByte(' ')
+			b.WriteString(strings.Join(fields, ","))
+			if ts, err := strconv.ParseInt(v.Actual.Metadata["timestamp"], 10, 64); err == nil {
+				b.WriteByte(' ')
+				b.WriteString(strconv.FormatInt(ts*1000000, 10))
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// writeTags writes the tags sorted by key as recommended by InfluxDB; empty values are not allowed and skipped
+func writeTags(b *strings.Builder, tags map[string]string) {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(influxKey.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(influxKey.Replace(tags[k]))
+	}
+}
+
+// nodeValues returns the node names of the node selector of v
+func nodeValues(v Dev) []string {
+	var ret []string
+	for _, values := range v.Node {
+		ret = append(ret, values...)
+	}
+	return ret
+}
+
+// influxField returns the field of the value name, reported or desired, of a twin with the type valueTyp. The twins
+// of a measurement, like the twins of a property on different devices, share the fields, whose type must not change:
+// numbers are written as floats, booleans and strings to fields with the suffix _bool and _str.
+func influxField(name string, valueTyp string) string {
+	switch valueTyp {
+	case "string":
+		return name + "_str"
+	case "boolean", "bool":
+		return name + "_bool"
+	}
+	return name
+}
+
+// influxValue converts value of a twin with the type valueTyp into a field value; integers are written as floats,
+// so a field does not change its type if twins of other devices report decimals
+func influxValue(valueTyp string, value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	switch valueTyp {
+	case "string":
+		return `"` + influxString.Replace(value) + `"`, true
+	case "boolean", "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatBool(b), true
+	case "int", "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return value, true
+		}
+		return "", false
+	}
+	// InfluxDB does not accept NaN and infinite values
+	if f, err := strconv.ParseFloat(value, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", false
+	}
+	return value, true
+}
@@ -0,0 +1,31 @@
+package prometheus
+
+import "sync"
+
+// subscribers are notified when the devices or nodes of an Exporter change
+type subscribers struct {
+	mutex    sync.Mutex
+	channels []chan struct{}
+}
+
+// Subscribe returns a channel which receives a value after the devices or nodes have changed;
+// changes which happen while a notification is pending are merged into it
+func (e *Exporter) Subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	e.subscribers.mutex.Lock()
+	e.subscribers.channels = append(e.subscribers.channels, ch)
+	e.subscribers.mutex.Unlock()
+	return ch
+}
+
+// notify informs all subscribers about a change without blocking
+func (s *subscribers) notify() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, ch := range s.channels {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The types below are the subset of the OTLP metrics data model in its JSON encoding which is needed for gauges
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+	// TimeUnixNano is a string because the JSON encoding of OTLP encodes 64 bit integers as strings
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+}
+
+// OTLP renders the twins as OTLP/HTTP JSON request. Every device is a resource with the attributes cluster,
+// namespace, node and device, every twin a gauge named after its property with the data points reported and desired.
+// The time of a data point is taken from the timestamp in milliseconds of the reported metadata, or the current time.
+func (e *Exporter) OTLP(cluster string) []byte {
+	rules := e.renderRules()
+	snap := e.store.load()
+	now := strconv.FormatInt(e.now().UnixNano(), 10)
+
+	req := otlpRequest{ResourceMetrics: []otlpResourceMetrics{}}
+	for _, key := range snap.deviceKeys() {
+		var metrics []otlpMetric
+		var resource otlpResource
+		for _, v := range snap.devices[key] {
+			if !rules.exports(v) {
+				continue
+			}
+			if resource.Attributes == nil {
+				resource.Attributes = otlpAttributes(map[string]string{
+					"service.name":          "cpu-kubeedge-exporter",
+					"k8s.cluster.name":      cluster,
+					"k8s.namespace.name":    v.Namespace,
+					"k8s.node.name":         strings.Join(nodeValues(v), ","),
+					"kubeedge.device.name":  deviceName(key),
+					"kubeedge.device.model": v.Model,
+				})
+			}
+
+			timestamp := now
+			if ts, err := strconv.ParseInt(v.Actual.Metadata["timestamp"], 10, 64); err == nil {
+				timestamp = strconv.FormatInt(ts*1000000, 10)
+			}
+			var points []otlpDataPoint
+			for _, value := range []struct{ typ, value string }{{"reported", v.Actual.Value}, {"desired", v.Expected.Value}} {
+				sample, ok := sampleValue(v.ValueTyp, value.value)
+				if !ok {
+					continue
+				}
+				f, err := strconv.ParseFloat(sample, 64)
+				if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+					// JSON can not encode NaN and infinite values
+					continue
+				}
+				points = append(points, otlpDataPoint{
+					Attributes:   otlpAttributes(map[string]string{"type": value.typ}),
+					TimeUnixNano: timestamp,
+					AsDouble:     f,
+				})
+			}
+			if len(points) > 0 && v.Name != "" {
+				metrics = append(metrics, otlpMetric{Name: v.Name, Gauge: otlpGauge{DataPoints: points}})
+			}
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, otlpResourceMetrics{
+			Resource: resource,
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "github.com/subpathdev/cpu-kubeedge-exporter"},
+				Metrics: metrics,
+			}},
+		})
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("can not encode the otlp request; err is: %v", err)
+	}
+	return data
+}
+
+// otlpAttributes converts attrs into attributes sorted by key; empty values are skipped
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	ret := []otlpKeyValue{}
+	for k, v := range attrs {
+		if v != "" {
+			ret = append(ret, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: v}})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
@@ -0,0 +1,234 @@
+package prometheus
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+const (
+	// orphanRetention is the time the twins of a device without Device object are kept after their last update
+	orphanRetention = 10 * time.Minute
+	// maxOrphans limits the number of devices without Device object whose twins are kept
+	maxOrphans = 1000
+)
+
+// UpdateTwins merges twins received directly from the EventBus of an edge node into the device and removes the
+// deleted twins; values which are missing in twins keep their current value. The merged values are kept until the
+// Device object reports the same or a newer value, so they are not reverted by an outdated Device object. Twins of
+// devices without a Device object are kept until it arrives, for at most orphanRetention.
+func (e *Exporter) UpdateTwins(namespace string, device string, twins []typ.Twin, deleted []string) {
+	e.stats.countEvent("eventbus", watch.Modified)
+	key := namespace + "/" + device
+	now := e.now()
+	var applied []Dev
+	e.store.update(func(next *snapshot) {
+		overlay := make(map[string]typ.Twin, len(next.overlay[key])+len(twins))
+		for name, twin := range next.overlay[key] {
+			overlay[name] = twin
+		}
+		for _, twin := range twins {
+			current := overlay[twin.Name]
+			current.Name = twin.Name
+			if present(twin.Actual) {
+				current.Actual = twin.Actual
+			}
+			if present(twin.Desired) {
+				current.Desired = twin.Desired
+			}
+			overlay[twin.Name] = current
+		}
+		for _, name := range deleted {
+			delete(overlay, name)
+		}
+		if len(overlay) > 0 {
+			next.overlay[key] = overlay
+		} else {
+			delete(next.overlay, key)
+		}
+
+		devs, ok := next.devices[key]
+		if !ok {
+			if len(overlay) > 0 {
+				next.orphans[key] = now
+				limitOrphans(next)
+			} else {
+				delete(next.orphans, key)
+			}
+			return
+		}
+		applied = applyOverlay(withoutTwins(devs, deleted), overlay)
+		next.devices[key] = applied
+	})
+	e.history.record(key, applied, now)
+	e.convergence.update(key, applied, now)
+	e.statistics.observe(key, e.changes.record(key, applied), now)
+	e.evaluateThresholds([]string{key}, now)
+	e.subscribers.notify()
+}
+
+// withoutTwins returns devs without the twins with the names deleted
+func withoutTwins(devs []Dev, deleted []string) []Dev {
+	if len(deleted) == 0 {
+		return devs
+	}
+	ret := make([]Dev, 0, len(devs))
+devs:
+	for _, dev := range devs {
+		for _, name := range deleted {
+			if dev.Name == name {
+				continue devs
+			}
+		}
+		ret = append(ret, dev)
+	}
+	return ret
+}
+
+// limitOrphans removes the oldest overlays of devices without Device object from next until at most maxOrphans are
+// left
+func limitOrphans(next *snapshot) {
+	for len(next.orphans) > maxOrphans {
+		var oldest string
+		for key, updated := range next.orphans {
+			if oldest == "" || updated.Before(next.orphans[oldest]) {
+				oldest = key
+			}
+		}
+		delete(next.orphans, oldest)
+		delete(next.overlay, oldest)
+	}
+}
+
+// pruneOrphans removes the overlays of devices without Device object which have not been updated for
+// orphanRetention
+func (e *Exporter) pruneOrphans(now time.Time) {
+	expired := func(snap *snapshot) bool {
+		for _, updated := range snap.orphans {
+			if now.Sub(updated) > orphanRetention {
+				return true
+			}
+		}
+		return false
+	}
+	if !expired(e.store.load()) {
+		return
+	}
+	e.store.update(func(next *snapshot) {
+		for key, updated := range next.orphans {
+			if now.Sub(updated) > orphanRetention {
+				delete(next.orphans, key)
+				delete(next.overlay, key)
+			}
+		}
+	})
+}
+
+// present reports whether v is part of a twin message
+func present(v typ.TwinValue) bool {
+	return v.Value != "" || v.Metadata != nil
+}
+
+// pruneOverlay returns the twins of overlay which are newer than the twins of the Device object in devs
+func pruneOverlay(overlay map[string]typ.Twin, devs []Dev) map[string]typ.Twin {
+	if len(overlay) == 0 {
+		return nil
+	}
+	ret := make(map[string]typ.Twin, len(overlay))
+	for name, twin := range overlay {
+		for _, dev := range devs {
+			if dev.Name != name {
+				continue
+			}
+			if !newer(twin.Actual, dev.Actual) {
+				twin.Actual = typ.TwinValue{}
+			}
+			if !newer(twin.Desired, dev.Expected) {
+				twin.Desired = typ.TwinValue{}
+			}
+		}
+		if present(twin.Actual) || present(twin.Desired) {
+			ret[name] = twin
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// newer reports whether the value v of the EventBus is newer than the value of the Device object;
+// without timestamps it is newer unless both values are equal
+func newer(v typ.TwinValue, object typ.TwinValue) bool {
+	if !present(v) {
+		return false
+	}
+	ts, err := strconv.ParseInt(v.Metadata["timestamp"], 10, 64)
+	objectTs, objectErr := strconv.ParseInt(object.Metadata["timestamp"], 10, 64)
+	if err == nil && objectErr == nil {
+		return ts > objectTs
+	}
+	return v.Value != object.Value
+}
+
+// applyOverlay returns a copy of devs with the twins of overlay; twins which are missing in devs are appended
+func applyOverlay(devs []Dev, overlay map[string]typ.Twin) []Dev {
+	if len(overlay) == 0 || len(devs) == 0 {
+		return devs
+	}
+	ret := make([]Dev, len(devs), len(devs)+len(overlay))
+	copy(ret, devs)
+	applied := make(map[string]bool, len(overlay))
+	for i := range ret {
+		twin, ok := overlay[ret[i].Name]
+		if !ok {
+			continue
+		}
+		applied[twin.Name] = true
+		overlayDev(&ret[i], twin)
+	}
+	var missing []string
+	for name := range overlay {
+		if !applied[name] && (devs[0].export == nil || devs[0].export.Exports(name)) {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		// the other fields are the same for all twins of a device
+		dev := devs[0]
+		dev.Name = name
+		dev.Actual, dev.Expected, dev.ValueTyp = typ.TwinValue{}, typ.TwinValue{}, ""
+		if dev.typeAnnotated() {
+			dev.ValueTyp = dev.export.Types[name]
+		}
+		overlayDev(&dev, overlay[name])
+		ret = append(ret, dev)
+	}
+	return ret
+}
+
+// typeAnnotated reports whether the type of the twin is set by an annotation of the device
+func (v Dev) typeAnnotated() bool {
+	if v.export == nil {
+		return false
+	}
+	_, ok := v.export.Types[v.Name]
+	return ok
+}
+
+func overlayDev(dev *Dev, twin typ.Twin) {
+	if present(twin.Actual) {
+		dev.Actual = twin.Actual
+		if t := twin.Actual.Metadata["type"]; t != "" && !dev.typeAnnotated() {
+			dev.ValueTyp = t
+		}
+	}
+	if present(twin.Desired) {
+		dev.Expected = twin.Desired
+	}
+}
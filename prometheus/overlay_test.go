@@ -0,0 +1,102 @@
+package prometheus
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func withTimestamp(value string, ts string) typ.TwinValue {
+	return typ.TwinValue{Value: value, Metadata: map[string]string{"type": "int", "timestamp": ts}}
+}
+
+func TestUpdateTwinsOverlaysDeviceObject(t *testing.T) {
+	e := NewExporter()
+	e.UpdateTwins("default", "a", []typ.Twin{{Name: "temperature", Actual: withTimestamp("30", "2000")}}, nil)
+	if len(e.store.load().devices) != 0 {
+		t.Fatalf("a device without Device object was created")
+	}
+
+	events := make(chan watch.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.Run(events, make(chan watch.Event), stop)
+
+	device := testDevice("a", 25)
+	device.Status.Twins[0].Actual = withTimestamp("25", "1000")
+	events <- watch.Event{Type: watch.Added, Object: device.DeepCopy()}
+	// the second event ensures that the first one has been processed
+	events <- watch.Event{Type: watch.Error, Object: device}
+	devs := e.store.load().devices["default/a"]
+	if len(devs) != 1 || devs[0].Actual.Value != "30" || devs[0].Expected.Value != "20" {
+		t.Fatalf("the newer value of the eventbus was not applied: %+v", devs)
+	}
+
+	e.UpdateTwins("default", "a", []typ.Twin{
+		{Name: "temperature", Desired: withTimestamp("22", "2100")},
+		{Name: "humidity", Actual: typ.TwinValue{Value: "40"}},
+	}, nil)
+	devs = e.store.load().devices["default/a"]
+	if len(devs) != 2 || devs[0].Actual.Value != "30" || devs[0].Expected.Value != "22" || devs[1].Name != "humidity" || devs[1].Actual.Value != "40" {
+		t.Fatalf("the partial update was not merged: %+v", devs)
+	}
+
+	// the Device object catches up with the eventbus
+	device.Status.Twins[0].Actual = withTimestamp("31", "3000")
+	device.Status.Twins[0].Desired = withTimestamp("22", "2100")
+	device.Status.Twins = append(device.Status.Twins, typ.Twin{Name: "humidity", Actual: typ.TwinValue{Value: "40"}})
+	events <- watch.Event{Type: watch.Modified, Object: device}
+	events <- watch.Event{Type: watch.Error, Object: device}
+	if overlay, ok := e.store.load().overlay["default/a"]; ok {
+		t.Errorf("outdated eventbus values were kept: %+v", overlay)
+	}
+	if devs = e.store.load().devices["default/a"]; devs[0].Actual.Value != "31" {
+		t.Errorf("the newer value of the Device object was not applied: %+v", devs)
+	}
+}
+
+func TestUpdateTwinsDeletesTwins(t *testing.T) {
+	e := NewExporter()
+	e.store.update(func(next *snapshot) {
+		next.devices["default/a"] = buildDevs(testDevice("a", 25))
+	})
+	e.UpdateTwins("default", "a", []typ.Twin{{Name: "humidity", Actual: typ.TwinValue{Value: "40"}}}, nil)
+	e.UpdateTwins("default", "a", nil, []string{"humidity", "temperature"})
+	snap := e.store.load()
+	if devs := snap.devices["default/a"]; len(devs) != 0 {
+		t.Errorf("the deleted twins are still exported: %+v", devs)
+	}
+	if overlay, ok := snap.overlay["default/a"]; ok {
+		t.Errorf("the deleted twins are kept in the overlay: %+v", overlay)
+	}
+}
+
+func TestUpdateTwinsLimitsOrphans(t *testing.T) {
+	e := NewExporter()
+	now := time.Unix(1500000000, 0)
+	e.now = func() time.Time { return now }
+	for i := 0; i <= maxOrphans; i++ {
+		now = now.Add(time.Second)
+		e.UpdateTwins("default", fmt.Sprint("unknown-", i), []typ.Twin{{Name: "temperature", Actual: typ.TwinValue{Value: "1"}}}, nil)
+	}
+	snap := e.store.load()
+	if len(snap.overlay) != maxOrphans || len(snap.orphans) != maxOrphans {
+		t.Fatalf("got %v overlays and %v orphans, want %v", len(snap.overlay), len(snap.orphans), maxOrphans)
+	}
+	if _, ok := snap.overlay["default/unknown-0"]; ok {
+		t.Errorf("the oldest overlay was kept")
+	}
+
+	e.pruneOrphans(now.Add(orphanRetention))
+	if snap = e.store.load(); len(snap.overlay) != 1 || len(snap.orphans) != 1 {
+		t.Errorf("got %v overlays and %v orphans after the retention, want the newest one", len(snap.overlay), len(snap.orphans))
+	}
+	e.pruneOrphans(now.Add(orphanRetention + time.Second))
+	if snap = e.store.load(); len(snap.overlay) != 0 || len(snap.orphans) != 0 {
+		t.Errorf("got %v overlays and %v orphans, want none", len(snap.overlay), len(snap.orphans))
+	}
+}
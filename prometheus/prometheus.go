@@ -5,146 +5,270 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
 	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
 )
 
+// TwinMetric is the name of the gauge of the twin values on /metrics; its labels are sensorGroup (the device), node,
+// sensor (the property), type (actual or expected) and namespace
+const TwinMetric = "cpu_kubeedge_exporter"
+
 type Dev struct {
-	Name     string
-	Actual   typ.TwinValue
-	Expected typ.TwinValue
-	ValueTyp string
-	Node     [][]string
-	Operator []string
+	Name      string
+	Namespace string
+	Model     string
+	Labels    map[string]string
+	Actual    typ.TwinValue
+	Expected  typ.TwinValue
+	ValueTyp  string
+	Node      [][]string
+	Operator  []string
+
+	// export is the export control given by the annotations of the device; it is shared by all twins of a device
+	export *typ.Export
+}
+
+// Exporter keeps the latest state of the devices and nodes and serves it over http
+type Exporter struct {
+	store *store
+
+	render      atomic.Value
+	status      SyncStatus
+	recorder    Recorder
+	stats       stats
+	subscribers subscribers
+	history     *history
+	convergence *convergence
+	changes     changes
+	statistics  *statistics
+	thresholds  evaluator
+	notifier    Notifier
+	writer      DesiredWriter
+	writeAPI    config.WriteAPI
+	mux         *http.ServeMux
+
+	// reported is the last validation result per device; it is only used by Run
+	reported map[string]string
+	now      func() time.Time
+	// clock is the time source set by WithClock; the wall clock is used if it is nil
+	clock Clock
+}
+
+// Option configures an Exporter
+type Option func(*Exporter)
+
+// WithStatus sets the SyncStatus which is used to answer the health and readiness probes
+func WithStatus(status SyncStatus) Option {
+	return func(e *Exporter) {
+		e.status = status
+	}
+}
+
+// WithRender sets the initial rendering options
+func WithRender(r config.Render) Option {
+	return func(e *Exporter) {
+		e.setRender(r)
+	}
+}
+
+// Clock is the time source of an Exporter, e.g. the time of a replay
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// EventTime returns the time of the event of obj
+	EventTime(obj runtime.Object) time.Time
+}
+
+// WithClock sets the time source of the history, the thresholds, the convergence and the statistics; the default is
+// the wall clock
+func WithClock(clock Clock) Option {
+	return func(e *Exporter) {
+		e.clock = clock
+		e.now = clock.Now
+	}
 }
 
-var devices map[string][]Dev
-var devMutex, nodeMutex sync.RWMutex
-var nodes map[string]int64
+// eventTime returns the time of the event of obj
+func (e *Exporter) eventTime(obj runtime.Object) time.Time {
+	if e.clock != nil {
+		return e.clock.EventTime(obj)
+	}
+	return e.now()
+}
+
+// NewExporter creates an Exporter; it has to be fed by Run
+func NewExporter(opts ...Option) *Exporter {
+	e := &Exporter{
+		store:       newStore(),
+		mux:         http.NewServeMux(),
+		reported:    make(map[string]string),
+		now:         time.Now,
+		history:     newHistory(config.History{}),
+		convergence: newConvergence(config.Convergence{}),
+		statistics:  newStatistics(config.Statistics{}),
+	}
+	e.stats.eventsProcessed = make(map[string]map[watch.EventType]uint64)
+	e.thresholds.violations = make(map[string]*violation)
+	e.changes.devices = make(map[string]*deviceChanges)
+	e.thresholds.objects = make(map[string]*typ.Device)
+	e.setRender(config.Render{})
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	e.mux.HandleFunc("/", e.handleRequest)
+	e.mux.HandleFunc("/metrics", e.handlePrometheus)
+	e.mux.HandleFunc("/influx", e.handleInflux)
+	e.mux.HandleFunc("/api/v1/devices/", e.handleDevices)
+	e.mux.HandleFunc("/healthz", e.handleHealthz)
+	e.mux.HandleFunc("/readyz", e.handleReadyz)
+	return e
+}
+
+// ServeHTTP serves the overview on /, the metrics on /metrics and /influx, the history and the write api of the
+// twins on /api/v1/devices/ and the probes on /healthz and /readyz
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mux.ServeHTTP(w, r)
+}
 
-func handleChannel(events chan watch.Event, eve chan watch.Event) {
+// Run processes the device events and the node events eve until stop is closed
+func (e *Exporter) Run(events <-chan watch.Event, eve <-chan watch.Event, stop <-chan struct{}) {
+	// values of the history expire even if their twins do not change
+	expire := time.NewTicker(time.Minute)
+	defer expire.Stop()
+	evaluate := time.NewTicker(evaluateInterval)
+	defer evaluate.Stop()
 	for {
 		select {
+		case <-stop:
+			return
+		case <-expire.C:
+			now := e.now()
+			e.history.prune(now)
+			e.changes.prune(now)
+			e.pruneOrphans(now)
+			e.statistics.expire(now)
+		case <-evaluate.C:
+			now := e.now()
+			e.evaluateThresholds(nil, now)
+			e.convergence.expire(now)
 		case ev := <-events:
-			dev, ok := ev.Object.(*typ.Device)
+			device, ok := ev.Object.(*typ.Device)
 			if !ok {
 				log.Printf("in events: can not confort ev.Object to *typ.Device; err:")
 				continue
 			}
+			e.stats.countEvent("device", ev.Type)
+			now := e.eventTime(ev.Object)
 
+			key := deviceKey(device)
 			switch ev.Type {
 			case watch.Deleted:
-				devMutex.Lock()
-				delete(devices, dev.ObjectMeta.Name)
-				devMutex.Unlock()
-			case watch.Added:
-				devMutex.Lock()
-				var nodes [][]string
-				var operator []string
-				for _, terms := range dev.Spec.NodeSelector.NodeSelectorTerms {
-					for _, expression := range terms.MatchExpressions {
-						var node []string
-						node = append(node, expression.Values...)
-						operator = append(operator, string(expression.Operator))
-						nodes = append(nodes, node)
+				e.changes.event(key, device.ResourceVersion, ev.Type, now)
+				e.forgetInvalid(key)
+				e.store.update(func(next *snapshot) {
+					delete(next.devices, key)
+					delete(next.info, key)
+					delete(next.invalid, key)
+					delete(next.overlay, key)
+					delete(next.orphans, key)
+				})
+				e.history.forget(key)
+				e.convergence.forget(key)
+				e.statistics.forget(key)
+				e.thresholds.setObject(key, nil)
+				e.evaluateThresholds([]string{key}, now)
+			case watch.Added, watch.Modified:
+				fresh := e.changes.event(key, device.ResourceVersion, ev.Type, now)
+				devs := buildDevs(device)
+				problems := e.validate(key, device)
+				var applied []Dev
+				e.store.update(func(next *snapshot) {
+					delete(next.orphans, key)
+					next.info[key] = deviceInfo(device)
+					applied = devs
+					if overlay := pruneOverlay(next.overlay[key], devs); overlay != nil {
+						next.overlay[key] = overlay
+						applied = applyOverlay(devs, overlay)
+						next.devices[key] = applied
+					} else {
+						delete(next.overlay, key)
+						next.devices[key] = devs
 					}
-				}
-				var devs []Dev
-				for _, twin := range dev.Status.Twins {
-					var dev Dev
-					var actual, expected typ.TwinValue
-					actual = twin.Actual
-					expected = twin.Desired
-					dev.Actual = actual
-					dev.Expected = expected
-					dev.Name = twin.Name
-					dev.Node = nodes
-					dev.Operator = operator
-					dev.ValueTyp = twin.Actual.Metadata["type"]
-					devs = append(devs, dev)
-				}
-				devices[dev.Name] = devs
-				devMutex.Unlock()
-			case watch.Modified:
-				devMutex.Lock()
-				var devs []Dev
-				var nodes [][]string
-				var operator []string
-				for _, terms := range dev.Spec.NodeSelector.NodeSelectorTerms {
-					for _, expression := range terms.MatchExpressions {
-						var node []string
-						node = append(node, expression.Values...)
-						operator = append(operator, string(expression.Operator))
-						nodes = append(nodes, node)
+					if len(problems) > 0 {
+						next.invalid[key] = problems
+					} else {
+						delete(next.invalid, key)
 					}
+				})
+				e.history.record(key, applied, now)
+				e.convergence.update(key, applied, now)
+				reported := e.changes.record(key, applied)
+				if fresh {
+					e.statistics.observe(key, reported, now)
 				}
-				for _, twin := range dev.Status.Twins {
-					var dev Dev
-					var actual, expected typ.TwinValue
-					actual = twin.Actual
-					expected = twin.Desired
-					dev.Actual = actual
-					dev.Expected = expected
-					dev.Name = twin.Name
-					dev.Node = nodes
-					dev.Operator = operator
-					dev.ValueTyp = twin.Actual.Metadata["type"]
-					devs = append(devs, dev)
-				}
-				devMutex.Unlock()
-				devices[dev.Name] = devs
+				e.thresholds.setObject(key, device)
+				e.evaluateThresholds([]string{key}, now)
 			default:
 				log.Printf("unexpected type")
+				continue
 			}
+			e.subscribers.notify()
 		case ev := <-eve:
 			dev, ok := ev.Object.(*v1.Node)
 			if !ok {
 				log.Printf("in eve: can not convert ev.Object to *typ.Device; err:")
 				continue
 			}
+			e.stats.countEvent("node", ev.Type)
 
 			switch ev.Type {
-			case watch.Added:
-				nodeMutex.Lock()
-				nodes[dev.Name] = time.Now().Unix()
-				nodeMutex.Unlock()
-			case watch.Modified:
-				nodeMutex.Lock()
-				nodes[dev.Name] = time.Now().Unix()
-				nodeMutex.Unlock()
+			case watch.Added, watch.Modified:
+				now := e.eventTime(ev.Object).Unix()
+				e.store.update(func(next *snapshot) {
+					next.nodes[dev.Name] = now
+				})
 			case watch.Deleted:
-				nodeMutex.Lock()
-				delete(nodes, dev.Name)
-				nodeMutex.Unlock()
+				e.store.update(func(next *snapshot) {
+					delete(next.nodes, dev.Name)
+				})
 			default:
 				log.Printf("unexpected type")
+				continue
 			}
+			e.subscribers.notify()
 		}
 	}
 }
 
-func handleRequest(w http.ResponseWriter, r *http.Request) {
+func (e *Exporter) handleRequest(w http.ResponseWriter, r *http.Request) {
 	message := "Displays the matched nodes, the device, the sensor name and the value:\n"
-	devMutex.RLock()
-	log.Printf("request over %v devices", len(devices))
-	for key, value := range devices {
-		for _, v := range value {
+	rules := e.renderRules()
+	snap := e.store.load()
+	log.Printf("request over %v devices", len(snap.devices))
+	for _, key := range snap.deviceKeys() {
+		for _, v := range snap.devices[key] {
+			if !rules.exports(v) {
+				continue
+			}
 			var node string
 			for _, op := range v.Operator {
 				for _, name := range v.Node {
 					for _, noName := range name {
-						for no := range nodes {
+						for _, no := range snap.nodeNames() {
 							switch op {
 							case "In":
 								if strings.Contains(no, noName) {
 									node += fmt.Sprintf("%s, ", no)
 								}
-							case "notIn":
+							case "NotIn":
 								if !strings.Contains(no, noName) {
 									node += fmt.Sprintf("%s, ", no)
 								}
@@ -170,43 +294,194 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 			message += fmt.Sprintf("Node: %v -> %v::%v: value type is: %v\t actual value: %v\t expected value:%v\n", node, key, v.Name, v.ValueTyp, v.Actual.Value, v.Expected.Value)
 		}
 	}
-	devMutex.RUnlock()
-	message += fmt.Sprintf("\n\n\n\n%v", nodes)
+	message += fmt.Sprintf("\n\n\n\n%v", snap.nodes)
 	if _, err := w.Write([]byte(message)); err != nil {
 		log.Printf("could not write message; error is: %v", err)
 	}
 }
 
-func handlePrometheus(w http.ResponseWriter, r *http.Request) {
-	message := "# TYPE cpu_kubeedge_exporter gauge\n"
-	devMutex.RLock()
-	log.Printf("request over %v devices", len(devices))
-	for sensor, dev := range devices {
-		for _, v := range dev {
-			if strings.Compare(v.ValueTyp, "string") != 0 {
-				if v.Actual.Value != "" {
-					message += fmt.Sprintf("cpu_kubeedge_exporter{sensorGroup=\"%v\",node=\"%v\",sensor=\"%v\",type=\"actual\"} %v\n", sensor, v.Node, v.Name, v.Actual.Value)
+func (e *Exporter) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	if _, err := w.Write([]byte(e.Metrics())); err != nil {
+		log.Printf("could not write message; error is: %v", err)
+	}
+}
+
+// Metrics renders the twins and the metrics about the exporter in the prometheus text format, as served on /metrics
+func (e *Exporter) Metrics() string {
+	start := time.Now()
+	message := e.metrics(true)
+	e.stats.setScrapeDuration(time.Since(start))
+	return message
+}
+
+// DeviceMetrics renders the metrics of Metrics which are derived from the devices; the metrics about the exporter
+// process, like the go runtime, the informers and the scrape duration, are left out
+func (e *Exporter) DeviceMetrics() string {
+	return e.metrics(false)
+}
+
+// RemoteWriteMetrics renders the series which are pushed to remote-write endpoints: the twin series of Metrics, the
+// last update of every node and the drift of every twin
+func (e *Exporter) RemoteWriteMetrics() string {
+	r := e.loadRender()
+	conf, rules := r.conf, r.rules
+	snap := e.store.load()
+	var message strings.Builder
+	series, exported := twinSeries(conf, rules, snap)
+	message.WriteString(series)
+	writeNodes(&message, snap)
+	writeDrift(&message, rules, snap, exported)
+	return message.String()
+}
+
+// metrics renders the twins and the metrics derived from the devices, and the metrics about the exporter if self is set
+func (e *Exporter) metrics(self bool) string {
+	r := e.loadRender()
+	conf, rules := r.conf, r.rules
+	snap := e.store.load()
+	log.Printf("request over %v devices", len(snap.devices))
+	message, _ := twinSeries(conf, rules, snap)
+
+	var derived strings.Builder
+	writeInvalid(&derived, snap)
+	writeFleet(&derived, conf, rules, snap)
+	e.writeThresholds(&derived)
+	e.convergence.write(&derived)
+	e.changes.write(&derived)
+	e.statistics.write(&derived, e.now())
+	if self {
+		e.writeSelfMetrics(&derived, snap)
+	}
+	return message + derived.String()
+}
+
+// twinSeries renders the series of the actual and expected values of the twins of snap; exported has the names of
+// the twins per device key with at least one series which is neither dropped by the rules nor above a limit
+func twinSeries(conf config.Render, rules *renderRules, snap *snapshot) (message string, exported map[string]map[string]bool) {
+	// the series are grouped by their metric name, which may be changed by the rules
+	families := map[string]*strings.Builder{TwinMetric: {}}
+	names := []string{TwinMetric}
+	series, invalid := 0, 0
+	// scoped counts the series of the scopes with a limit; limited are the scopes which reached it
+	scoped, limited := make(map[*scope]int), make(map[*scope]bool)
+	exported = make(map[string]map[string]bool)
+devs:
+	for _, key := range snap.deviceKeys() {
+		sensor := deviceName(key)
+		for _, v := range snap.devices[key] {
+			if !rules.exports(v) {
+				continue
+			}
+			if conf.MaxSeries > 0 && series >= conf.MaxSeries {
+				log.Printf("reached the limit of %v series; skip the remaining twins", conf.MaxSeries)
+				break devs
+			}
+			sc := rules.scope(v)
+			if sc != nil && sc.maxSeries > 0 && scoped[sc] >= sc.maxSeries {
+				if !limited[sc] {
+					log.Printf("reached the limit of %v series of an ExporterConfig in namespace %v; skip its remaining twins", sc.maxSeries, v.Namespace)
+					limited[sc] = true
+				}
+				continue
+			}
+			for _, s := range []struct {
+				kind  string
+				value typ.TwinValue
+			}{{"actual", v.Actual}, {"expected", v.Expected}} {
+				value, ok := sampleValue(v.ValueTyp, s.value.Value)
+				if !ok {
+					continue
 				}
-				if v.Expected.Value != "" {
-					message += fmt.Sprintf("cpu_kubeedge_exporter{sensorGroup=\"%v\",node=\"%v\",sensor=\"%v\",type=\"expected\"} %v\n", sensor, v.Node, v.Name, v.Expected.Value)
+				name, labels, value, ok := rules.apply(v, TwinMetric, twinLabels(rules.labels(v.Namespace), v, sensor, s.kind), value)
+				if !ok {
+					continue
+				}
+				if !validSeries(name, labels) {
+					invalid++
+					continue
+				}
+				family, ok := families[name]
+				if !ok {
+					family = &strings.Builder{}
+					families[name] = family
+					names = append(names, name)
+				}
+				writeSeries(family, name, labels, value)
+				if exported[key] == nil {
+					exported[key] = make(map[string]bool)
+				}
+				exported[key][v.Name] = true
+				series++
+				if sc != nil && sc.maxSeries > 0 {
+					scoped[sc]++
 				}
 			}
 		}
 	}
-	devMutex.RUnlock()
-	if _, err := w.Write([]byte(message)); err != nil {
-		log.Printf("could not write message; error is: %v", err)
+	if invalid > 0 {
+		log.Printf("skip %v series with an invalid or reserved metric name or an invalid label name after relabeling", invalid)
+	}
+	for _, name := range names {
+		message += "# TYPE " + name + " gauge\n" + families[name].String()
+	}
+	return message, exported
+}
+
+// deviceInfo returns the entry of device without twin, which has the fields shared by all twins of the device
+func deviceInfo(device *typ.Device) Dev {
+	var nodes [][]string
+	var operator []string
+	if device.Spec.NodeSelector != nil {
+		for _, terms := range device.Spec.NodeSelector.NodeSelectorTerms {
+			for _, expression := range terms.MatchExpressions {
+				var node []string
+				node = append(node, expression.Values...)
+				operator = append(operator, string(expression.Operator))
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	var model string
+	if device.Spec.DeviceModelRef != nil {
+		model = device.Spec.DeviceModelRef.Name
+	}
+	// invalid annotations are reported by the validation of the device
+	export, _ := typ.ExportAnnotations(device)
+	return Dev{
+		Namespace: device.Namespace,
+		Model:     model,
+		Labels:    device.Labels,
+		Node:      nodes,
+		Operator:  operator,
+		export:    &export,
 	}
 }
 
-func Init(events chan watch.Event, listen string, eve chan watch.Event) {
-	devices = make(map[string][]Dev)
-	nodes = make(map[string]int64)
-	go handleChannel(events, eve)
+// buildDevs converts the twins of device into the entries kept by the Exporter
+func buildDevs(device *typ.Device) []Dev {
+	info := deviceInfo(device)
+	var devs []Dev
+	for _, twin := range device.Status.Twins {
+		if !info.export.Exports(twin.Name) {
+			continue
+		}
+		dev := info
+		dev.Actual = twin.Actual
+		dev.Expected = twin.Desired
+		dev.Name = twin.Name
+		dev.ValueTyp = twin.Actual.Metadata["type"]
+		if t, ok := info.export.Types[twin.Name]; ok {
+			dev.ValueTyp = t
+		}
+		devs = append(devs, dev)
+	}
+	return devs
+}
 
-	http.HandleFunc("/", handleRequest)
-	http.HandleFunc("/metrics", handlePrometheus)
-	if err := http.ListenAndServe(listen, nil); err != nil {
-		log.Printf("could not run list and serve; error is: %v", err)
+// ListenAndServe serves handler on the listen address of conf, using TLS if it is configured
+func ListenAndServe(conf config.Config, handler http.Handler) error {
+	if conf.TLS.CertFile != "" {
+		return http.ListenAndServeTLS(conf.Listen(), conf.TLS.CertFile, conf.TLS.KeyFile, handler)
 	}
+	return http.ListenAndServe(conf.Listen(), handler)
 }
@@ -0,0 +1,437 @@
+package prometheus
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/units"
+)
+
+var (
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reservedPrefixes are the prefixes of the metrics which are written besides the twin series; relabel configs
+	// must not move a twin series into their families
+	reservedPrefixes = []string{TwinMetric + "_", "kubeedge_", "go_", "process_"}
+)
+
+// label is a label of a series; the order of the labels of a series is kept when it is rendered
+type label struct {
+	name, value string
+}
+
+// transform is a config.Transform with its unit conversion
+type transform struct {
+	config.Transform
+	convert func(float64) float64
+}
+
+// relabel is a config.RelabelConfig with its compiled regex
+type relabel struct {
+	config.RelabelConfig
+	regex       *regexp.Regexp
+	replacement string
+}
+
+// selection is a config.Selection with its parsed label selector
+type selection struct {
+	namespaces map[string]bool
+	selector   labels.Selector
+	properties map[string]bool
+}
+
+// scope is a config.Scope with its compiled rules
+type scope struct {
+	selection  selection
+	labels     []string
+	transforms []transform
+	relabels   []relabel
+	maxSeries  int
+}
+
+// renderRules are the compiled selections, transforms and relabel configs of a config.Render
+type renderRules struct {
+	properties    map[string]bool
+	deviceLabels  []string
+	selections    []selection
+	selectionOnly bool
+	transforms    []transform
+	relabels      []relabel
+	// scopes are stored under their namespace
+	scopes map[string][]*scope
+}
+
+// matches reports whether s selects the twin v
+func (s selection) matches(v Dev) bool {
+	return (s.namespaces == nil || s.namespaces[v.Namespace]) && (s.properties == nil || s.properties[v.Name]) &&
+		s.selector.Matches(labels.Set(v.Labels))
+}
+
+// matchesDevice reports whether s selects the namespace and labels of the device of v
+func (s selection) matchesDevice(v Dev) bool {
+	return (s.namespaces == nil || s.namespaces[v.Namespace]) && s.selector.Matches(labels.Set(v.Labels))
+}
+
+// exports reports whether the twin v is exported
+func (rules *renderRules) exports(v Dev) bool {
+	if rules.properties != nil && !rules.properties[v.Name] {
+		return false
+	}
+	if len(rules.selections) == 0 && len(rules.scopes[v.Namespace]) == 0 && !rules.selectionOnly {
+		return true
+	}
+	for _, s := range rules.selections {
+		if s.matches(v) {
+			return true
+		}
+	}
+	return rules.scope(v) != nil
+}
+
+// exportsDevice reports whether the device of the entry v is exported, that is whether a selection or scope selects
+// its namespace and labels; the properties are ignored so devices without twins are exported as well
+func (rules *renderRules) exportsDevice(v Dev) bool {
+	if v.export != nil && !v.export.Scrape {
+		return false
+	}
+	if len(rules.selections) == 0 && len(rules.scopes[v.Namespace]) == 0 && !rules.selectionOnly {
+		return true
+	}
+	for _, s := range rules.selections {
+		if s.matchesDevice(v) {
+			return true
+		}
+	}
+	for _, s := range rules.scopes[v.Namespace] {
+		if s.selection.matchesDevice(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// scope returns the first scope which selects the twin v, or nil
+func (rules *renderRules) scope(v Dev) *scope {
+	for _, s := range rules.scopes[v.Namespace] {
+		if s.selection.matches(v) {
+			return s
+		}
+	}
+	return nil
+}
+
+// labels returns the device labels which are added to the series of the twins in namespace
+func (rules *renderRules) labels(namespace string) []string {
+	ret := rules.deviceLabels
+	for _, s := range rules.scopes[namespace] {
+		for _, l := range s.labels {
+			found := false
+			for _, existing := range ret {
+				found = found || existing == l
+			}
+			if !found {
+				ret = append(ret[:len(ret):len(ret)], l)
+			}
+		}
+	}
+	return ret
+}
+
+// set returns the values as set, or nil if values is empty
+func set(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	ret := make(map[string]bool, len(values))
+	for _, v := range values {
+		ret[v] = true
+	}
+	return ret
+}
+
+// newRenderRules compiles the rules of r, which has to be validated by config.Config.Validate
+func newRenderRules(r config.Render) *renderRules {
+	rules := &renderRules{
+		properties:    set(r.Properties),
+		deviceLabels:  r.Labels,
+		selectionOnly: r.SelectionOnly,
+		transforms:    compileTransforms(r.Transforms),
+		relabels:      compileRelabels(r.RelabelConfigs),
+		scopes:        make(map[string][]*scope),
+	}
+	for _, s := range r.Selections {
+		selector, _ := labels.Parse(s.LabelSelector)
+		rules.selections = append(rules.selections, selection{namespaces: set(s.Namespaces), selector: selector, properties: set(s.Properties)})
+	}
+	for _, s := range r.Scopes {
+		selector, _ := labels.Parse(s.LabelSelector)
+		rules.scopes[s.Namespace] = append(rules.scopes[s.Namespace], &scope{
+			selection:  selection{namespaces: set([]string{s.Namespace}), selector: selector, properties: set(s.Properties)},
+			labels:     s.Labels,
+			transforms: compileTransforms(s.Transforms),
+			relabels:   compileRelabels(s.RelabelConfigs),
+			maxSeries:  s.MaxSeries,
+		})
+	}
+	return rules
+}
+
+func compileTransforms(transforms []config.Transform) []transform {
+	var ret []transform
+	for _, t := range transforms {
+		tr := transform{Transform: t}
+		if t.FromUnit != "" {
+			tr.convert, _ = units.Converter(t.FromUnit, t.ToUnit)
+		}
+		ret = append(ret, tr)
+	}
+	return ret
+}
+
+func compileRelabels(configs []config.RelabelConfig) []relabel {
+	var ret []relabel
+	for _, c := range configs {
+		rl := relabel{RelabelConfig: c, replacement: "$1"}
+		if rl.Separator == "" {
+			rl.Separator = ";"
+		}
+		if rl.Action == "" {
+			rl.Action = "replace"
+		}
+		regex := c.Regex
+		if regex == "" {
+			regex = "(.*)"
+		}
+		rl.regex = regexp.MustCompile("^(?:" + regex + ")$")
+		if c.Replacement != nil {
+			rl.replacement = *c.Replacement
+		}
+		ret = append(ret, rl)
+	}
+	return ret
+}
+
+// transform returns the first transform which applies to the twin v, or nil; the transforms of the scopes of its
+// namespace follow the ones of the render config
+func (rules *renderRules) transform(v Dev) *transform {
+	transforms := rules.transforms
+	for _, s := range rules.scopes[v.Namespace] {
+		transforms = append(transforms[:len(transforms):len(transforms)], s.transforms...)
+	}
+	for i, t := range transforms {
+		if (t.Model == "" || t.Model == v.Model) && (t.Property == "" || t.Property == v.Name) {
+			return &transforms[i]
+		}
+	}
+	return nil
+}
+
+// relabeled reports whether relabel configs apply to the twins in namespace
+func (rules *renderRules) relabeled(namespace string) bool {
+	if len(rules.relabels) > 0 {
+		return true
+	}
+	for _, s := range rules.scopes[namespace] {
+		if len(s.relabels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// value transforms the value f of the twin v like the samples of its series, so aggregates and thresholds use the
+// exported units
+func (rules *renderRules) value(v Dev, f float64) float64 {
+	t := rules.transform(v)
+	if t == nil || math.IsNaN(f) {
+		return f
+	}
+	if t.Scale != nil {
+		f *= *t.Scale
+	}
+	f += t.Offset
+	if t.convert != nil {
+		f = t.convert(f)
+	}
+	return f
+}
+
+// apply transforms the sample value of the series of the twin v with the metric name and labels; it returns false if
+// the series is dropped
+func (rules *renderRules) apply(v Dev, name string, labels []label, value string) (string, []label, string, bool) {
+	if len(rules.transforms) == 0 && len(rules.relabels) == 0 && len(rules.scopes[v.Namespace]) == 0 {
+		return name, labels, value, true
+	}
+	if t := rules.transform(v); t != nil {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			value = strconv.FormatFloat(rules.value(v, f), 'g', -1, 64)
+			if t.Metric != "" {
+				name = t.Metric
+			}
+		}
+	}
+	if !rules.relabeled(v.Namespace) {
+		return name, labels, value, true
+	}
+
+	labels = append([]label{{"__name__", name}}, labels...)
+	// the relabel configs of the scopes apply first, so they only see the series of their namespace
+	var scoped []relabel
+	for _, s := range rules.scopes[v.Namespace] {
+		scoped = append(scoped, s.relabels...)
+	}
+	if len(scoped) > 0 {
+		for _, r := range scoped {
+			var ok bool
+			if labels, ok = r.apply(labels); !ok {
+				return "", nil, "", false
+			}
+		}
+		// an ExporterConfig can not move its series into another namespace
+		labels = setLabel(labels, "namespace", v.Namespace)
+	}
+	for _, r := range rules.relabels {
+		var ok bool
+		if labels, ok = r.apply(labels); !ok {
+			return "", nil, "", false
+		}
+	}
+	ret := labels[:0]
+	name = ""
+	for _, l := range labels {
+		switch {
+		case l.name == "__name__":
+			name = l.value
+		case strings.HasPrefix(l.name, "__"):
+			// like in prometheus, the labels starting with __ are removed after relabeling
+		default:
+			ret = append(ret, l)
+		}
+	}
+	if name == "" {
+		return "", nil, "", false
+	}
+	return name, ret, value, true
+}
+
+// validSeries reports whether the metric name and the labels of a series are valid after relabeling, so an invalid
+// target label or a __name__ of another family does not break the page
+func validSeries(name string, labels []label) bool {
+	if !metricName.MatchString(name) {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	for _, l := range labels {
+		if !labelName.MatchString(l.name) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply applies r to labels; it returns false if the series is dropped
+func (r relabel) apply(labels []label) ([]label, bool) {
+	switch r.Action {
+	case "keep", "drop", "replace":
+		values := make([]string, len(r.SourceLabels))
+		for i, name := range r.SourceLabels {
+			values[i] = labelValue(labels, name)
+		}
+		joined := strings.Join(values, r.Separator)
+		match := r.regex.FindStringSubmatchIndex(joined)
+		switch r.Action {
+		case "keep":
+			return labels, match != nil
+		case "drop":
+			return labels, match == nil
+		}
+		if match == nil {
+			return labels, true
+		}
+		target := string(r.regex.ExpandString(nil, r.TargetLabel, joined, match))
+		value := string(r.regex.ExpandString(nil, r.replacement, joined, match))
+		return setLabel(labels, target, value), true
+	case "labelmap":
+		ret := labels
+		for _, l := range labels {
+			if r.regex.MatchString(l.name) {
+				ret = setLabel(ret, r.regex.ReplaceAllString(l.name, r.replacement), l.value)
+			}
+		}
+		return ret, true
+	case "labeldrop", "labelkeep":
+		ret := make([]label, 0, len(labels))
+		for _, l := range labels {
+			// __name__ is not a label of the series for labeldrop and labelkeep
+			if l.name == "__name__" || r.regex.MatchString(l.name) == (r.Action == "labelkeep") {
+				ret = append(ret, l)
+			}
+		}
+		return ret, true
+	}
+	return labels, true
+}
+
+func labelValue(labels []label, name string) string {
+	for _, l := range labels {
+		if l.name == name {
+			return l.value
+		}
+	}
+	return ""
+}
+
+// setLabel sets the label name to value; a label with an empty value is removed and a new label is appended
+func setLabel(labels []label, name string, value string) []label {
+	ret := make([]label, 0, len(labels)+1)
+	found := false
+	for _, l := range labels {
+		if l.name != name {
+			ret = append(ret, l)
+			continue
+		}
+		found = true
+		if value != "" {
+			ret = append(ret, label{name, value})
+		}
+	}
+	if !found && value != "" {
+		ret = append(ret, label{name, value})
+	}
+	return ret
+}
+
+// labelEscape escapes label values in the prometheus text format
+var labelEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value
+func escapeLabel(value string) string {
+	return labelEscape.Replace(value)
+}
+
+// writeSeries renders a sample in the prometheus text format
+func writeSeries(message *strings.Builder, name string, labels []label, value string) {
+	message.WriteString(name)
+	message.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			message.WriteByte(',')
+		}
+		message.WriteString(l.name)
+		message.WriteString(`="`)
+		message.WriteString(escapeLabel(l.value))
+		message.WriteByte('"')
+	}
+	message.WriteString("} ")
+	message.WriteString(value)
+	message.WriteByte('\n')
+}
@@ -0,0 +1,179 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func TestRenderRules(t *testing.T) {
+	scale, replacement, empty := 0.1, "site_$1", ""
+	e := NewExporter(WithRender(config.Render{
+		Labels: []string{"site"},
+		Transforms: []config.Transform{
+			{Model: "other", Property: "temperature", Metric: "not_applied"},
+			{Model: "sensor", Property: "temperature", Scale: &scale, FromUnit: "fahrenheit", ToUnit: "celsius", Metric: "room_temperature_celsius"},
+		},
+		RelabelConfigs: []config.RelabelConfig{
+			{SourceLabels: []string{"sensorGroup"}, Regex: "b", Action: "drop"},
+			{SourceLabels: []string{"sensor"}, TargetLabel: "property"},
+			{Regex: "label_(.*)", Replacement: &replacement, Action: "labelmap"},
+			{Regex: "sensor|label_site", Action: "labeldrop"},
+			{SourceLabels: []string{"type"}, Regex: "expected", TargetLabel: "__name__", Replacement: &empty},
+		},
+	}))
+	e.store.update(func(next *snapshot) {
+		for name, value := range map[string]int{"a": 2120, "b": 500} {
+			device := testDevice(name, value)
+			device.Labels = map[string]string{"site": "munich"}
+			device.Spec.DeviceModelRef = &v1.LocalObjectReference{Name: "sensor"}
+			next.devices["default/"+name] = buildDevs(device)
+		}
+	})
+
+	metrics := e.Metrics()
+	want := "# TYPE cpu_kubeedge_exporter gauge\n" +
+		"# TYPE room_temperature_celsius gauge\n" +
+		`room_temperature_celsius{sensorGroup="a",node="[[edge-node]]",type="actual",namespace="default",property="temperature",site_site="munich"} 100` + "\n"
+	if !strings.HasPrefix(metrics, want) {
+		t.Errorf("got\n%s\nwant\n%s", metrics[:strings.Index(metrics, "# HELP")], want)
+	}
+}
+
+func TestDriftFollowsRules(t *testing.T) {
+	e := NewExporter(WithRender(config.Render{
+		RelabelConfigs: []config.RelabelConfig{{SourceLabels: []string{"sensorGroup"}, Regex: "b", Action: "drop"}},
+		// the actual and expected series of a reach the limit, so c is not exported
+		MaxSeries: 2,
+	}))
+	e.store.update(func(next *snapshot) {
+		for name, value := range map[string]int{"a": 25, "b": 30, "c": 35} {
+			next.devices["default/"+name] = buildDevs(testDevice(name, value))
+		}
+	})
+
+	metrics := e.RemoteWriteMetrics()
+	drift := metrics[strings.Index(metrics, "# HELP kubeedge_twin_drift"):]
+	want := "# HELP kubeedge_twin_drift reported minus desired value of the twin\n" +
+		"# TYPE kubeedge_twin_drift gauge\n" +
+		`kubeedge_twin_drift{namespace="default",device="a",property="temperature"} 5` + "\n"
+	if drift != want {
+		t.Errorf("got\n%s\nwant\n%s", drift, want)
+	}
+}
+
+func TestRenderSelections(t *testing.T) {
+	rules := newRenderRules(config.Render{
+		Properties: []string{"temperature", "pressure"},
+		Selections: []config.Selection{
+			{Namespaces: []string{"plant-a"}, LabelSelector: "line=3", Properties: []string{"temperature"}},
+			{Namespaces: []string{"plant-b"}},
+		},
+	})
+	tests := []struct {
+		dev  Dev
+		want bool
+	}{
+		{Dev{Namespace: "plant-a", Name: "temperature", Labels: map[string]string{"line": "3"}}, true},
+		{Dev{Namespace: "plant-a", Name: "pressure", Labels: map[string]string{"line": "3"}}, false},
+		{Dev{Namespace: "plant-a", Name: "temperature", Labels: map[string]string{"line": "4"}}, false},
+		{Dev{Namespace: "plant-b", Name: "pressure"}, true},
+		{Dev{Namespace: "plant-b", Name: "humidity"}, false},
+		{Dev{Namespace: "default", Name: "temperature"}, false},
+	}
+	for _, test := range tests {
+		if got := rules.exports(test.dev); got != test.want {
+			t.Errorf("%s/%s %v: got %v, want %v", test.dev.Namespace, test.dev.Name, test.dev.Labels, got, test.want)
+		}
+	}
+	if !newRenderRules(config.Render{}).exports(Dev{Namespace: "default", Name: "temperature"}) {
+		t.Errorf("twins are not exported without selections")
+	}
+}
+
+func TestRenderScopes(t *testing.T) {
+	scale, namespace := 0.1, "plant-b"
+	scopeA := config.Scope{
+		Namespace:  "plant-a",
+		Labels:     []string{"site"},
+		Transforms: []config.Transform{{Scale: &scale, Metric: "scaled"}},
+		RelabelConfigs: []config.RelabelConfig{
+			{SourceLabels: []string{"namespace"}, Regex: "plant-b", Action: "drop"},
+			{TargetLabel: "namespace", Replacement: &namespace},
+		},
+		MaxSeries: 2,
+	}
+	e := NewExporter(WithRender(config.Render{Scopes: []config.Scope{scopeA}}))
+	e.store.update(func(next *snapshot) {
+		for _, key := range []string{"plant-a/a", "plant-a/b", "plant-b/c"} {
+			device := testDevice(deviceName(key), 300)
+			device.Namespace = deviceNamespace(key)
+			device.Labels = map[string]string{"site": "munich"}
+			next.devices[key] = buildDevs(device)
+		}
+	})
+
+	// the ExporterConfig of plant-a can neither change nor drop the series of plant-b, and its limit only applies to
+	// plant-a
+	metrics := e.Metrics()
+	for _, want := range []string{
+		`scaled{sensorGroup="a",node="[[edge-node]]",sensor="temperature",type="actual",namespace="plant-a",label_site="munich"} 30`,
+		`cpu_kubeedge_exporter{sensorGroup="c",node="[[edge-node]]",sensor="temperature",type="actual",namespace="plant-b"} 300`,
+		`cpu_kubeedge_exporter{sensorGroup="c",node="[[edge-node]]",sensor="temperature",type="expected",namespace="plant-b"} 20`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `sensorGroup="b"`) {
+		t.Errorf("the limit of the ExporterConfig of plant-a is exceeded:\n%s", metrics)
+	}
+
+	// only the twins selected by a scope are exported in selection-only mode
+	scopeA.MaxSeries = 0
+	e.SetRender(config.Render{SelectionOnly: true, Scopes: []config.Scope{scopeA}})
+	if metrics := e.Metrics(); strings.Contains(metrics, `sensorGroup="c"`) || !strings.Contains(metrics, `sensorGroup="b"`) {
+		t.Errorf("unexpected series in selection-only mode:\n%s", metrics)
+	}
+}
+
+func TestRelabelInvalidSeries(t *testing.T) {
+	for _, c := range []config.RelabelConfig{
+		{SourceLabels: []string{"sensor"}, TargetLabel: "${1}-name"},
+		{SourceLabels: []string{"sensor"}, Regex: "(.*)", TargetLabel: "__name__", Replacement: strPtr("kubeedge_fleet_$1")},
+		{SourceLabels: []string{"sensor"}, Regex: "(.*)", TargetLabel: "__name__", Replacement: strPtr("$1 celsius")},
+	} {
+		e := NewExporter(WithRender(config.Render{RelabelConfigs: []config.RelabelConfig{c}}))
+		e.store.update(func(next *snapshot) {
+			next.devices["default/a"] = buildDevs(testDevice("a", 20))
+		})
+		if metrics := e.Metrics(); strings.Contains(metrics, "temperature") {
+			t.Errorf("%+v: the invalid series is exported:\n%s", c, metrics)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestEscapeLabels(t *testing.T) {
+	e := NewExporter()
+	e.store.update(func(next *snapshot) {
+		device := testDevice("a", 20)
+		device.Annotations = map[string]string{typ.AnnotationLabels: `room=hall "A" \ 2`}
+		next.devices["default/a"] = buildDevs(device)
+	})
+	if want := `room="hall \"A\" \\ 2"`; !strings.Contains(e.Metrics(), want) {
+		t.Errorf("metrics do not contain %s:\n%s", want, e.Metrics())
+	}
+
+	l := convergenceLabels{model: "sensor", property: "temperature", node: "edge\nnode"}
+	if got, want := l.String(), `model="sensor",property="temperature",node="edge\nnode"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// SetRender replaces the rendering options; it takes effect with the next request
+func (e *Exporter) SetRender(r config.Render) {
+	e.setRender(r)
+}
+
+// render is a config.Render with its compiled rules; both are replaced together, so a request never pairs the
+// rules of one with the other
+type render struct {
+	conf  config.Render
+	rules *renderRules
+}
+
+func (e *Exporter) setRender(r config.Render) {
+	e.render.Store(&render{conf: r, rules: newRenderRules(r)})
+}
+
+func (e *Exporter) loadRender() *render {
+	return e.render.Load().(*render)
+}
+
+func (e *Exporter) renderRules() *renderRules {
+	return e.loadRender().rules
+}
+
+// Reset removes all devices and nodes; it is used when the informers are restarted
+func (e *Exporter) Reset() {
+	e.store.reset()
+	e.subscribers.notify()
+}
+
+func deviceKey(dev *typ.Device) string {
+	return dev.Namespace + "/" + dev.Name
+}
+
+// deviceNamespace returns the namespace of the device stored under key
+func deviceNamespace(key string) string {
+	return key[:strings.IndexByte(key, '/')]
+}
+
+// deviceName returns the name of the device stored under key
+func deviceName(key string) string {
+	return key[strings.IndexByte(key, '/')+1:]
+}
+
+// Renderer renders the series of single twins like /metrics, e.g. to generate rules which select them
+type Renderer struct {
+	rules *renderRules
+}
+
+// NewRenderer compiles r, which has to be validated by config.Config.Validate
+func NewRenderer(r config.Render) *Renderer {
+	return &Renderer{rules: newRenderRules(r)}
+}
+
+// Series returns the metric name and the labels which select the series of the twin prop of device with the type
+// kind, actual or expected. Without relabel configs the labels are namespace, sensorGroup, sensor and type, otherwise
+// all labels of the series. ok is false if the series is not exported.
+func (r *Renderer) Series(device *typ.Device, prop string, kind string) (name string, labels map[string]string, ok bool) {
+	v, ok := twinDev(device, prop)
+	if !ok || !r.rules.exports(v) {
+		return "", nil, false
+	}
+	name, ls, _, ok := r.rules.apply(v, TwinMetric, twinLabels(r.rules.labels(v.Namespace), v, device.Name, kind), "0")
+	if !ok || !validSeries(name, ls) {
+		return "", nil, false
+	}
+	labels = make(map[string]string, len(ls))
+	for _, l := range ls {
+		if r.rules.relabeled(v.Namespace) || l.name == "namespace" || l.name == "sensorGroup" || l.name == "sensor" || l.name == "type" {
+			labels[l.name] = l.value
+		}
+	}
+	return name, labels, true
+}
+
+// Value transforms the value of the twin prop of device like the samples of its series
+func (r *Renderer) Value(device *typ.Device, prop string, value float64) float64 {
+	v, ok := twinDev(device, prop)
+	if !ok {
+		return value
+	}
+	return r.rules.value(v, value)
+}
+
+// Unit returns the unit of the samples of the twin prop of device whose values have the unit unit
+func (r *Renderer) Unit(device *typ.Device, prop string, unit string) string {
+	v, ok := twinDev(device, prop)
+	if !ok {
+		return unit
+	}
+	if t := r.rules.transform(v); t != nil && t.ToUnit != "" {
+		return t.ToUnit
+	}
+	return unit
+}
+
+// twinDev returns the entry of the twin prop of device, which does not need to be reported yet; ok is false if the
+// annotations of the device do not export it
+func twinDev(device *typ.Device, prop string) (Dev, bool) {
+	d := *device
+	d.Status.Twins = []typ.Twin{{Name: prop}}
+	devs := buildDevs(&d)
+	if len(devs) == 0 {
+		return Dev{}, false
+	}
+	return devs[0], true
+}
+
+// twinLabels returns the labels of the series of the twin v with the type typ, including the namespace and the
+// allowed device labels deviceLabels
+func twinLabels(deviceLabels []string, v Dev, sensor string, typ string) []label {
+	ret := []label{
+		{"sensorGroup", sensor},
+		{"node", fmt.Sprint(v.Node)},
+		{"sensor", v.Name},
+		{"type", typ},
+		{"namespace", v.Namespace},
+	}
+	for _, l := range deviceLabels {
+		if value, ok := v.Labels[l]; ok {
+			ret = append(ret, label{"label_" + sanitizeLabel(l), value})
+		}
+	}
+	if v.export != nil {
+		for _, name := range v.export.LabelNames() {
+			ret = append(ret, label{name, v.export.Labels[name]})
+		}
+	}
+	return ret
+}
+
+// sampleValue converts value of a twin with the type valueTyp into a prometheus sample value;
+// ok is false for strings, empty values and values which are not numbers
+func sampleValue(valueTyp string, value string) (sample string, ok bool) {
+	switch valueTyp {
+	case "string":
+		return "", false
+	case "boolean", "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", false
+		}
+		if b {
+			return "1", true
+		}
+		return "0", true
+	}
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// sanitizeLabel replaces every character of a kubernetes label name which is not allowed in prometheus label names
+func sanitizeLabel(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
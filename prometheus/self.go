@@ -0,0 +1,195 @@
+package prometheus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// SyncStatus is implemented by the source of the events to report the state of its watches
+type SyncStatus interface {
+	HasSynced() bool
+	Healthy() error
+	LastSync() map[string]time.Time
+	WatchRestarts() map[string]uint64
+}
+
+// stats counts the work done by an Exporter
+type stats struct {
+	mutex           sync.Mutex
+	eventsProcessed map[string]map[watch.EventType]uint64
+	scrapeDuration  time.Duration
+}
+
+var processStart = time.Now()
+
+// countEvent increments the number of processed events of resource with the event type typ
+func (s *stats) countEvent(resource string, typ watch.EventType) {
+	s.mutex.Lock()
+	if s.eventsProcessed[resource] == nil {
+		s.eventsProcessed[resource] = make(map[watch.EventType]uint64)
+	}
+	s.eventsProcessed[resource][typ]++
+	s.mutex.Unlock()
+}
+
+func (s *stats) setScrapeDuration(d time.Duration) {
+	s.mutex.Lock()
+	s.scrapeDuration = d
+	s.mutex.Unlock()
+}
+
+func (e *Exporter) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if e.status != nil {
+		if err := e.status.Healthy(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if _, err := w.Write([]byte("ok\n")); err != nil {
+		log.Printf("could not write message; error is: %v", err)
+	}
+}
+
+func (e *Exporter) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if e.status == nil || !e.status.HasSynced() {
+		http.Error(w, "informers have not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := w.Write([]byte("ok\n")); err != nil {
+		log.Printf("could not write message; error is: %v", err)
+	}
+}
+
+// writeSelfMetrics appends the metrics about the exporter itself and the size of snap to message
+func (e *Exporter) writeSelfMetrics(message *strings.Builder, snap *snapshot) {
+	e.stats.mutex.Lock()
+	message.WriteString("# TYPE cpu_kubeedge_exporter_events_processed_total counter\n")
+	for _, resource := range sortedKeys(e.stats.eventsProcessed) {
+		counts := e.stats.eventsProcessed[resource]
+		for _, typ := range sortedEventTypes(counts) {
+			fmt.Fprintf(message, "cpu_kubeedge_exporter_events_processed_total{resource=\"%v\",type=\"%v\"} %v\n", resource, typ, counts[typ])
+		}
+	}
+	message.WriteString("# TYPE cpu_kubeedge_exporter_scrape_duration_seconds gauge\n")
+	fmt.Fprintf(message, "cpu_kubeedge_exporter_scrape_duration_seconds %v\n", e.stats.scrapeDuration.Seconds())
+	e.stats.mutex.Unlock()
+
+	if e.status != nil {
+		synced := 0
+		if e.status.HasSynced() {
+			synced = 1
+		}
+		message.WriteString("# TYPE cpu_kubeedge_exporter_informers_synced gauge\n")
+		fmt.Fprintf(message, "cpu_kubeedge_exporter_informers_synced %v\n", synced)
+		message.WriteString("# TYPE cpu_kubeedge_exporter_informer_last_sync_timestamp_seconds gauge\n")
+		for informer, last := range e.status.LastSync() {
+			if !last.IsZero() {
+				fmt.Fprintf(message, "cpu_kubeedge_exporter_informer_last_sync_timestamp_seconds{informer=\"%v\"} %v\n", informer, last.Unix())
+			}
+		}
+		message.WriteString("# TYPE cpu_kubeedge_exporter_watch_restarts_total counter\n")
+		for informer, restarts := range e.status.WatchRestarts() {
+			fmt.Fprintf(message, "cpu_kubeedge_exporter_watch_restarts_total{informer=\"%v\"} %v\n", informer, restarts)
+		}
+	}
+
+	twins := 0
+	for _, devs := range snap.devices {
+		twins += len(devs)
+	}
+	message.WriteString("# TYPE cpu_kubeedge_exporter_devices gauge\n")
+	fmt.Fprintf(message, "cpu_kubeedge_exporter_devices %v\n", len(snap.devices))
+	message.WriteString("# TYPE cpu_kubeedge_exporter_twins gauge\n")
+	fmt.Fprintf(message, "cpu_kubeedge_exporter_twins %v\n", twins)
+	message.WriteString("# TYPE cpu_kubeedge_exporter_nodes gauge\n")
+	fmt.Fprintf(message, "cpu_kubeedge_exporter_nodes %v\n", len(snap.nodes))
+
+	writeRuntimeMetrics(message)
+	writeProcessMetrics(message)
+}
+
+// writeRuntimeMetrics appends the go runtime metrics to message
+func writeRuntimeMetrics(message *strings.Builder) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	message.WriteString("# TYPE go_goroutines gauge\n")
+	fmt.Fprintf(message, "go_goroutines %v\n", runtime.NumGoroutine())
+	message.WriteString("# TYPE go_info gauge\n")
+	fmt.Fprintf(message, "go_info{version=\"%v\"} 1\n", runtime.Version())
+	message.WriteString("# TYPE go_memstats_alloc_bytes gauge\n")
+	fmt.Fprintf(message, "go_memstats_alloc_bytes %v\n", mem.Alloc)
+	message.WriteString("# TYPE go_memstats_sys_bytes gauge\n")
+	fmt.Fprintf(message, "go_memstats_sys_bytes %v\n", mem.Sys)
+	message.WriteString("# TYPE go_memstats_heap_inuse_bytes gauge\n")
+	fmt.Fprintf(message, "go_memstats_heap_inuse_bytes %v\n", mem.HeapInuse)
+	message.WriteString("# TYPE go_memstats_heap_objects gauge\n")
+	fmt.Fprintf(message, "go_memstats_heap_objects %v\n", mem.HeapObjects)
+	message.WriteString("# TYPE go_gc_runs_total counter\n")
+	fmt.Fprintf(message, "go_gc_runs_total %v\n", mem.NumGC)
+	message.WriteString("# TYPE go_gc_pause_seconds_total counter\n")
+	fmt.Fprintf(message, "go_gc_pause_seconds_total %v\n", float64(mem.PauseTotalNs)/1e9)
+}
+
+// writeProcessMetrics appends the process metrics to message; they are only available on systems with a /proc file system
+func writeProcessMetrics(message *strings.Builder) {
+	message.WriteString("# TYPE process_start_time_seconds gauge\n")
+	fmt.Fprintf(message, "process_start_time_seconds %v\n", processStart.Unix())
+
+	stat, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return
+	}
+	// the command name is in parentheses and may contain spaces, so the fields start after the last one
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	// fields[0] is the third field of /proc/self/stat (state)
+	if len(fields) > 22 {
+		utime, _ := strconv.ParseFloat(fields[11], 64)
+		stime, _ := strconv.ParseFloat(fields[12], 64)
+		vsize, _ := strconv.ParseFloat(fields[20], 64)
+		rss, _ := strconv.ParseFloat(fields[21], 64)
+
+		// the kernel reports cpu time in USER_HZ, which is 100 on all common platforms
+		message.WriteString("# TYPE process_cpu_seconds_total counter\n")
+		fmt.Fprintf(message, "process_cpu_seconds_total %v\n", (utime+stime)/100)
+		message.WriteString("# TYPE process_virtual_memory_bytes gauge\n")
+		fmt.Fprintf(message, "process_virtual_memory_bytes %v\n", vsize)
+		message.WriteString("# TYPE process_resident_memory_bytes gauge\n")
+		fmt.Fprintf(message, "process_resident_memory_bytes %v\n", rss*float64(os.Getpagesize()))
+	}
+
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		message.WriteString("# TYPE process_open_fds gauge\n")
+		fmt.Fprintf(message, "process_open_fds %v\n", len(fds))
+	}
+}
+
+// sortedEventTypes returns the event types of counts in a stable order
+func sortedEventTypes(counts map[watch.EventType]uint64) []watch.EventType {
+	types := make([]watch.EventType, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func sortedKeys(m map[string]map[watch.EventType]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,216 @@
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const defaultStatisticsMaxSamples = 1000
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// WithStatistics enables the statistics of the reported values over sliding windows; conf has to be validated by
+// config.Config.Validate
+func WithStatistics(conf config.Statistics) Option {
+	return func(e *Exporter) {
+		e.statistics = newStatistics(conf)
+	}
+}
+
+// statistics keeps every reported value of the twins within the largest window, so spikes between two scrapes are
+// not lost
+type statistics struct {
+	mutex      sync.Mutex
+	windows    []time.Duration
+	quantiles  []float64
+	maxSamples int
+	// rings are stored under the device key and the property name separated by a slash; only Actual of the points
+	// is used
+	rings map[string]*statisticsRing
+}
+
+// statisticsRing keeps the values with the time they were received, which may differ from the clock of the device
+type statisticsRing struct {
+	ring
+	// reported is the last value with the timestamp of the device; it is only used to order and skip repeated values
+	reported    point
+	hasReported bool
+}
+
+func newStatistics(conf config.Statistics) *statistics {
+	s := &statistics{
+		quantiles:  conf.Quantiles,
+		maxSamples: conf.MaxSamples,
+		rings:      make(map[string]*statisticsRing),
+	}
+	for _, w := range conf.Windows {
+		s.windows = append(s.windows, w.Duration)
+	}
+	sort.Slice(s.windows, func(i, j int) bool { return s.windows[i] < s.windows[j] })
+	if len(s.quantiles) == 0 {
+		s.quantiles = defaultQuantiles
+	}
+	if s.maxSamples == 0 {
+		s.maxSamples = defaultStatisticsMaxSamples
+	}
+	return s
+}
+
+// maxWindow returns the largest window
+func (s *statistics) maxWindow() time.Duration {
+	return s.windows[len(s.windows)-1]
+}
+
+// observe appends the reported values of the twins devs of the device key with the receive time now; devs are the
+// twins with a new value or timestamp, see changes.record. A value is skipped if it repeats the last value with the
+// same timestamp of the device, like a value of the EventBus which is reported again by the Device object, or if it
+// is older than the last value.
+func (s *statistics) observe(key string, devs []Dev, now time.Time) {
+	if len(s.windows) == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, v := range devs {
+		value := historyValue(v.ValueTyp, v.Actual.Value)
+		if math.IsNaN(value) {
+			continue
+		}
+		r := s.rings[key+"/"+v.Name]
+		if r == nil {
+			r = &statisticsRing{}
+			s.rings[key+"/"+v.Name] = r
+		}
+		if ts, err := strconv.ParseInt(v.Actual.Metadata["timestamp"], 10, 64); err == nil {
+			if r.hasReported && (ts < r.reported.T || ts == r.reported.T && value == r.reported.Actual) {
+				continue
+			}
+			r.reported = point{T: ts, Actual: value}
+			r.hasReported = true
+		}
+		r.push(point{T: now.UnixNano() / int64(time.Millisecond), Actual: value, Expected: math.NaN()}, s.maxSamples)
+	}
+}
+
+// expire removes the values which are older than the largest window
+func (s *statistics) expire(now time.Time) {
+	if len(s.windows) == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	oldest := now.Add(-s.maxWindow()).UnixNano() / int64(time.Millisecond)
+	for key, r := range s.rings {
+		k := 0
+		for k < r.n && r.at(k).T < oldest {
+			k++
+		}
+		if k > 0 {
+			r.dropFront(k)
+		}
+		if r.n == 0 {
+			delete(s.rings, key)
+		}
+	}
+}
+
+// forget removes the twins of the device key
+func (s *statistics) forget(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name := range s.rings {
+		if strings.HasPrefix(name, key+"/") {
+			delete(s.rings, name)
+		}
+	}
+}
+
+// write appends kubeedge_twin_reported with the quantiles and kubeedge_twin_reported_min, _max, _avg and _count
+// for every window to message
+func (s *statistics) write(message *strings.Builder, now time.Time) {
+	if len(s.windows) == 0 {
+		return
+	}
+	s.expire(now)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.rings))
+	for name := range s.rings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var quantiles, min, max, avg, count strings.Builder
+	for _, name := range names {
+		r := s.rings[name]
+		i := strings.LastIndexByte(name, '/')
+		key, prop := name[:i], name[i+1:]
+		for _, w := range s.windows {
+			start := now.Add(-w).UnixNano() / int64(time.Millisecond)
+			var values []float64
+			sum := 0.0
+			for k := 0; k < r.n; k++ {
+				if p := r.at(k); p.T >= start {
+					values = append(values, p.Actual)
+					sum += p.Actual
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+			sort.Float64s(values)
+			labels := fmt.Sprintf("namespace=\"%v\",device=\"%v\",property=\"%v\",window=\"%v\"", escapeLabel(deviceNamespace(key)), escapeLabel(deviceName(key)), escapeLabel(prop), windowLabel(w))
+			for _, q := range s.quantiles {
+				fmt.Fprintf(&quantiles, "kubeedge_twin_reported{%v,quantile=\"%v\"} %v\n", labels, q, quantile(values, q))
+			}
+			fmt.Fprintf(&min, "kubeedge_twin_reported_min{%v} %v\n", labels, values[0])
+			fmt.Fprintf(&max, "kubeedge_twin_reported_max{%v} %v\n", labels, values[len(values)-1])
+			fmt.Fprintf(&avg, "kubeedge_twin_reported_avg{%v} %v\n", labels, sum/float64(len(values)))
+			fmt.Fprintf(&count, "kubeedge_twin_reported_count{%v} %v\n", labels, len(values))
+		}
+	}
+	message.WriteString("# HELP kubeedge_twin_reported quantiles of the reported values of the twin within the window\n")
+	message.WriteString("# TYPE kubeedge_twin_reported gauge\n")
+	message.WriteString(quantiles.String())
+	message.WriteString("# HELP kubeedge_twin_reported_min minimum of the reported values of the twin within the window\n")
+	message.WriteString("# TYPE kubeedge_twin_reported_min gauge\n")
+	message.WriteString(min.String())
+	message.WriteString("# HELP kubeedge_twin_reported_max maximum of the reported values of the twin within the window\n")
+	message.WriteString("# TYPE kubeedge_twin_reported_max gauge\n")
+	message.WriteString(max.String())
+	message.WriteString("# HELP kubeedge_twin_reported_avg mean of the reported values of the twin within the window\n")
+	message.WriteString("# TYPE kubeedge_twin_reported_avg gauge\n")
+	message.WriteString(avg.String())
+	message.WriteString("# HELP kubeedge_twin_reported_count number of the reported values of the twin within the window\n")
+	message.WriteString("# TYPE kubeedge_twin_reported_count gauge\n")
+	message.WriteString(count.String())
+}
+
+// quantile returns the q-quantile of the sorted values by the nearest rank
+func quantile(values []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(values)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+// windowLabel formats w like the durations of prometheus, e.g. 5m instead of 5m0s
+func windowLabel(w time.Duration) string {
+	switch {
+	case w%time.Hour == 0:
+		return fmt.Sprintf("%dh", w/time.Hour)
+	case w%time.Minute == 0:
+		return fmt.Sprintf("%dm", w/time.Minute)
+	case w%time.Second == 0:
+		return fmt.Sprintf("%ds", w/time.Second)
+	}
+	return w.String()
+}
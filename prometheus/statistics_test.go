@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func TestStatisticsWindows(t *testing.T) {
+	s := newStatistics(config.Statistics{
+		Windows:    []metav1.Duration{{Duration: 5 * time.Minute}, {Duration: time.Minute}},
+		Quantiles:  []float64{0.5, 1},
+		MaxSamples: 4,
+	})
+	start := time.Unix(1000, 0)
+	twin := func(value string, ts string) []Dev {
+		return []Dev{{Name: "temperature", ValueTyp: "int", Actual: typ.TwinValue{Value: value, Metadata: map[string]string{"timestamp": ts}}}}
+	}
+	// the first value is dropped by maxSamples, the repeated and the older value are skipped; the windows use the
+	// receive time, the clock of the device is a day behind
+	s.observe("default/a", twin("100", "913600000"), start)
+	s.observe("default/a", twin("10", "913610000"), start.Add(10*time.Second))
+	s.observe("default/a", twin("40", "913620000"), start.Add(20*time.Second))
+	s.observe("default/a", twin("40", "913620000"), start.Add(30*time.Second))
+	s.observe("default/a", twin("50", "913615000"), start.Add(40*time.Second))
+	s.observe("default/a", twin("20", "913850000"), start.Add(250*time.Second))
+	s.observe("default/a", twin("30", "913860000"), start.Add(260*time.Second))
+
+	var message strings.Builder
+	s.write(&message, time.Unix(1280, 0))
+	for _, want := range []string{
+		`kubeedge_twin_reported{namespace="default",device="a",property="temperature",window="5m",quantile="0.5"} 20`,
+		`kubeedge_twin_reported{namespace="default",device="a",property="temperature",window="5m",quantile="1"} 40`,
+		`kubeedge_twin_reported_min{namespace="default",device="a",property="temperature",window="5m"} 10`,
+		`kubeedge_twin_reported_avg{namespace="default",device="a",property="temperature",window="5m"} 25`,
+		`kubeedge_twin_reported_count{namespace="default",device="a",property="temperature",window="5m"} 4`,
+		`kubeedge_twin_reported_min{namespace="default",device="a",property="temperature",window="1m"} 20`,
+		`kubeedge_twin_reported_max{namespace="default",device="a",property="temperature",window="1m"} 30`,
+	} {
+		if !strings.Contains(message.String(), want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, message.String())
+		}
+	}
+
+	message.Reset()
+	s.write(&message, time.Unix(2000, 0))
+	if strings.Contains(message.String(), "kubeedge_twin_reported_count{") || len(s.rings) != 0 {
+		t.Errorf("the values outside of the windows were kept:\n%s", message.String())
+	}
+}
+
+func TestStatisticsOnlyChangedTwins(t *testing.T) {
+	e := NewExporter(WithStatistics(config.Statistics{Windows: []metav1.Duration{{Duration: time.Hour}}}))
+	events := make(chan watch.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.Run(events, make(chan watch.Event), stop)
+	// the twins have no timestamp; only the changed twin is observed
+	send := func(resourceVersion string, temperature string) {
+		device := testDevice("a", 0)
+		device.ResourceVersion = resourceVersion
+		device.Status.Twins = []typ.Twin{
+			{Name: "temperature", Actual: typ.TwinValue{Value: temperature}},
+			{Name: "humidity", Actual: typ.TwinValue{Value: "50"}},
+		}
+		events <- watch.Event{Type: watch.Modified, Object: device}
+		events <- watch.Event{Type: watch.Error, Object: device}
+	}
+	send("1", "20")
+	send("2", "21")
+	send("3", "22")
+	// the EventBus reports the value the next Device object repeats
+	e.UpdateTwins("default", "a", []typ.Twin{{Name: "temperature", Actual: typ.TwinValue{Value: "23"}}}, nil)
+	send("4", "23")
+
+	metrics := e.Metrics()
+	for _, want := range []string{
+		`kubeedge_twin_reported_count{namespace="default",device="a",property="temperature",window="1h"} 4`,
+		`kubeedge_twin_reported_count{namespace="default",device="a",property="humidity",window="1h"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package prometheus
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// snapshot is an immutable view of the devices and nodes; it is never modified after it has been published
+type snapshot struct {
+	devices map[string][]Dev
+	// info contains per device an entry without twin, which has the namespace, the model, the labels and the node
+	// selector of devices without twins
+	info  map[string]Dev
+	nodes map[string]int64
+	// invalid contains the validation errors of the devices which are not valid
+	invalid map[string][]string
+	// overlay contains per device the twins received from the EventBus which are newer than the Device object
+	overlay map[string]map[string]typ.Twin
+	// orphans contains the time of the last EventBus update of the overlays of devices without Device object
+	orphans map[string]time.Time
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		devices: make(map[string][]Dev),
+		info:    make(map[string]Dev),
+		nodes:   make(map[string]int64),
+		invalid: make(map[string][]string),
+		overlay: make(map[string]map[string]typ.Twin),
+		orphans: make(map[string]time.Time),
+	}
+}
+
+// clone returns a copy of the maps of s; the values are shared because they are never modified
+func (s *snapshot) clone() *snapshot {
+	c := &snapshot{
+		devices: make(map[string][]Dev, len(s.devices)+1),
+		info:    make(map[string]Dev, len(s.info)+1),
+		nodes:   make(map[string]int64, len(s.nodes)+1),
+		invalid: make(map[string][]string, len(s.invalid)+1),
+		overlay: make(map[string]map[string]typ.Twin, len(s.overlay)+1),
+		orphans: make(map[string]time.Time, len(s.orphans)+1),
+	}
+	for k, v := range s.devices {
+		c.devices[k] = v
+	}
+	for k, v := range s.info {
+		c.info[k] = v
+	}
+	for k, v := range s.nodes {
+		c.nodes[k] = v
+	}
+	for k, v := range s.invalid {
+		c.invalid[k] = v
+	}
+	for k, v := range s.overlay {
+		c.overlay[k] = v
+	}
+	for k, v := range s.orphans {
+		c.orphans[k] = v
+	}
+	return c
+}
+
+// deviceKeys returns the sorted keys of the devices of s
+func (s *snapshot) deviceKeys() []string {
+	keys := make([]string, 0, len(s.devices))
+	for key := range s.devices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nodeNames returns the sorted names of the nodes of s
+func (s *snapshot) nodeNames() []string {
+	names := make([]string, 0, len(s.nodes))
+	for name := range s.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// store publishes a new snapshot for every update, so readers never block writers and always see a consistent state
+type store struct {
+	// mutex serialises the writers; readers only load the current snapshot
+	mutex   sync.Mutex
+	current atomic.Value
+}
+
+func newStore() *store {
+	s := &store{}
+	s.current.Store(newSnapshot())
+	return s
+}
+
+// load returns the current snapshot; it must not be modified
+func (s *store) load() *snapshot {
+	return s.current.Load().(*snapshot)
+}
+
+// update copies the current snapshot, applies fn to the copy and publishes it
+func (s *store) update(fn func(next *snapshot)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	next := s.load().clone()
+	fn(next)
+	s.current.Store(next)
+}
+
+// reset publishes an empty snapshot
+func (s *store) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.current.Store(newSnapshot())
+}
@@ -0,0 +1,232 @@
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func testDevice(name string, value int) *typ.Device {
+	return &typ.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: typ.DeviceSpec{
+			NodeSelector: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{
+						Key:      "name",
+						Operator: v1.NodeSelectorOpIn,
+						Values:   []string{"edge-node"},
+					}},
+				}},
+			},
+		},
+		Status: typ.DeviceStatus{
+			Twins: []typ.Twin{{
+				Name: "temperature",
+				Actual: typ.TwinValue{
+					Value:    fmt.Sprint(value),
+					Metadata: map[string]string{"type": "int"},
+				},
+				Desired: typ.TwinValue{Value: "20"},
+			}},
+		},
+	}
+}
+
+func testNode(name string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func TestStoreSnapshotIsImmutable(t *testing.T) {
+	s := newStore()
+	s.update(func(next *snapshot) {
+		next.devices["default/a"] = buildDevs(testDevice("a", 1))
+	})
+	old := s.load()
+
+	s.update(func(next *snapshot) {
+		delete(next.devices, "default/a")
+		next.devices["default/b"] = buildDevs(testDevice("b", 2))
+	})
+	s.update(func(next *snapshot) {
+		next.nodes["edge-node"] = 1
+	})
+
+	if _, ok := old.devices["default/a"]; !ok || len(old.devices) != 1 {
+		t.Errorf("published snapshot was modified: %v", old.devices)
+	}
+	if len(old.nodes) != 0 {
+		t.Errorf("published snapshot was modified: %v", old.nodes)
+	}
+	cur := s.load()
+	if _, ok := cur.devices["default/b"]; !ok || len(cur.devices) != 1 {
+		t.Errorf("unexpected devices in current snapshot: %v", cur.devices)
+	}
+	if cur.nodes["edge-node"] != 1 {
+		t.Errorf("unexpected nodes in current snapshot: %v", cur.nodes)
+	}
+
+	s.reset()
+	if cur := s.load(); len(cur.devices) != 0 || len(cur.nodes) != 0 {
+		t.Errorf("reset did not clear the snapshot: %v %v", cur.devices, cur.nodes)
+	}
+}
+
+func TestBuildDevsWithoutNodeSelector(t *testing.T) {
+	device := testDevice("a", 1)
+	device.Spec.NodeSelector = nil
+
+	devs := buildDevs(device)
+	if len(devs) != 1 || devs[0].Name != "temperature" || devs[0].Node != nil {
+		t.Errorf("unexpected devs: %+v", devs)
+	}
+}
+
+// TestConcurrentEventsAndScrapes is meant to be run with -race
+func TestConcurrentEventsAndScrapes(t *testing.T) {
+	e := NewExporter()
+	events := make(chan watch.Event)
+	eve := make(chan watch.Event)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		e.Run(events, eve, stop)
+		close(done)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			name := fmt.Sprintf("device-%d", i%10)
+			typ := watch.Added
+			switch i % 3 {
+			case 1:
+				typ = watch.Modified
+			case 2:
+				typ = watch.Deleted
+			}
+			events <- watch.Event{Type: typ, Object: testDevice(name, i)}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			typ := watch.Added
+			if i%2 == 1 {
+				typ = watch.Deleted
+			}
+			eve <- watch.Event{Type: typ, Object: testNode(fmt.Sprintf("node-%d", i%5))}
+		}
+	}()
+
+	var scrapes sync.WaitGroup
+	for _, path := range []string{"/", "/metrics"} {
+		scrapes.Add(1)
+		go func(path string) {
+			defer scrapes.Done()
+			for i := 0; i < 50; i++ {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("GET %s returned %v", path, rec.Code)
+				}
+			}
+		}(path)
+	}
+
+	wg.Wait()
+	scrapes.Wait()
+	close(stop)
+	<-done
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "cpu_kubeedge_exporter_events_processed_total{resource=\"device\",type=\"ADDED\"}") {
+		t.Errorf("device events were not counted:\n%s", rec.Body.String())
+	}
+}
+
+func TestSubscribeMergesChanges(t *testing.T) {
+	e := NewExporter()
+	changes := e.Subscribe()
+	events := make(chan watch.Event)
+	eve := make(chan watch.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.Run(events, eve, stop)
+
+	// events of an unknown type do not notify; sending one ensures that the previous event has been processed
+	barrier := func() {
+		eve <- watch.Event{Type: watch.Error, Object: testNode("edge-node")}
+	}
+	pending := func() bool {
+		select {
+		case <-changes:
+			return true
+		default:
+			return false
+		}
+	}
+
+	events <- watch.Event{Type: watch.Added, Object: testDevice("a", 1)}
+	events <- watch.Event{Type: watch.Modified, Object: testDevice("a", 2)}
+	eve <- watch.Event{Type: watch.Added, Object: testNode("edge-node")}
+	barrier()
+	if !pending() {
+		t.Fatalf("no notification after changes")
+	}
+	if pending() {
+		t.Errorf("the changes were not merged into one notification")
+	}
+
+	events <- watch.Event{Type: watch.Deleted, Object: testDevice("a", 2)}
+	barrier()
+	if !pending() {
+		t.Errorf("no notification after the device was deleted")
+	}
+}
+
+func TestBuildDevsAnnotations(t *testing.T) {
+	device := testDevice("a", 1)
+	device.Status.Twins = append(device.Status.Twins,
+		typ.Twin{Name: "pressure", Actual: typ.TwinValue{Value: "1013", Metadata: map[string]string{"type": "string"}}},
+		typ.Twin{Name: "serial", Actual: typ.TwinValue{Value: "42"}})
+	device.Annotations = map[string]string{
+		typ.AnnotationProperties:              "temperature,pressure",
+		typ.AnnotationTypePrefix + "pressure": "float",
+		typ.AnnotationLabels:                  "site=munich",
+	}
+
+	devs := buildDevs(device)
+	if len(devs) != 2 || devs[1].Name != "pressure" || devs[1].ValueTyp != "float" {
+		t.Fatalf("unexpected twins %+v", devs)
+	}
+	// the type of the annotation wins over the type reported on the EventBus
+	devs = applyOverlay(devs, map[string]typ.Twin{
+		"pressure": {Name: "pressure", Actual: typ.TwinValue{Value: "1014", Metadata: map[string]string{"type": "string"}}},
+		"serial":   {Name: "serial", Actual: typ.TwinValue{Value: "43"}},
+	})
+	if len(devs) != 2 || devs[1].ValueTyp != "float" || devs[1].Actual.Value != "1014" {
+		t.Errorf("unexpected twins after the overlay %+v", devs)
+	}
+	labels := twinLabels(nil, devs[0], "a", "actual")
+	if last := labels[len(labels)-1]; last != (label{"site", "munich"}) {
+		t.Errorf("the static label is missing in %v", labels)
+	}
+
+	device.Annotations = map[string]string{typ.AnnotationScrape: "false"}
+	if devs := buildDevs(device); len(devs) != 0 {
+		t.Errorf("twins of a device with scrape false are exported: %+v", devs)
+	}
+}
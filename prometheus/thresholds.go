@@ -0,0 +1,261 @@
+package prometheus
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// evaluateInterval is the time between two evaluations of all twins, so the grace periods expire without events
+const evaluateInterval = 10 * time.Second
+
+// Alert is a threshold violation in the model of the Alertmanager API
+type Alert struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	StartsAt    time.Time
+	// EndsAt is the time the violation has been resolved; it is zero while the alert fires
+	EndsAt time.Time
+}
+
+// Notifier receives the alerts of the threshold violations when they fire and when they are resolved
+type Notifier interface {
+	Notify(alerts []Alert)
+}
+
+// WithThresholds sets the thresholds the twins are checked against; thresholds has to be validated by
+// config.Config.Validate
+func WithThresholds(thresholds []config.Threshold) Option {
+	return func(e *Exporter) {
+		e.thresholds.thresholds = nil
+		for _, t := range thresholds {
+			selector, _ := labels.Parse(t.LabelSelector)
+			e.thresholds.thresholds = append(e.thresholds.thresholds, threshold{Threshold: t, selector: selector})
+		}
+	}
+}
+
+// WithNotifier sets the Notifier of the threshold violations
+func WithNotifier(notifier Notifier) Option {
+	return func(e *Exporter) {
+		e.notifier = notifier
+	}
+}
+
+type threshold struct {
+	config.Threshold
+	selector labels.Selector
+}
+
+// violation is the state of a threshold for a twin
+type violation struct {
+	key                                    string
+	namespace, device, property, threshold string
+	// since is the time the twin started to violate the threshold; it is zero if the twin is within the threshold
+	since  time.Time
+	firing bool
+	reason string
+}
+
+// evaluator keeps the state of the thresholds of every twin they apply to
+type evaluator struct {
+	mutex      sync.Mutex
+	thresholds []threshold
+	// violations are stored under the threshold name, the device key and the property separated by slashes
+	violations map[string]*violation
+	// objects are the latest Device objects, which are the subject of the Kubernetes Events
+	objects map[string]*typ.Device
+}
+
+func (ev *evaluator) setObject(key string, device *typ.Device) {
+	if len(ev.thresholds) == 0 {
+		return
+	}
+	ev.mutex.Lock()
+	defer ev.mutex.Unlock()
+	if device == nil {
+		delete(ev.objects, key)
+	} else {
+		ev.objects[key] = device
+	}
+}
+
+// transition is a change of a violation which is reported as event and alert
+type transition struct {
+	device  *typ.Device
+	v       violation
+	resolve bool
+}
+
+// evaluateThresholds checks the twins of the devices keys at now against the thresholds, or of all devices if keys is
+// nil; violations which start or end after their grace period are recorded as events and sent to the notifier
+func (e *Exporter) evaluateThresholds(keys []string, now time.Time) {
+	ev := &e.thresholds
+	if len(ev.thresholds) == 0 {
+		return
+	}
+	rules := e.renderRules()
+
+	ev.mutex.Lock()
+	// the snapshot is loaded under the lock, so a later evaluation never sees an older snapshot
+	snap := e.store.load()
+	if keys == nil {
+		keys = snap.deviceKeys()
+		removed := make(map[string]bool)
+		for _, state := range ev.violations {
+			if _, ok := snap.devices[state.key]; !ok && !removed[state.key] {
+				removed[state.key] = true
+				keys = append(keys, state.key)
+			}
+		}
+	}
+	var changes []transition
+	for _, key := range keys {
+		seen := make(map[string]bool)
+		for _, v := range snap.devices[key] {
+			for _, t := range ev.thresholds {
+				if t.Property != v.Name || !t.selector.Matches(labels.Set(v.Labels)) {
+					continue
+				}
+				name := t.Name + "/" + key + "/" + v.Name
+				seen[name] = true
+				state, ok := ev.violations[name]
+				if !ok {
+					state = &violation{key: key, namespace: v.Namespace, device: deviceName(key), property: v.Name, threshold: t.Name}
+					ev.violations[name] = state
+				}
+
+				reason := t.violation(v, rules)
+				switch {
+				case reason == "":
+					if state.firing {
+						changes = append(changes, transition{device: ev.objects[key], v: *state, resolve: true})
+					}
+					state.since, state.firing, state.reason = time.Time{}, false, ""
+				case state.since.IsZero():
+					state.since, state.reason = now, reason
+				default:
+					state.reason = reason
+				}
+				if reason != "" && !state.firing && now.Sub(state.since) >= t.For.Duration {
+					state.firing = true
+					changes = append(changes, transition{device: ev.objects[key], v: *state})
+				}
+			}
+		}
+		for name, state := range ev.violations {
+			if state.key != key || seen[name] {
+				continue
+			}
+			if state.firing {
+				changes = append(changes, transition{device: ev.objects[key], v: *state, resolve: true})
+			}
+			delete(ev.violations, name)
+		}
+	}
+	ev.mutex.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+	alerts := make([]Alert, 0, len(changes))
+	for _, c := range changes {
+		message := fmt.Sprintf("twin %s violates threshold %s: %s", c.v.property, c.v.threshold, c.v.reason)
+		if c.resolve {
+			message = fmt.Sprintf("twin %s is within threshold %s again", c.v.property, c.v.threshold)
+		}
+		log.Printf("device %s/%s: %s", c.v.namespace, c.v.device, message)
+		if e.recorder != nil && c.device != nil {
+			if c.resolve {
+				e.recorder.Normal(c.device, "ThresholdResolved", message)
+			} else {
+				e.recorder.Warning(c.device, "ThresholdViolated", message)
+			}
+		}
+		alerts = append(alerts, e.alert(c, now))
+	}
+	if e.notifier != nil {
+		e.notifier.Notify(alerts)
+	}
+}
+
+// alert converts the transition c into an Alert
+func (e *Exporter) alert(c transition, now time.Time) Alert {
+	a := Alert{
+		Labels: map[string]string{
+			"alertname": c.v.threshold,
+			"namespace": c.v.namespace,
+			"device":    c.v.device,
+			"property":  c.v.property,
+		},
+		Annotations: map[string]string{
+			"summary":     fmt.Sprintf("twin %s of device %s/%s violates threshold %s", c.v.property, c.v.namespace, c.v.device, c.v.threshold),
+			"description": c.v.reason,
+		},
+		StartsAt: c.v.since,
+	}
+	for _, t := range e.thresholds.thresholds {
+		if t.Name == c.v.threshold && t.Severity != "" {
+			a.Labels["severity"] = t.Severity
+		}
+	}
+	if c.resolve {
+		a.EndsAt = now
+	}
+	return a
+}
+
+// violation returns why the twin v violates t, or an empty string; the values are transformed by rules like the
+// samples of the twin series
+func (t threshold) violation(v Dev, rules *renderRules) string {
+	actual := rules.value(v, historyValue(v.ValueTyp, v.Actual.Value))
+	if math.IsNaN(actual) {
+		return ""
+	}
+	if t.Min != nil && actual < *t.Min {
+		return fmt.Sprintf("the reported value %v is below the minimum %v", actual, *t.Min)
+	}
+	if t.Max != nil && actual > *t.Max {
+		return fmt.Sprintf("the reported value %v is above the maximum %v", actual, *t.Max)
+	}
+	expected := rules.value(v, historyValue(v.ValueTyp, v.Expected.Value))
+	if t.MaxDivergence != nil && !math.IsNaN(expected) && math.Abs(actual-expected) > *t.MaxDivergence {
+		return fmt.Sprintf("the reported value %v differs by more than %v from the desired value %v", actual, *t.MaxDivergence, expected)
+	}
+	return ""
+}
+
+// writeThresholds appends the kubeedge_twin_threshold_violation gauge of every twin a threshold applies to
+func (e *Exporter) writeThresholds(message *strings.Builder) {
+	ev := &e.thresholds
+	if len(ev.thresholds) == 0 {
+		return
+	}
+	ev.mutex.Lock()
+	names := make([]string, 0, len(ev.violations))
+	for name := range ev.violations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	message.WriteString("# HELP kubeedge_twin_threshold_violation 1 if the twin violates the threshold for longer than its grace period\n")
+	message.WriteString("# TYPE kubeedge_twin_threshold_violation gauge\n")
+	for _, name := range names {
+		v := ev.violations[name]
+		firing := 0
+		if v.firing {
+			firing = 1
+		}
+		fmt.Fprintf(message, "kubeedge_twin_threshold_violation{namespace=\"%v\",device=\"%v\",property=\"%v\",threshold=\"%v\"} %v\n", escapeLabel(v.namespace), escapeLabel(v.device), escapeLabel(v.property), escapeLabel(v.threshold), firing)
+	}
+	ev.mutex.Unlock()
+}
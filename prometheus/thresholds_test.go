@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+type fakeRecorder struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (r *fakeRecorder) Warning(device *typ.Device, reason string, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, "Warning "+device.Name+" "+reason)
+}
+
+func (r *fakeRecorder) Normal(device *typ.Device, reason string, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, "Normal "+device.Name+" "+reason)
+}
+
+type fakeNotifier struct {
+	alerts []Alert
+}
+
+func (n *fakeNotifier) Notify(alerts []Alert) {
+	n.alerts = append(n.alerts, alerts...)
+}
+
+func TestThresholdGracePeriodAndResolve(t *testing.T) {
+	max, divergence := 30.0, 1.0
+	recorder := &fakeRecorder{}
+	notifier := &fakeNotifier{}
+	e := NewExporter(WithRecorder(recorder), WithNotifier(notifier), WithThresholds([]config.Threshold{
+		{Name: "TooHot", Property: "temperature", Max: &max, For: metav1.Duration{Duration: time.Minute}, Severity: "critical"},
+		{Name: "OutOfSync", Property: "temperature", MaxDivergence: &divergence, LabelSelector: "site=munich"},
+	}))
+	now := time.Unix(1000, 0)
+	e.now = func() time.Time { return now }
+
+	events := make(chan watch.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.Run(events, make(chan watch.Event), stop)
+	send := func(device *typ.Device) {
+		events <- watch.Event{Type: watch.Modified, Object: device.DeepCopy()}
+		// the second event ensures that the first one has been processed
+		events <- watch.Event{Type: watch.Error, Object: device}
+	}
+
+	device := testDevice("a", 35)
+	send(device)
+	if len(notifier.alerts) != 0 {
+		t.Fatalf("the alert fired within the grace period: %+v", notifier.alerts)
+	}
+	metrics := e.Metrics()
+	if want := `kubeedge_twin_threshold_violation{namespace="default",device="a",property="temperature",threshold="TooHot"} 0`; !strings.Contains(metrics, want) {
+		t.Errorf("metrics do not contain %s", want)
+	}
+	if strings.Contains(metrics, `threshold="OutOfSync"`) {
+		t.Errorf("the label selector of OutOfSync was ignored")
+	}
+
+	now = now.Add(time.Minute)
+	e.evaluateThresholds(nil, e.now())
+	if len(notifier.alerts) != 1 || notifier.alerts[0].Labels["alertname"] != "TooHot" || notifier.alerts[0].Labels["severity"] != "critical" ||
+		!notifier.alerts[0].StartsAt.Equal(time.Unix(1000, 0)) || !notifier.alerts[0].EndsAt.IsZero() {
+		t.Fatalf("unexpected alerts %+v", notifier.alerts)
+	}
+	if !strings.Contains(e.Metrics(), `threshold="TooHot"} 1`) {
+		t.Errorf("the violation gauge is not set")
+	}
+	// the alert is sent only once
+	e.evaluateThresholds(nil, e.now())
+	if len(notifier.alerts) != 1 {
+		t.Errorf("the alert was sent again: %+v", notifier.alerts)
+	}
+
+	send(testDevice("a", 25))
+	if len(notifier.alerts) != 2 || notifier.alerts[1].EndsAt.IsZero() {
+		t.Errorf("the alert was not resolved: %+v", notifier.alerts)
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if fmt.Sprint(recorder.events) != "[Warning a InvalidDevice Warning a ThresholdViolated Normal a ThresholdResolved]" {
+		t.Errorf("unexpected events %v", recorder.events)
+	}
+}
+
+func TestThresholdResolvedOnDelete(t *testing.T) {
+	divergence := 1.0
+	notifier := &fakeNotifier{}
+	e := NewExporter(WithNotifier(notifier), WithThresholds([]config.Threshold{{Name: "OutOfSync", Property: "temperature", MaxDivergence: &divergence}}))
+
+	events := make(chan watch.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.Run(events, make(chan watch.Event), stop)
+
+	device := testDevice("a", 25)
+	events <- watch.Event{Type: watch.Added, Object: device}
+	events <- watch.Event{Type: watch.Deleted, Object: device}
+	events <- watch.Event{Type: watch.Error, Object: device}
+	if len(notifier.alerts) != 2 || notifier.alerts[0].EndsAt.IsZero() == notifier.alerts[1].EndsAt.IsZero() {
+		t.Errorf("unexpected alerts %+v", notifier.alerts)
+	}
+	if strings.Contains(e.Metrics(), "kubeedge_twin_threshold_violation{") {
+		t.Errorf("the gauge of a deleted device was kept")
+	}
+}
+
+func TestThresholdTransformedValues(t *testing.T) {
+	max, scale := 50.0, 0.1
+	th := threshold{Threshold: config.Threshold{Name: "TooHot", Property: "temperature", Max: &max}}
+	v := Dev{Name: "temperature", Model: "sensor", Actual: typ.TwinValue{Value: "300"}}
+	if th.violation(v, newRenderRules(config.Render{})) == "" {
+		t.Errorf("the raw value 300 does not violate the maximum 50")
+	}
+	rules := newRenderRules(config.Render{Transforms: []config.Transform{{Model: "sensor", Scale: &scale}}})
+	if reason := th.violation(v, rules); reason != "" {
+		t.Errorf("the transformed value 30 violates the maximum 50: %s", reason)
+	}
+}
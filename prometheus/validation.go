@@ -0,0 +1,66 @@
+package prometheus
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// Recorder records Kubernetes Events about devices
+type Recorder interface {
+	Warning(device *typ.Device, reason string, message string)
+	Normal(device *typ.Device, reason string, message string)
+}
+
+// WithRecorder sets the Recorder which is used to report invalid devices and threshold violations
+func WithRecorder(recorder Recorder) Option {
+	return func(e *Exporter) {
+		e.recorder = recorder
+	}
+}
+
+// validate returns the validation errors of device; changes of the result are logged and recorded as event
+func (e *Exporter) validate(key string, device *typ.Device) []string {
+	var problems []string
+	for _, err := range typ.ValidateDevice(device) {
+		problems = append(problems, err.Error())
+	}
+	message := strings.Join(problems, "; ")
+	if e.reported[key] == message {
+		return problems
+	}
+
+	if message == "" {
+		log.Printf("device %s is valid again", key)
+		delete(e.reported, key)
+		return problems
+	}
+	e.reported[key] = message
+	log.Printf("warning: device %s is invalid: %s", key, message)
+	if e.recorder != nil {
+		e.recorder.Warning(device, "InvalidDevice", message)
+	}
+	return problems
+}
+
+func (e *Exporter) forgetInvalid(key string) {
+	delete(e.reported, key)
+}
+
+// writeInvalid appends the kubeedge_device_invalid gauge of every device in snap to message
+func writeInvalid(message *strings.Builder, snap *snapshot) {
+	keys := snap.deviceKeys()
+
+	message.WriteString("# HELP kubeedge_device_invalid 1 if the device object has validation errors\n")
+	message.WriteString("# TYPE kubeedge_device_invalid gauge\n")
+	for _, key := range keys {
+		invalid := 0
+		if len(snap.invalid[key]) > 0 {
+			invalid = 1
+		}
+		namespace := key[:strings.IndexByte(key, '/')]
+		fmt.Fprintf(message, "kubeedge_device_invalid{namespace=\"%v\",device=\"%v\"} %v\n", escapeLabel(namespace), escapeLabel(deviceName(key)), invalid)
+	}
+}
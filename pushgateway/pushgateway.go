@@ -0,0 +1,135 @@
+// Package pushgateway pushes the metrics of the exporter to a Prometheus Pushgateway
+package pushgateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// Pusher pushes the metrics rendered by source to the group of a Pushgateway
+type Pusher struct {
+	source   func() string
+	conf     config.Pushgateway
+	url      string
+	interval time.Duration
+	client   *http.Client
+}
+
+// New creates a Pusher; conf has to be validated by config.Config.Validate
+func New(source func() string, conf config.Pushgateway) *Pusher {
+	p := &Pusher{
+		source:   source,
+		conf:     conf,
+		url:      groupURL(conf),
+		interval: conf.Interval.Duration,
+		client:   &http.Client{Timeout: conf.Timeout.Duration},
+	}
+	if p.interval == 0 {
+		p.interval = defaultInterval
+	}
+	if p.client.Timeout == 0 {
+		p.client.Timeout = defaultTimeout
+	}
+	return p
+}
+
+// groupURL returns the url of the group identified by the job and the grouping labels of conf
+func groupURL(conf config.Pushgateway) string {
+	u := strings.TrimSuffix(conf.URL, "/") + "/metrics/job" + groupingSegment(conf.Job)
+
+	names := make([]string, 0, len(conf.Grouping))
+	for name := range conf.Grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		u += "/" + name + groupingSegment(conf.Grouping[name])
+	}
+	return u
+}
+
+// groupingSegment encodes a value of the grouping key; values which can not be a path segment are base64 encoded
+func groupingSegment(value string) string {
+	if value != "" && !strings.ContainsRune(value, '/') {
+		return "/" + url.PathEscape(value)
+	}
+	enc := base64.URLEncoding.EncodeToString([]byte(value))
+	if enc == "" {
+		// the Pushgateway encodes the empty value as a single padding character
+		enc = "="
+	}
+	return "@base64/" + enc
+}
+
+// Push replaces the metrics of the group with the current metrics
+func (p *Pusher) Push() error {
+	return p.do(http.MethodPut, []byte(p.source()))
+}
+
+// Delete removes the group from the Pushgateway
+func (p *Pusher) Delete() error {
+	return p.do(http.MethodDelete, nil)
+}
+
+func (p *Pusher) do(method string, body []byte) error {
+	req, err := http.NewRequest(method, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	}
+	if p.conf.BasicAuth != nil {
+		req.SetBasicAuth(p.conf.BasicAuth.Username, p.conf.BasicAuth.Password)
+	}
+	if p.conf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.conf.BearerToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	return fmt.Errorf("%s %s returned %v: %s", method, p.url, resp.Status, bytes.TrimSpace(msg))
+}
+
+// Run pushes the metrics immediately and then every interval until stop is closed; afterwards the group is deleted
+func (p *Pusher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Push(); err != nil {
+			log.Printf("can not push to the pushgateway; err is: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err := p.Delete(); err != nil {
+				log.Printf("can not delete the group from the pushgateway; err is: %v", err)
+			}
+			return
+		}
+	}
+}
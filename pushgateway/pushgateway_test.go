@@ -0,0 +1,100 @@
+package pushgateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+func TestGroupURL(t *testing.T) {
+	for _, c := range []struct {
+		conf config.Pushgateway
+		want string
+	}{
+		{config.Pushgateway{URL: "http://gw:9091/", Job: "kubeedge"}, "http://gw:9091/metrics/job/kubeedge"},
+		{
+			config.Pushgateway{URL: "http://gw:9091", Job: "kubeedge", Grouping: map[string]string{"site": "munich", "cluster": "edge 1"}},
+			"http://gw:9091/metrics/job/kubeedge/cluster/edge%201/site/munich",
+		},
+		{
+			config.Pushgateway{URL: "http://gw:9091", Job: "a/b", Grouping: map[string]string{"site": ""}},
+			"http://gw:9091/metrics/job@base64/YS9i/site@base64/=",
+		},
+	} {
+		if got := groupURL(c.conf); got != c.want {
+			t.Errorf("got %v, want %v", got, c.want)
+		}
+	}
+}
+
+type request struct {
+	method, path, auth, body string
+}
+
+func TestRunPushesAndDeletes(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, request{r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)})
+		mutex.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	metrics := "# TYPE cpu_kubeedge_exporter gauge\ncpu_kubeedge_exporter{sensor=\"temperature\"} 21\n"
+	p := New(func() string { return metrics }, config.Pushgateway{
+		URL:         server.URL,
+		Job:         "kubeedge",
+		Grouping:    map[string]string{"site": "munich"},
+		Interval:    metav1.Duration{Duration: 10 * time.Millisecond},
+		BearerToken: "token",
+	})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Run(stop)
+		close(done)
+	}()
+
+	for {
+		mutex.Lock()
+		n := len(requests)
+		mutex.Unlock()
+		if n >= 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := request{http.MethodPut, "/metrics/job/kubeedge/site/munich", "Bearer token", metrics}
+	if requests[0] != want || requests[1] != want {
+		t.Errorf("got %+v, want two pushes %+v", requests[:2], want)
+	}
+	if last := requests[len(requests)-1]; last.method != http.MethodDelete || last.path != want.path {
+		t.Errorf("the group was not deleted; last request is %+v", last)
+	}
+}
+
+func TestPushReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := New(func() string { return "x 1\n" }, config.Pushgateway{URL: server.URL, Job: "kubeedge"}).Push(); err == nil {
+		t.Errorf("the rejected push was not reported")
+	}
+}
@@ -0,0 +1,168 @@
+// Package record writes the events received by the exporter to a JSON lines file and replays such files
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+const (
+	// Device marks events of the device channel
+	Device = "device"
+	// Node marks events of the node channel
+	Node = "node"
+)
+
+// Entry is one line of a recording
+type Entry struct {
+	Time     time.Time       `json:"time"`
+	Resource string          `json:"resource"`
+	Type     watch.EventType `json:"type"`
+	Object   json.RawMessage `json:"object"`
+}
+
+// Writer writes events as JSON lines; it is safe for concurrent use
+type Writer struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+}
+
+// NewWriter creates a Writer which writes to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Write appends ev received on the channel of resource to the recording
+func (w *Writer) Write(resource string, ev watch.Event) error {
+	obj, err := json.Marshal(ev.Object)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.enc.Encode(Entry{Time: time.Now(), Resource: resource, Type: ev.Type, Object: obj})
+}
+
+// Tee passes every event of in to out and records it with w as event of resource; it returns when in is closed
+func Tee(w *Writer, resource string, in <-chan watch.Event, out chan<- watch.Event) {
+	for ev := range in {
+		if err := w.Write(resource, ev); err != nil {
+			log.Printf("can not record %s event; err is: %v", resource, err)
+		}
+		out <- ev
+	}
+}
+
+// Clock is the time of a replay. Now is the time of the last replayed entry; with a speed other than 0 the time which
+// has passed since the entry was replayed is added, multiplied by the speed. EventTime is the time of the entry of a
+// replayed object, so it does not depend on when the receiver processes the event.
+type Clock struct {
+	mutex    sync.Mutex
+	speed    float64
+	entry    time.Time
+	replayed time.Time
+	// events are the times of the entries of the objects which have not been passed to EventTime
+	events map[runtime.Object]time.Time
+}
+
+// NewClock creates the Clock of a replay with speed; it returns the current time until the first entry is replayed
+func NewClock(speed float64) *Clock {
+	return &Clock{speed: speed, events: make(map[runtime.Object]time.Time)}
+}
+
+// Now returns the time of the replay
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entry.IsZero() {
+		return time.Now()
+	}
+	return c.entry.Add(time.Duration(float64(time.Since(c.replayed)) * c.speed))
+}
+
+// EventTime returns the time of the entry of obj, or Now if obj has not been replayed
+func (c *Clock) EventTime(obj runtime.Object) time.Time {
+	c.mutex.Lock()
+	t, ok := c.events[obj]
+	delete(c.events, obj)
+	c.mutex.Unlock()
+	if !ok {
+		return c.Now()
+	}
+	return t
+}
+
+// set records the entry of obj before it is sent
+func (c *Clock) set(obj runtime.Object, entry time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entry, c.replayed = entry, time.Now()
+	c.events[obj] = entry
+}
+
+// Replay sends the events recorded in r to events and eve; the delays between the events are divided by speed,
+// a speed of 0 sends the events without delay. The times of the entries are recorded in clock if it is not nil.
+// Replay returns early if stop is closed.
+func Replay(r io.Reader, events chan<- watch.Event, eve chan<- watch.Event, speed float64, clock *Clock, stop <-chan struct{}) error {
+	scanner := bufio.NewScanner(r)
+	// device objects with many twins do not fit into the default buffer of 64 KiB
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+
+	var last time.Time
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("line %v: %v", line, err)
+		}
+
+		var obj runtime.Object
+		var out chan<- watch.Event
+		switch entry.Resource {
+		case Device:
+			obj, out = &typ.Device{}, events
+		case Node:
+			obj, out = &v1.Node{}, eve
+		default:
+			return fmt.Errorf("line %v: unknown resource %q", line, entry.Resource)
+		}
+		if err := json.Unmarshal(entry.Object, obj); err != nil {
+			return fmt.Errorf("line %v: %v", line, err)
+		}
+
+		if speed > 0 && !last.IsZero() && entry.Time.After(last) {
+			select {
+			case <-time.After(time.Duration(float64(entry.Time.Sub(last)) / speed)):
+			case <-stop:
+				return nil
+			}
+		}
+		last = entry.Time
+		if clock != nil {
+			clock.set(obj, entry.Time)
+		}
+
+		select {
+		case out <- watch.Event{Type: entry.Type, Object: obj}:
+		case <-stop:
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,102 @@
+package record
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	in := make(chan watch.Event)
+	out := make(chan watch.Event)
+	go Tee(w, Device, in, out)
+
+	device := &typ.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "sensor", Namespace: "default"},
+		Status: typ.DeviceStatus{Twins: []typ.Twin{{
+			Name:   "temperature",
+			Actual: typ.TwinValue{Value: "21", Metadata: map[string]string{"type": "int"}},
+		}}},
+	}
+	in <- watch.Event{Type: watch.Added, Object: device}
+	if ev := <-out; ev.Object != device {
+		t.Fatalf("Tee did not pass the event through")
+	}
+	close(in)
+	if err := w.Write(Node, watch.Event{Type: watch.Deleted, Object: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-node"}}}); err != nil {
+		t.Fatalf("can not record node event: %v", err)
+	}
+
+	events := make(chan watch.Event, 1)
+	eve := make(chan watch.Event, 1)
+	if err := Replay(bytes.NewReader(buf.Bytes()), events, eve, 0, nil, nil); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	ev := <-events
+	got, ok := ev.Object.(*typ.Device)
+	if !ok || ev.Type != watch.Added || got.Name != "sensor" || got.Status.Twins[0].Actual.Value != "21" {
+		t.Errorf("unexpected device event: %+v", ev)
+	}
+	ev = <-eve
+	node, ok := ev.Object.(*v1.Node)
+	if !ok || ev.Type != watch.Deleted || node.Name != "edge-node" {
+		t.Errorf("unexpected node event: %+v", ev)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	start := time.Now()
+	recording := `{"time":"2019-07-01T10:00:00Z","resource":"node","type":"ADDED","object":{"metadata":{"name":"a"}}}
+{"time":"2019-07-01T10:00:10Z","resource":"node","type":"ADDED","object":{"metadata":{"name":"b"}}}
+`
+	eve := make(chan watch.Event, 2)
+	if err := Replay(strings.NewReader(recording), nil, eve, 100, nil, nil); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > 5*time.Second {
+		t.Errorf("replay of 10s at speed 100 took %v", d)
+	}
+}
+
+func TestReplayRejectsUnknownResource(t *testing.T) {
+	recording := `{"time":"2019-07-01T10:00:00Z","resource":"pod","type":"ADDED","object":{}}`
+	if err := Replay(strings.NewReader(recording), nil, nil, 0, nil, nil); err == nil {
+		t.Errorf("replay accepted an unknown resource")
+	}
+}
+
+func TestReplayClock(t *testing.T) {
+	recording := `{"time":"2019-07-01T10:00:00Z","resource":"node","type":"ADDED","object":{"metadata":{"name":"a"}}}
+{"time":"2019-07-01T10:00:10Z","resource":"node","type":"ADDED","object":{"metadata":{"name":"b"}}}
+`
+	clock := NewClock(0)
+	eve := make(chan watch.Event)
+	done := make(chan error)
+	go func() {
+		done <- Replay(strings.NewReader(recording), nil, eve, 0, clock, nil)
+	}()
+	for _, want := range []string{"2019-07-01T10:00:00Z", "2019-07-01T10:00:10Z"} {
+		ev := <-eve
+		if got := clock.EventTime(ev.Object).Format(time.RFC3339); got != want {
+			t.Errorf("got time %v, want the time of the entry %v", got, want)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	// the clock stays at the last entry after a replay without delays
+	if got := clock.Now().Format(time.RFC3339); got != "2019-07-01T10:00:10Z" {
+		t.Errorf("got time %v after the replay", got)
+	}
+}
@@ -0,0 +1,66 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The remote-write request is encoded by hand to avoid a dependency on the protobuf library:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func encodeLabel(l Label) []byte {
+	var b []byte
+	b = appendBytes(b, 1, []byte(l.Name))
+	return appendBytes(b, 2, []byte(l.Value))
+}
+
+func encodeSample(s Series) []byte {
+	b := appendTag(nil, 1, wireFixed64)
+	var f [8]byte
+	binary.LittleEndian.PutUint64(f[:], math.Float64bits(s.Value))
+	b = append(b, f[:]...)
+	b = appendTag(b, 2, wireVarint)
+	return appendVarint(b, uint64(s.Timestamp))
+}
+
+// encodeWriteRequest encodes series as protobuf WriteRequest
+func encodeWriteRequest(series []Series) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			ts = appendBytes(ts, 1, encodeLabel(l))
+		}
+		ts = appendBytes(ts, 2, encodeSample(s))
+		req = appendBytes(req, 1, ts)
+	}
+	return req
+}
@@ -0,0 +1,118 @@
+package remotewrite
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Label is a label of a series
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Series is one sample of a series; the labels contain the metric name as __name__ and are sorted by name
+type Series struct {
+	Labels    []Label
+	Value     float64
+	Timestamp int64
+}
+
+// Parse converts the samples of text, which is in the prometheus text format, into series with the timestamp ts in
+// milliseconds; comments are skipped and timestamps in text are ignored
+func Parse(text string, ts int64) ([]Series, error) {
+	var series []Series
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		s, err := parseLine(l)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		s.Timestamp = ts
+		series = append(series, s)
+	}
+	return series, scanner.Err()
+}
+
+func parseLine(l string) (Series, error) {
+	var s Series
+	end := strings.IndexAny(l, "{ \t")
+	if end <= 0 {
+		return s, fmt.Errorf("sample without value")
+	}
+	s.Labels = append(s.Labels, Label{Name: "__name__", Value: l[:end]})
+	rest := l[end:]
+
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, " ,")
+			if rest == "" {
+				return s, fmt.Errorf("unterminated label set")
+			}
+			if rest[0] == '}' {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.IndexByte(rest, '=')
+			if eq <= 0 || len(rest) < eq+2 || rest[eq+1] != '"' {
+				return s, fmt.Errorf("invalid label in %q", rest)
+			}
+			name := strings.TrimSpace(rest[:eq])
+			value, n, err := unquote(rest[eq+2:])
+			if err != nil {
+				return s, err
+			}
+			s.Labels = append(s.Labels, Label{Name: name, Value: value})
+			rest = rest[eq+2+n:]
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("sample without value")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value %q", fields[0])
+	}
+	s.Value = value
+	sort.Slice(s.Labels, func(i, j int) bool { return s.Labels[i].Name < s.Labels[j].Name })
+	return s, nil
+}
+
+// unquote reads a label value up to the closing quote; it returns the value and the number of bytes consumed
+// including the quote
+func unquote(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated label value")
+}
@@ -0,0 +1,283 @@
+// Package remotewrite pushes the metrics of the exporter to prometheus remote-write endpoints
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const (
+	defaultInterval  = 30 * time.Second
+	defaultTimeout   = 10 * time.Second
+	defaultQueueSize = 100
+	// minChangeInterval limits the pushes triggered by changes
+	minChangeInterval = time.Second
+	minBackoff        = 100 * time.Millisecond
+	maxBackoff        = 30 * time.Second
+)
+
+// Source renders the metrics in the prometheus text format, like prometheus.Exporter.Metrics
+type Source func() string
+
+// Pusher pushes the metrics of a Source to remote-write endpoints
+type Pusher struct {
+	source    Source
+	endpoints []*endpoint
+	now       func() time.Time
+}
+
+// request is a queued push
+type request struct {
+	seq  uint64
+	body []byte
+}
+
+// endpoint is one remote-write endpoint with its queue
+type endpoint struct {
+	conf       config.RemoteWrite
+	interval   time.Duration
+	queueSize  int
+	client     *http.Client
+	minBackoff time.Duration
+
+	mutex sync.Mutex
+	queue []request
+	seq   uint64
+	// wake is signalled when a request is queued
+	wake chan struct{}
+	// changed is signalled when the source has changed
+	changed chan struct{}
+}
+
+// New creates a Pusher for the endpoints confs; the configurations have to be validated by config.Config.Validate
+func New(source Source, confs []config.RemoteWrite) *Pusher {
+	p := &Pusher{source: source, now: time.Now}
+	for _, conf := range confs {
+		e := &endpoint{
+			conf:       conf,
+			interval:   conf.Interval.Duration,
+			queueSize:  conf.QueueSize,
+			client:     &http.Client{Timeout: conf.Timeout.Duration},
+			minBackoff: minBackoff,
+			wake:       make(chan struct{}, 1),
+			changed:    make(chan struct{}, 1),
+		}
+		if e.interval == 0 {
+			e.interval = defaultInterval
+		}
+		if e.queueSize == 0 {
+			e.queueSize = defaultQueueSize
+		}
+		if e.client.Timeout == 0 {
+			e.client.Timeout = defaultTimeout
+		}
+		p.endpoints = append(p.endpoints, e)
+	}
+	return p
+}
+
+// Run pushes to every endpoint periodically and, if enabled, after a value has been received on changes;
+// it returns when stop is closed. Queued pushes which have not been sent are lost.
+func (p *Pusher) Run(changes <-chan struct{}, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(2)
+		go func(e *endpoint) {
+			defer wg.Done()
+			p.collect(e, stop)
+		}(e)
+		go func(e *endpoint) {
+			defer wg.Done()
+			e.send(stop)
+		}(e)
+	}
+
+	for {
+		select {
+		case <-stop:
+			wg.Wait()
+			return
+		case <-changes:
+			for _, e := range p.endpoints {
+				if !e.conf.OnChange {
+					continue
+				}
+				select {
+				case e.changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// collect queues the metrics for e every interval and after changes
+func (p *Pusher) collect(e *endpoint, stop <-chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-e.changed:
+			if wait := minChangeInterval - p.now().Sub(last); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-stop:
+					return
+				}
+			}
+		}
+		last = p.now()
+		series, err := Parse(p.source(), last.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			log.Printf("can not parse the metrics for %s; err is: %v", e.conf.URL, err)
+			continue
+		}
+		e.enqueue(snappy.Encode(nil, encodeWriteRequest(withExternalLabels(series, e.conf.ExternalLabels))))
+	}
+}
+
+// withExternalLabels adds labels to every series which does not have a label of the same name
+func withExternalLabels(series []Series, labels map[string]string) []Series {
+	if len(labels) == 0 {
+		return series
+	}
+	for i := range series {
+		ls := series[i].Labels
+		for name, value := range labels {
+			if !hasLabel(series[i].Labels, name) {
+				ls = append(ls, Label{Name: name, Value: value})
+			}
+		}
+		sort.Slice(ls, func(a, b int) bool { return ls[a].Name < ls[b].Name })
+		series[i].Labels = ls
+	}
+	return series
+}
+
+func hasLabel(labels []Label, name string) bool {
+	for _, l := range labels {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue appends body to the queue; the oldest request is dropped if the queue is full
+func (e *endpoint) enqueue(body []byte) {
+	e.mutex.Lock()
+	if len(e.queue) >= e.queueSize {
+		log.Printf("queue of %s is full; drop the oldest push", e.conf.URL)
+		e.queue = e.queue[1:]
+	}
+	e.seq++
+	e.queue = append(e.queue, request{seq: e.seq, body: body})
+	e.mutex.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// head returns the oldest queued request
+func (e *endpoint) head() (request, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.queue) == 0 {
+		return request{}, false
+	}
+	return e.queue[0], true
+}
+
+// remove removes the request seq if it is still the oldest one; it may have been dropped in the meantime
+func (e *endpoint) remove(seq uint64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.queue) > 0 && e.queue[0].seq == seq {
+		e.queue = e.queue[1:]
+	}
+}
+
+// send sends the queued requests in order until stop is closed; failed requests are retried with an exponential
+// backoff unless the endpoint rejects them
+func (e *endpoint) send(stop <-chan struct{}) {
+	backoff := e.minBackoff
+	for {
+		req, ok := e.head()
+		if !ok {
+			select {
+			case <-e.wake:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		retry, err := e.post(req.body)
+		if err == nil || !retry {
+			if err != nil {
+				log.Printf("%s rejected the push, drop it; err is: %v", e.conf.URL, err)
+			}
+			e.remove(req.seq)
+			backoff = e.minBackoff
+			continue
+		}
+
+		log.Printf("can not push to %s, retry in %v; err is: %v", e.conf.URL, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post sends one request; retry reports whether a failed request may succeed later
+func (e *endpoint) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, e.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("User-Agent", "cpu-kubeedge-exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if e.conf.BasicAuth != nil {
+		req.SetBasicAuth(e.conf.BasicAuth.Username, e.conf.BasicAuth.Password)
+	}
+	if e.conf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+e.conf.BearerToken)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("server returned %v: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
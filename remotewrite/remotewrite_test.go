@@ -0,0 +1,302 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+)
+
+const metrics = `# TYPE cpu_kubeedge_exporter gauge
+cpu_kubeedge_exporter{sensorGroup="sensor-tag01",node="[[edge-node-1]]",sensor="temperature",type="actual",namespace="default"} 21
+cpu_kubeedge_exporter{sensorGroup="sensor-tag01",node="[[edge-node-1]]",sensor="temperature",type="expected",namespace="default"} 20.5
+# HELP kubeedge_device_invalid 1 if the device object has validation errors
+kubeedge_device_invalid{namespace="plant-a",device="broken"} 1
+go_goroutines 12
+`
+
+func TestParse(t *testing.T) {
+	series, err := Parse(metrics+`escaped{value="a \"b\" \\ c\n"} -Inf`, 1000)
+	if err != nil {
+		t.Fatalf("can not parse metrics: %v", err)
+	}
+	if len(series) != 5 {
+		t.Fatalf("got %v series, want 5", len(series))
+	}
+	want := Series{
+		Labels: []Label{
+			{"__name__", "cpu_kubeedge_exporter"},
+			{"namespace", "default"},
+			{"node", "[[edge-node-1]]"},
+			{"sensor", "temperature"},
+			{"sensorGroup", "sensor-tag01"},
+			{"type", "expected"},
+		},
+		Value:     20.5,
+		Timestamp: 1000,
+	}
+	if !reflect.DeepEqual(series[1], want) {
+		t.Errorf("got %+v, want %+v", series[1], want)
+	}
+	if got := series[4]; got.Labels[1].Value != "a \"b\" \\ c\n" || !math.IsInf(got.Value, -1) {
+		t.Errorf("escapes are not decoded: %+v", got)
+	}
+
+	for _, invalid := range []string{"novalue", `a{b="c"`, `a{b=c} 1`, "a NaN1"} {
+		if _, err := Parse(invalid, 0); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+}
+
+// receiver is a stand-in for a remote-write endpoint which decodes and stores the received requests
+type receiver struct {
+	mutex    sync.Mutex
+	requests [][]Series
+	headers  []http.Header
+	// status is returned for the next requests; it is consumed from the front, afterwards 204 is returned
+	status []int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.headers = append(r.headers, req.Header)
+	if len(r.status) > 0 {
+		status := r.status[0]
+		r.status = r.status[1:]
+		http.Error(w, "unavailable", status)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, series)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) received() ([][]Series, []http.Header) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests, r.headers
+}
+
+// wait waits until n requests have been received
+func (r *receiver) wait(t *testing.T, n int) [][]Series {
+	timeout := time.After(10 * time.Second)
+	for {
+		requests, _ := r.received()
+		if len(requests) >= n {
+			return requests
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("received %v requests, want %v", len(requests), n)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestPusher(t *testing.T) {
+	recv := &receiver{status: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	p := New(func() string { return metrics }, []config.RemoteWrite{{
+		URL:            server.URL,
+		Interval:       metav1.Duration{Duration: time.Hour},
+		OnChange:       true,
+		ExternalLabels: map[string]string{"site": "plant-a", "namespace": "ignored"},
+		BasicAuth:      &config.BasicAuth{Username: "edge", Password: "secret"},
+	}})
+	p.endpoints[0].minBackoff = time.Millisecond
+	changes := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.Run(changes, stop)
+
+	changes <- struct{}{}
+	requests := recv.wait(t, 1)
+	if len(requests[0]) != 4 {
+		t.Fatalf("got %v series, want 4", len(requests[0]))
+	}
+	first := requests[0][0]
+	wantLabels := []Label{
+		{"__name__", "cpu_kubeedge_exporter"},
+		{"namespace", "default"},
+		{"node", "[[edge-node-1]]"},
+		{"sensor", "temperature"},
+		{"sensorGroup", "sensor-tag01"},
+		{"site", "plant-a"},
+		{"type", "actual"},
+	}
+	if !reflect.DeepEqual(first.Labels, wantLabels) || first.Value != 21 || first.Timestamp == 0 {
+		t.Errorf("got %+v, want the labels %v and the value 21", first, wantLabels)
+	}
+
+	_, headers := recv.received()
+	if len(headers) != 3 {
+		t.Errorf("got %v attempts, want 2 retries", len(headers))
+	}
+	h := headers[len(headers)-1]
+	if h.Get("Content-Encoding") != "snappy" || h.Get("Content-Type") != "application/x-protobuf" || h.Get("X-Prometheus-Remote-Write-Version") == "" {
+		t.Errorf("unexpected headers %v", h)
+	}
+	if user, pass, ok := (&http.Request{Header: h}).BasicAuth(); !ok || user != "edge" || pass != "secret" {
+		t.Errorf("basic auth is missing: %v", h.Get("Authorization"))
+	}
+}
+
+func TestPusherDropsRejectedRequests(t *testing.T) {
+	recv := &receiver{status: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	p := New(func() string { return metrics }, []config.RemoteWrite{{URL: server.URL, BearerToken: "token"}})
+	e := p.endpoints[0]
+	e.enqueue([]byte("rejected"))
+	e.enqueue(snappy.Encode(nil, encodeWriteRequest(nil)))
+	stop := make(chan struct{})
+	defer close(stop)
+	go e.send(stop)
+
+	recv.wait(t, 1)
+	_, headers := recv.received()
+	if len(headers) != 2 || headers[0].Get("Authorization") != "Bearer token" {
+		t.Errorf("got %v requests with the headers %v", len(headers), headers)
+	}
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	e := New(nil, []config.RemoteWrite{{URL: "http://localhost", QueueSize: 2}}).endpoints[0]
+	for i := 0; i < 3; i++ {
+		e.enqueue([]byte{byte(i)})
+	}
+	if len(e.queue) != 2 || e.queue[0].body[0] != 1 || e.queue[1].body[0] != 2 {
+		t.Errorf("unexpected queue %v", e.queue)
+	}
+	e.remove(1)
+	if len(e.queue) != 2 {
+		t.Errorf("a request which was already dropped removed the head")
+	}
+}
+
+// decodeWriteRequest decodes the output of snappy.Encode(nil, encodeWriteRequest(series))
+func decodeWriteRequest(body []byte) ([]Series, error) {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	var series []Series
+	err = decodeFields(data, func(field int, v uint64, b []byte) error {
+		if field != 1 {
+			return fmt.Errorf("unexpected field %v in WriteRequest", field)
+		}
+		var s Series
+		err := decodeFields(b, func(field int, v uint64, b []byte) error {
+			switch field {
+			case 1:
+				var l Label
+				err := decodeFields(b, func(field int, v uint64, b []byte) error {
+					if field == 1 {
+						l.Name = string(b)
+					} else {
+						l.Value = string(b)
+					}
+					return nil
+				})
+				s.Labels = append(s.Labels, l)
+				return err
+			case 2:
+				return decodeFields(b, func(field int, v uint64, b []byte) error {
+					if field == 1 {
+						s.Value = math.Float64frombits(v)
+					} else {
+						s.Timestamp = int64(v)
+					}
+					return nil
+				})
+			}
+			return fmt.Errorf("unexpected field %v in TimeSeries", field)
+		})
+		series = append(series, s)
+		return err
+	})
+	return series, err
+}
+
+// decodeFields calls fn for every field of the protobuf message b
+func decodeFields(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid tag")
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch tag & 7 {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("invalid varint")
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("truncated fixed64")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return fmt.Errorf("truncated bytes")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("unexpected wire type %v", tag&7)
+		}
+		if err := fn(int(tag>>3), v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWriteRequestIsCompressed(t *testing.T) {
+	var series []Series
+	for i := 0; i < 100; i++ {
+		series = append(series, Series{
+			Labels: []Label{{Name: "__name__", Value: "cpu_kubeedge_exporter"}, {Name: "namespace", Value: "plant-a"}, {Name: "sensor", Value: fmt.Sprint("temperature", i)}},
+			Value:  float64(i), Timestamp: 1000,
+		})
+	}
+	req := encodeWriteRequest(series)
+	body := snappy.Encode(nil, req)
+	if len(body) >= len(req)/2 {
+		t.Errorf("got %v bytes for a request of %v bytes, want the repeated labels to be compressed", len(body), len(req))
+	}
+	got, err := decodeWriteRequest(body)
+	if err != nil || !reflect.DeepEqual(got, series) {
+		t.Errorf("round trip failed: %v", err)
+	}
+}
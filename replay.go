@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/record"
+)
+
+type replayCommand struct {
+	File   string  `long:"file" required:"yes" description:"recording written with --record"`
+	Speed  float64 `long:"speed" default:"1" description:"speed factor of the replay; 0 replays without delays"`
+	Output string  `long:"output" required:"no" description:"write the metrics derived from the devices to this file after the replay"`
+	Self   bool    `long:"self" description:"include the metrics about the exporter process in the output"`
+}
+
+// Execute replays a recording into an exporter; the exporter is served if a listen address is configured
+func (c *replayCommand) Execute(args []string) error {
+	f, err := os.Open(c.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conf, confErr := loadConfig()
+	if confErr != nil && c.Output == "" {
+		return fmt.Errorf("neither a valid listen address nor an output file is given: %v", confErr)
+	}
+
+	// the exporter gets the time of the recording, so the history, thresholds, convergence and statistics do not
+	// depend on the speed of the replay
+	clock := record.NewClock(c.Speed)
+	exporter := prometheus.NewExporter(
+		prometheus.WithClock(clock),
+		prometheus.WithRender(conf.Render),
+		prometheus.WithHistory(conf.History),
+		prometheus.WithThresholds(conf.Thresholds),
+		prometheus.WithConvergence(conf.Convergence),
+		prometheus.WithStatistics(conf.Statistics),
+	)
+	events := make(chan watch.Event)
+	ev := make(chan watch.Event)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(events, ev, stop)
+		close(done)
+	}()
+
+	serveErr := make(chan error, 1)
+	if confErr == nil {
+		go func() {
+			serveErr <- prometheus.ListenAndServe(conf, exporter)
+		}()
+	}
+
+	if err := record.Replay(f, events, ev, c.Speed, clock, nil); err != nil {
+		return fmt.Errorf("can not replay %s: %v", c.File, err)
+	}
+	close(stop)
+	<-done
+	log.Printf("replayed %s", c.File)
+
+	if c.Output != "" {
+		metrics := exporter.DeviceMetrics()
+		if c.Self {
+			metrics = exporter.Metrics()
+		}
+		if err := ioutil.WriteFile(c.Output, []byte(metrics), 0644); err != nil {
+			return err
+		}
+	}
+	if confErr == nil {
+		return <-serveErr
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/kubernetes"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/rules"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+type generateRulesCommand struct {
+	Files         []string      `long:"file" description:"YAML file with Devices and DeviceModels; may be repeated; the devices and models of the cluster are used if no file is given"`
+	Namespace     string        `long:"namespace" description:"namespace of the devices and models read from the cluster; all namespaces if empty"`
+	Format        string        `long:"format" default:"prometheusrule" choice:"prometheusrule" choice:"rules" description:"write a PrometheusRule manifest or a plain rule file"`
+	Name          string        `long:"name" default:"kubeedge-devices" description:"name of the PrometheusRule"`
+	RuleNamespace string        `long:"rule-namespace" default:"monitoring" description:"namespace of the PrometheusRule"`
+	RuleLabels    []string      `long:"rule-label" description:"label key=value of the PrometheusRule, e.g. for the ruleSelector of prometheus; may be repeated"`
+	For           time.Duration `long:"for" default:"5m" description:"time an out-of-range value or a divergence has to last before the alert fires"`
+	Stale         time.Duration `long:"stale" default:"30m" description:"time after which an unchanged reported value is stale; 0 disables the stale alerts"`
+	Tolerance     float64       `long:"tolerance" default:"0" description:"difference between the reported and the desired value which is not a divergence"`
+	Severity      string        `long:"severity" default:"warning" description:"severity label of the alerts"`
+	Output        string        `long:"output" description:"file the rules are written to; stdout if empty"`
+}
+
+// Execute generates the alerting rules for the devices of the files or of the cluster
+func (c *generateRulesCommand) Execute(args []string) error {
+	devices, models, err := c.load()
+	if err != nil {
+		return err
+	}
+	ruleOpts := rules.Options{For: c.For, Stale: c.Stale, Tolerance: c.Tolerance, Severity: c.Severity}
+	if opts.Config != "" {
+		// the rules select the series like they are rendered by the exporter
+		conf, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		ruleOpts.Renderer = prometheus.NewRenderer(conf.Render)
+	}
+	ruleFile := rules.Generate(devices, models, ruleOpts)
+
+	var out interface{} = ruleFile
+	if c.Format == "prometheusrule" {
+		labels := make(map[string]string, len(c.RuleLabels))
+		for _, label := range c.RuleLabels {
+			parts := strings.SplitN(label, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return fmt.Errorf("rule label %q is not of the form key=value", label)
+			}
+			labels[parts[0]] = parts[1]
+		}
+		out = rules.NewPrometheusRule(c.Name, c.RuleNamespace, labels, ruleFile)
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if c.Output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(c.Output, data, 0644)
+}
+
+// load reads the devices and models of the files, or of the cluster if no file is given
+func (c *generateRulesCommand) load() ([]typ.Device, []typ.DeviceModel, error) {
+	if len(c.Files) == 0 {
+		conf, err := readConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		watcher, err := kubernetes.NewWatcher(nil, nil, kubernetes.WithServer(conf.Server), kubernetes.WithKubeConfig(conf.KubeConfig))
+		if err != nil {
+			return nil, nil, err
+		}
+		devices, err := watcher.ListDevices(c.Namespace)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can not list the devices: %v", err)
+		}
+		models, err := watcher.ListDeviceModels(c.Namespace)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can not list the device models: %v", err)
+		}
+		return devices.Items, models.Items, nil
+	}
+
+	var devices []typ.Device
+	var models []typ.DeviceModel
+	for _, path := range c.Files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, m, err := rules.Decode(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can not decode %s: %v", path, err)
+		}
+		devices = append(devices, d...)
+		models = append(models, m...)
+	}
+	return devices, models, nil
+}
@@ -0,0 +1,397 @@
+// Package rules generates prometheus alerting rules for the twins of devices from the ranges of their DeviceModels
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// Options controls the generated alerts
+type Options struct {
+	// For is the time an out-of-range value or a divergence has to last before the alert fires
+	For time.Duration
+	// Stale is the time after which an unchanged reported value is stale; no stale alerts are generated if it is 0
+	Stale time.Duration
+	// Tolerance is the difference between the reported and the desired value which is not a divergence
+	Tolerance float64
+	// Severity is the severity label of the alerts
+	Severity string
+	// Renderer renders the series like the render config of the exporter, so the rules select the transformed and
+	// relabeled series; the series of cpu_kubeedge_exporter are used if it is nil
+	Renderer *prometheus.Renderer
+}
+
+// Rule is an alerting rule in the format of the prometheus rule files
+type Rule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Group is a rule group; Generate creates one group per device
+type Group struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// RuleFile is the content of a prometheus rule file and the spec of a PrometheusRule
+type RuleFile struct {
+	Groups []Group `json:"groups"`
+}
+
+type metadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// PrometheusRule is the custom resource of the prometheus operator
+type PrometheusRule struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   metadata `json:"metadata"`
+	Spec       RuleFile `json:"spec"`
+}
+
+// NewPrometheusRule wraps rules into a PrometheusRule called name in namespace
+func NewPrometheusRule(name string, namespace string, labels map[string]string, rules RuleFile) PrometheusRule {
+	return PrometheusRule{
+		APIVersion: "monitoring.coreos.com/v1",
+		Kind:       "PrometheusRule",
+		Metadata:   metadata{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       rules,
+	}
+}
+
+// property is a numeric twin property of a device
+type property struct {
+	name string
+	// model is the definition of the property in the DeviceModel; it is nil if there is none
+	model *typ.PropertyTypeInt64
+	// desired reports whether the property can have a desired value
+	desired bool
+}
+
+// Generate returns out-of-range, stale-value and divergence alerts for the numeric properties of devices; the ranges
+// and access modes are taken from the DeviceModels in models, which are matched by name and namespace
+func Generate(devices []typ.Device, models []typ.DeviceModel, opts Options) RuleFile {
+	if opts.Renderer == nil {
+		opts.Renderer = prometheus.NewRenderer(config.Render{})
+	}
+	byName := make(map[string]*typ.DeviceModel, len(models))
+	for i := range models {
+		byName[models[i].Namespace+"/"+models[i].Name] = &models[i]
+	}
+	sorted := make([]typ.Device, len(devices))
+	copy(sorted, devices)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	ret := RuleFile{Groups: []Group{}}
+	for _, device := range sorted {
+		var model *typ.DeviceModel
+		if device.Spec.DeviceModelRef != nil {
+			model = byName[device.Namespace+"/"+device.Spec.DeviceModelRef.Name]
+		}
+		var rules []Rule
+		for _, prop := range properties(device, model) {
+			rules = append(rules, propertyRules(device, prop, opts)...)
+		}
+		if len(rules) > 0 {
+			ret.Groups = append(ret.Groups, Group{Name: "kubeedge-device-" + device.Namespace + "-" + device.Name, Rules: rules})
+		}
+	}
+	return ret
+}
+
+// properties returns the numeric properties of the model and the twins of device, sorted by name
+func properties(device typ.Device, model *typ.DeviceModel) []property {
+	props := make(map[string]*property)
+	skip := make(map[string]bool)
+	if model != nil {
+		for _, p := range model.Spec.Properties {
+			if p.Type.Int == nil {
+				skip[p.Name] = true
+				continue
+			}
+			props[p.Name] = &property{name: p.Name, model: p.Type.Int, desired: p.Type.Int.AccessMode != typ.ReadOnly}
+		}
+	}
+	for _, twin := range device.Status.Twins {
+		if twin.Name == "" || skip[twin.Name] {
+			continue
+		}
+		p, ok := props[twin.Name]
+		if !ok {
+			if !numeric(twin.Actual) {
+				continue
+			}
+			p = &property{name: twin.Name}
+			props[twin.Name] = p
+		}
+		if twin.Desired.Value != "" {
+			p.desired = true
+		}
+	}
+
+	ret := make([]property, 0, len(props))
+	for _, p := range props {
+		ret = append(ret, *p)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+// numeric reports whether the exporter renders v as a sample
+func numeric(v typ.TwinValue) bool {
+	switch v.Metadata["type"] {
+	case "string":
+		return false
+	case "boolean", "bool":
+		return true
+	}
+	_, err := strconv.ParseFloat(v.Value, 64)
+	return err == nil
+}
+
+// propertyRules returns the alerts of the twin prop of device; the range of the model is transformed like the values
+// of the series, the tolerance is taken as it is
+func propertyRules(device typ.Device, prop property, opts Options) []Rule {
+	actual, ok := selector(opts.Renderer, device, prop.name, "actual")
+	if !ok {
+		return nil
+	}
+	labels := map[string]string{}
+	if opts.Severity != "" {
+		labels["severity"] = opts.Severity
+	}
+	var unit string
+	if prop.model != nil {
+		unit = prop.model.Unit
+	}
+	if unit = opts.Renderer.Unit(&device, prop.name, unit); unit != "" {
+		unit = " " + unit
+	}
+
+	var rules []Rule
+	if prop.model != nil && prop.model.Maximum > prop.model.Minimum {
+		min := opts.Renderer.Value(&device, prop.name, float64(prop.model.Minimum))
+		max := opts.Renderer.Value(&device, prop.name, float64(prop.model.Maximum))
+		if min > max {
+			// a negative scale swaps the bounds
+			min, max = max, min
+		}
+		rules = append(rules, Rule{
+			Alert:  "KubeEdgeTwinOutOfRange",
+			Expr:   fmt.Sprintf("%s < %s or %s > %s", actual, number(min), actual, number(max)),
+			For:    duration(opts.For),
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("%s of %s/%s is out of range", prop.name, device.Namespace, device.Name),
+				"description": fmt.Sprintf("%s of %s/%s reports {{ $value }}%s, outside of the range %s to %s of its model.",
+					prop.name, device.Namespace, device.Name, unit, number(min), number(max)),
+			},
+		})
+	}
+	if opts.Stale > 0 {
+		rules = append(rules, Rule{
+			Alert:  "KubeEdgeTwinStale",
+			Expr:   fmt.Sprintf("changes(%s[%s]) == 0", actual, duration(opts.Stale)),
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("%s of %s/%s is stale", prop.name, device.Namespace, device.Name),
+				"description": fmt.Sprintf("%s of %s/%s has not changed for %s.", prop.name, device.Namespace, device.Name, duration(opts.Stale)),
+			},
+		})
+	}
+	if expected, ok := selector(opts.Renderer, device, prop.name, "expected"); prop.desired && ok {
+		match := ""
+		if ignoring := actual.ignoring(expected); len(ignoring) > 0 {
+			match = "ignoring(" + strings.Join(ignoring, ", ") + ") "
+		}
+		rules = append(rules, Rule{
+			Alert:  "KubeEdgeTwinDiverged",
+			Expr:   fmt.Sprintf("abs(%s - %s%s) > %s", actual, match, expected, number(opts.Tolerance)),
+			For:    duration(opts.For),
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("%s of %s/%s does not reach its desired value", prop.name, device.Namespace, device.Name),
+				"description": fmt.Sprintf("The reported value of %s of %s/%s differs by {{ $value }}%s from the desired value.", prop.name, device.Namespace, device.Name, unit),
+			},
+		})
+	}
+	return rules
+}
+
+// series selects a series of a twin
+type series struct {
+	name   string
+	labels map[string]string
+}
+
+// twinLabels are the labels which come first in a selector, in the order of the series on /metrics
+var twinLabels = []string{"namespace", "sensorGroup", "sensor", "type"}
+
+// selector selects the series of the twin prop of device with the type kind, actual or expected, as rendered by r; ok
+// is false if the series is not exported
+func selector(r *prometheus.Renderer, device typ.Device, prop string, kind string) (series, bool) {
+	name, labels, ok := r.Series(&device, prop, kind)
+	return series{name: name, labels: labels}, ok
+}
+
+func (s series) String() string {
+	names := make([]string, 0, len(s.labels))
+	for _, name := range twinLabels {
+		if _, ok := s.labels[name]; ok {
+			names = append(names, name)
+		}
+	}
+	var others []string
+	for name := range s.labels {
+		if !contains(twinLabels, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	names = append(names, others...)
+
+	matchers := make([]string, len(names))
+	for i, name := range names {
+		matchers[i] = name + "=" + strconv.Quote(s.labels[name])
+	}
+	return s.name + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// ignoring returns the sorted names of the labels which differ between s and o
+func (s series) ignoring(o series) []string {
+	var ret []string
+	for name, value := range s.labels {
+		if v, ok := o.labels[name]; !ok || v != value {
+			ret = append(ret, name)
+		}
+	}
+	for name := range o.labels {
+		if _, ok := s.labels[name]; !ok {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// number formats f for an expression or a description
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// duration formats d in the largest unit of the prometheus duration format which represents it exactly
+func duration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	for _, unit := range []struct {
+		suffix string
+		d      time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}} {
+		if d%unit.d == 0 {
+			return strconv.FormatInt(int64(d/unit.d), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
+
+// Decode reads the Devices and DeviceModels of a YAML file; it may contain several documents with single objects or
+// lists. Other kinds are skipped.
+func Decode(data []byte) ([]typ.Device, []typ.DeviceModel, error) {
+	var devices []typ.Device
+	var models []typ.DeviceModel
+	for i, doc := range splitDocuments(data) {
+		var header struct {
+			Kind string `json:"kind"`
+		}
+		if err := yaml.Unmarshal(doc, &header); err != nil {
+			return nil, nil, fmt.Errorf("document %v: %v", i+1, err)
+		}
+		var err error
+		switch header.Kind {
+		case "Device":
+			var device typ.Device
+			err = yaml.Unmarshal(doc, &device)
+			devices = append(devices, device)
+		case "DeviceList":
+			var list typ.DeviceList
+			err = yaml.Unmarshal(doc, &list)
+			devices = append(devices, list.Items...)
+		case "DeviceModel":
+			var model typ.DeviceModel
+			err = yaml.Unmarshal(doc, &model)
+			models = append(models, model)
+		case "DeviceModelList":
+			var list typ.DeviceModelList
+			err = yaml.Unmarshal(doc, &list)
+			models = append(models, list.Items...)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("document %v: %v", i+1, err)
+		}
+	}
+	for i := range devices {
+		if devices[i].Namespace == "" {
+			devices[i].Namespace = "default"
+		}
+	}
+	for i := range models {
+		if models[i].Namespace == "" {
+			models[i].Namespace = "default"
+		}
+	}
+	return devices, models, nil
+}
+
+// splitDocuments splits a YAML stream at the --- separators; empty documents are dropped
+func splitDocuments(data []byte) [][]byte {
+	var ret [][]byte
+	var doc []byte
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if trimmed := strings.TrimRight(line, " \r\n"); trimmed == "---" || strings.HasPrefix(trimmed, "--- ") {
+			ret = append(ret, doc)
+			doc = nil
+			continue
+		}
+		doc = append(doc, line...)
+	}
+	ret = append(ret, doc)
+
+	docs := ret[:0]
+	for _, doc := range ret {
+		if len(bytes.TrimSpace(doc)) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
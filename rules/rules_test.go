@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/config"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestGoldenRules(t *testing.T) {
+	var data []byte
+	for _, path := range []string{"../testdata/devices.yaml", "../testdata/devicemodels.yaml"} {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("can not read %s: %v", path, err)
+		}
+		data = append(append(data, "---\n"...), content...)
+	}
+	devices, models, err := Decode(data)
+	if err != nil {
+		t.Fatalf("can not decode the fixtures: %v", err)
+	}
+	if len(devices) != 3 || len(models) != 2 {
+		t.Fatalf("got %v devices and %v models, want 3 and 2", len(devices), len(models))
+	}
+
+	rule := NewPrometheusRule("kubeedge-devices", "monitoring", map[string]string{"role": "alert-rules"},
+		Generate(devices, models, Options{For: 5 * time.Minute, Stale: time.Hour, Tolerance: 0.5, Severity: "warning"}))
+	got, err := yaml.Marshal(rule)
+	if err != nil {
+		t.Fatalf("can not encode the rules: %v", err)
+	}
+
+	path := "../testdata/rules.golden"
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("can not update %s: %v", path, err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("can not read %s: %v", path, err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("output differs from %s; run go test -update to accept it\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestGenerateRender(t *testing.T) {
+	scale := -1.0
+	renderer := prometheus.NewRenderer(config.Render{
+		Transforms: []config.Transform{{Model: "sensor", Property: "temperature", Scale: &scale, Metric: "cooling"}},
+		RelabelConfigs: []config.RelabelConfig{
+			{SourceLabels: []string{"sensor"}, TargetLabel: "property"},
+			{Regex: "sensor|node", Action: "labeldrop"},
+		},
+	})
+	device := typ.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"},
+		Spec:       typ.DeviceSpec{DeviceModelRef: &v1.LocalObjectReference{Name: "sensor"}},
+		Status:     typ.DeviceStatus{Twins: []typ.Twin{{Name: "temperature", Actual: typ.TwinValue{Value: "20"}, Desired: typ.TwinValue{Value: "20"}}}},
+	}
+	model := typ.DeviceModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sensor", Namespace: "default"},
+		Spec: typ.DeviceModelSpec{Properties: []typ.DeviceProperty{
+			{Name: "temperature", Type: typ.PropertyType{Int: &typ.PropertyTypeInt64{AccessMode: typ.ReadWrite, Minimum: -20, Maximum: 60}}},
+		}},
+	}
+
+	ruleFile := Generate([]typ.Device{device}, []typ.DeviceModel{model}, Options{Renderer: renderer})
+	actual := `cooling{namespace="default",sensorGroup="a",type="actual",property="temperature"}`
+	want := []string{
+		actual + " < -60 or " + actual + " > 20",
+		`abs(` + actual + ` - ignoring(type) cooling{namespace="default",sensorGroup="a",type="expected",property="temperature"}) > 0`,
+	}
+	if len(ruleFile.Groups) != 1 || len(ruleFile.Groups[0].Rules) != len(want) {
+		t.Fatalf("got %+v", ruleFile)
+	}
+	for i, rule := range ruleFile.Groups[0].Rules {
+		if rule.Expr != want[i] {
+			t.Errorf("got %s, want %s", rule.Expr, want[i])
+		}
+	}
+}
+
+func TestDecodeDocuments(t *testing.T) {
+	data := []byte(`apiVersion: devices.kubeedge.io/v1alpha1
+kind: Device
+metadata:
+  name: a
+--- # second document
+kind: ConfigMap
+---
+kind: DeviceModel
+metadata:
+  name: m
+  namespace: plant-a
+`)
+	devices, models, err := Decode(data)
+	if err != nil || len(devices) != 1 || devices[0].Namespace != "default" || len(models) != 1 || models[0].Namespace != "plant-a" {
+		t.Errorf("got %+v %+v, %v", devices, models, err)
+	}
+	if _, _, err := Decode([]byte("kind: Device\nmetadata: [")); err == nil {
+		t.Errorf("invalid YAML was accepted")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{0: "", 90 * time.Second: "90s", time.Hour: "1h", 48 * time.Hour: "2d", 1500 * time.Millisecond: "1500ms"} {
+		if got := duration(d); got != want {
+			t.Errorf("duration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
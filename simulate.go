@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/kubernetes"
+	"github.com/subpathdev/cpu-kubeedge-exporter/prometheus"
+	"github.com/subpathdev/cpu-kubeedge-exporter/simulate"
+)
+
+type simulateCommand struct {
+	Devices       int           `long:"devices" default:"10" description:"number of simulated devices"`
+	Nodes         int           `long:"nodes" default:"2" description:"number of simulated nodes"`
+	Namespace     string        `long:"namespace" default:"default" description:"namespace of the simulated devices"`
+	Interval      time.Duration `long:"interval" default:"5s" description:"time between two updates of the devices"`
+	Numeric       int           `long:"numeric" default:"2" description:"numeric twins per device"`
+	Boolean       int           `long:"boolean" default:"1" description:"boolean twins per device"`
+	String        int           `long:"string" default:"1" description:"string twins per device"`
+	Patterns      []string      `long:"pattern" description:"pattern of the numeric twins (noise, sine or step); may be repeated"`
+	DesiredChange float64       `long:"desired-change" default:"0.01" description:"probability per update that a desired value changes"`
+	NodeChurn     float64       `long:"node-churn" default:"0" description:"probability per update that a node disappears for one update"`
+	Seed          int64         `long:"seed" default:"1" description:"seed of the random number generator"`
+	Apply         bool          `long:"apply" description:"also write the simulated devices as Device objects into the cluster"`
+}
+
+// Execute serves an exporter which is fed by synthetic devices and nodes
+func (c *simulateCommand) Execute(args []string) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	sim, err := simulate.New(simulate.Options{
+		Devices:       c.Devices,
+		Nodes:         c.Nodes,
+		Namespace:     c.Namespace,
+		Interval:      c.Interval,
+		Numeric:       c.Numeric,
+		Boolean:       c.Boolean,
+		String:        c.String,
+		Patterns:      c.Patterns,
+		DesiredChange: c.DesiredChange,
+		NodeChurn:     c.NodeChurn,
+		Seed:          c.Seed,
+	})
+	if err != nil {
+		return err
+	}
+
+	var applier simulate.Applier
+	if c.Apply {
+		watcher, err := kubernetes.NewWatcher(nil, nil, kubernetes.WithServer(conf.Server), kubernetes.WithKubeConfig(conf.KubeConfig))
+		if err != nil {
+			return err
+		}
+		applier = watcher
+	}
+
+	exporter := prometheus.NewExporter(prometheus.WithRender(conf.Render))
+	events := make(chan watch.Event)
+	ev := make(chan watch.Event)
+	stop := make(chan struct{})
+	go exporter.Run(events, ev, stop)
+	go sim.Run(events, ev, applier, stop)
+
+	return prometheus.ListenAndServe(conf, exporter)
+}
@@ -0,0 +1,270 @@
+// Package simulate generates synthetic devices and nodes for load tests of dashboards and alerts
+package simulate
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+// Patterns are the supported patterns of numeric twins
+var Patterns = []string{"noise", "sine", "step"}
+
+var states = []string{"running", "idle", "maintenance", "error"}
+
+// Options configures a Simulator
+type Options struct {
+	// Devices is the number of simulated devices
+	Devices int
+	// Nodes is the number of simulated nodes; the devices are distributed round robin
+	Nodes int
+	// Namespace is the namespace of the simulated devices
+	Namespace string
+	// Interval is the time between two updates of all devices
+	Interval time.Duration
+	// Numeric, Boolean and String are the number of twins of each type per device
+	Numeric, Boolean, String int
+	// Patterns are the patterns of the numeric twins; they are assigned round robin
+	Patterns []string
+	// DesiredChange is the probability per update that the desired value of a twin changes
+	DesiredChange float64
+	// NodeChurn is the probability per update that a node disappears; it reappears with the next update
+	NodeChurn float64
+	// Seed initialises the random number generator
+	Seed int64
+}
+
+// Applier writes devices into a cluster
+type Applier interface {
+	ApplyDevice(device *typ.Device) error
+}
+
+// twinState is the state of one simulated twin
+type twinState struct {
+	name    string
+	kind    string
+	pattern string
+	desired float64
+	phase   float64
+}
+
+// Simulator generates the events of synthetic devices and nodes
+type Simulator struct {
+	opts    Options
+	rand    *rand.Rand
+	twins   []twinState
+	devices []*typ.Device
+	removed string
+	tick    int
+}
+
+// New creates a Simulator; it returns an error if opts are invalid
+func New(opts Options) (*Simulator, error) {
+	if opts.Devices < 1 || opts.Nodes < 1 {
+		return nil, fmt.Errorf("at least one device and one node have to be simulated")
+	}
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("the interval has to be positive")
+	}
+	if len(opts.Patterns) == 0 {
+		opts.Patterns = Patterns
+	}
+	for _, p := range opts.Patterns {
+		if !contains(Patterns, p) {
+			return nil, fmt.Errorf("unknown pattern %q; supported are %v", p, Patterns)
+		}
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "default"
+	}
+
+	s := &Simulator{opts: opts, rand: rand.New(rand.NewSource(opts.Seed))}
+	for i := 0; i < opts.Numeric; i++ {
+		s.twins = append(s.twins, twinState{
+			name:    fmt.Sprintf("value%d", i),
+			kind:    "float",
+			pattern: opts.Patterns[i%len(opts.Patterns)],
+			desired: float64(10 * (i + 1)),
+			phase:   s.rand.Float64() * 2 * math.Pi,
+		})
+	}
+	for i := 0; i < opts.Boolean; i++ {
+		s.twins = append(s.twins, twinState{name: fmt.Sprintf("switch%d", i), kind: "boolean"})
+	}
+	for i := 0; i < opts.String; i++ {
+		s.twins = append(s.twins, twinState{name: fmt.Sprintf("state%d", i), kind: "string"})
+	}
+
+	for i := 0; i < opts.Devices; i++ {
+		s.devices = append(s.devices, s.newDevice(i))
+	}
+	return s, nil
+}
+
+func (s *Simulator) nodeName(i int) string {
+	return fmt.Sprintf("sim-node-%d", i%s.opts.Nodes)
+}
+
+func (s *Simulator) newDevice(i int) *typ.Device {
+	dev := &typ.Device{
+		TypeMeta: metav1.TypeMeta{APIVersion: "devices.kubeedge.io/v1alpha1", Kind: "Device"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("sim-device-%04d", i),
+			Namespace: s.opts.Namespace,
+			Labels:    map[string]string{"simulator": "true"},
+		},
+		Spec: typ.DeviceSpec{
+			DeviceModelRef: &v1.LocalObjectReference{Name: "sim-model"},
+			Protocol: typ.ProtocolConfig{
+				Modbus: &typ.ProtocolConfigModbus{TCP: &typ.ProtocolConfigModbusTCP{IP: "127.0.0.1", Port: 502, SlaveID: strconv.Itoa(i)}},
+			},
+			NodeSelector: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{
+						Operator: v1.NodeSelectorOpIn,
+						Values:   []string{s.nodeName(i)},
+					}},
+				}},
+			},
+		},
+	}
+	for _, twin := range s.twins {
+		t := typ.Twin{
+			Name:   twin.name,
+			Actual: typ.TwinValue{Metadata: map[string]string{"type": twin.kind}},
+		}
+		if twin.kind == "float" {
+			t.Desired = typ.TwinValue{Metadata: map[string]string{"type": twin.kind}, Value: formatFloat(twin.desired)}
+		}
+		dev.Status.Twins = append(dev.Status.Twins, t)
+	}
+	return dev
+}
+
+// update advances every twin of the n-th device dev by one step
+func (s *Simulator) update(n int, dev *typ.Device) {
+	for i := range dev.Status.Twins {
+		twin := &dev.Status.Twins[i]
+		state := s.twins[i]
+		switch state.kind {
+		case "float":
+			desired, _ := strconv.ParseFloat(twin.Desired.Value, 64)
+			if s.rand.Float64() < s.opts.DesiredChange {
+				desired = state.desired * (0.5 + s.rand.Float64())
+				twin.Desired.Value = formatFloat(desired)
+			}
+			twin.Actual.Value = formatFloat(s.numeric(state, float64(n), desired))
+		case "boolean":
+			if twin.Actual.Value == "" || s.rand.Float64() < 0.1 {
+				twin.Actual.Value = strconv.FormatBool(twin.Actual.Value != "true")
+			}
+		case "string":
+			if twin.Actual.Value == "" || s.rand.Float64() < 0.05 {
+				twin.Actual.Value = states[s.rand.Intn(len(states))]
+			}
+		}
+	}
+}
+
+// numeric returns the next value of a numeric twin which follows desired; offset shifts the phase of the pattern
+func (s *Simulator) numeric(state twinState, offset float64, desired float64) float64 {
+	amplitude := math.Max(math.Abs(desired)*0.1, 1)
+	switch state.pattern {
+	case "sine":
+		return desired + amplitude*math.Sin(state.phase+offset+2*math.Pi*float64(s.tick)/60)
+	case "step":
+		if ((s.tick+int(offset))/10)%2 == 1 {
+			return desired + amplitude
+		}
+		return desired
+	default:
+		return desired + s.rand.NormFloat64()*amplitude/3
+	}
+}
+
+func node(name string) *v1.Node {
+	return &v1.Node{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"simulator": "true"}},
+	}
+}
+
+// Run sends the synthetic devices on events and the nodes on eve every interval until stop is closed;
+// if applier is not nil every device is also written into the cluster
+func (s *Simulator) Run(events chan<- watch.Event, eve chan<- watch.Event, applier Applier, stop <-chan struct{}) {
+	send := func(ch chan<- watch.Event, ev watch.Event) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	for i := 0; i < s.opts.Nodes; i++ {
+		if !send(eve, watch.Event{Type: watch.Added, Object: node(s.nodeName(i))}) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		evType := watch.Modified
+		if s.tick == 0 {
+			evType = watch.Added
+		}
+		for n, dev := range s.devices {
+			s.update(n, dev)
+			if applier != nil {
+				if err := applier.ApplyDevice(dev.DeepCopy()); err != nil {
+					log.Printf("can not apply device %s; err is: %v", dev.Name, err)
+				}
+			}
+			if !send(events, watch.Event{Type: evType, Object: dev.DeepCopy()}) {
+				return
+			}
+		}
+
+		if s.removed != "" {
+			if !send(eve, watch.Event{Type: watch.Added, Object: node(s.removed)}) {
+				return
+			}
+			s.removed = ""
+		} else if s.rand.Float64() < s.opts.NodeChurn {
+			s.removed = s.nodeName(s.rand.Intn(s.opts.Nodes))
+			if !send(eve, watch.Event{Type: watch.Deleted, Object: node(s.removed)}) {
+				return
+			}
+		}
+		s.tick++
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package simulate
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/subpathdev/cpu-kubeedge-exporter/typ"
+)
+
+type countingApplier struct {
+	applied int
+}
+
+func (c *countingApplier) ApplyDevice(device *typ.Device) error {
+	c.applied++
+	return nil
+}
+
+func TestSimulatorEvents(t *testing.T) {
+	sim, err := New(Options{Devices: 3, Nodes: 2, Interval: time.Millisecond, Numeric: 3, Boolean: 1, String: 1, NodeChurn: 1})
+	if err != nil {
+		t.Fatalf("can not create simulator: %v", err)
+	}
+
+	events := make(chan watch.Event)
+	eve := make(chan watch.Event)
+	stop := make(chan struct{})
+	applier := &countingApplier{}
+	go sim.Run(events, eve, applier, stop)
+
+	nodes := map[string]bool{}
+	for len(nodes) < 2 {
+		ev := <-eve
+		if ev.Type != watch.Added {
+			t.Fatalf("unexpected initial node event %v", ev.Type)
+		}
+		nodes[ev.Object.(*v1.Node).Name] = true
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 3; i++ {
+			ev := <-events
+			want := watch.Modified
+			if round == 0 {
+				want = watch.Added
+			}
+			dev := ev.Object.(*typ.Device)
+			if ev.Type != want || len(dev.Status.Twins) != 5 {
+				t.Fatalf("round %v: unexpected event %v with %v twins", round, ev.Type, len(dev.Status.Twins))
+			}
+			if len(typ.ValidateDevice(dev)) > 0 {
+				t.Errorf("simulated device is invalid: %v", typ.ValidateDevice(dev))
+			}
+			for _, twin := range dev.Status.Twins {
+				switch twin.Actual.Metadata["type"] {
+				case "float":
+					if _, err := strconv.ParseFloat(twin.Actual.Value, 64); err != nil {
+						t.Errorf("numeric twin %s has value %q", twin.Name, twin.Actual.Value)
+					}
+				case "boolean":
+					if _, err := strconv.ParseBool(twin.Actual.Value); err != nil {
+						t.Errorf("boolean twin %s has value %q", twin.Name, twin.Actual.Value)
+					}
+				}
+			}
+		}
+		// with a churn of 1 a node is removed after the first round and added again after the second
+		ev := <-eve
+		if round == 0 && ev.Type != watch.Deleted || round == 1 && ev.Type != watch.Added {
+			t.Errorf("round %v: unexpected node event %v", round, ev.Type)
+		}
+	}
+	close(stop)
+
+	if applier.applied < 6 {
+		t.Errorf("applied %v devices, want at least 6", applier.applied)
+	}
+}
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Devices: 0, Nodes: 1, Interval: time.Second},
+		{Devices: 1, Nodes: 1},
+		{Devices: 1, Nodes: 1, Interval: time.Second, Patterns: []string{"square"}},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("options %+v were accepted", opts)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package typ
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The annotations of a Device which control how the exporter exports it
+const (
+	// AnnotationScrape disables the export of the device if it is "false"
+	AnnotationScrape = "exporter.kubeedge.io/scrape"
+	// AnnotationProperties is a comma separated allowlist of the exported properties
+	AnnotationProperties = "exporter.kubeedge.io/properties"
+	// AnnotationTypePrefix followed by a property name overrides the type of the twin
+	AnnotationTypePrefix = "exporter.kubeedge.io/type."
+	// AnnotationLabels is a comma separated list of name=value labels which are added to the series of the device
+	AnnotationLabels = "exporter.kubeedge.io/labels"
+)
+
+var (
+	labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reservedLabels are the labels of the twin series which can not be overridden by AnnotationLabels
+	reservedLabels = []string{"sensorGroup", "node", "sensor", "type", "namespace"}
+	// reservedLabelPrefixes are the prefixes of the Kubernetes labels of the device on the twin series and of the
+	// labels prometheus reserves for itself, like __name__
+	reservedLabelPrefixes = []string{"label_", "__"}
+	twinTypes             = []string{"int", "integer", "float", "double", "boolean", "bool", "string"}
+)
+
+// Export is the export control of a device given by its annotations
+type Export struct {
+	Scrape bool
+	// Properties are the exported properties; all properties are exported if it is nil
+	Properties map[string]bool
+	// Types are the types of the twins by property
+	Types map[string]string
+	// Labels are added to the series of the twins
+	Labels map[string]string
+}
+
+// Exports reports whether the twin property prop is exported
+func (e Export) Exports(prop string) bool {
+	return e.Scrape && (e.Properties == nil || e.Properties[prop])
+}
+
+// LabelNames returns the names of Labels in a stable order
+func (e Export) LabelNames() []string {
+	names := make([]string, 0, len(e.Labels))
+	for name := range e.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportAnnotations returns the export control of dev; invalid annotations are ignored and returned as errors
+func ExportAnnotations(dev *Device) (Export, field.ErrorList) {
+	var errs field.ErrorList
+	export := Export{Scrape: true}
+	annotations := field.NewPath("metadata", "annotations")
+	keys := make([]string, 0, len(dev.Annotations))
+	for key := range dev.Annotations {
+		keys = append(keys, key)
+	}
+	// the errors are sorted, so the validation result of a device does not change between two events
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := dev.Annotations[key]
+		path := annotations.Key(key)
+		switch {
+		case key == AnnotationScrape:
+			switch value {
+			case "true":
+			case "false":
+				export.Scrape = false
+			default:
+				errs = append(errs, field.NotSupported(path, value, []string{"true", "false"}))
+			}
+		case key == AnnotationProperties:
+			export.Properties = make(map[string]bool)
+			for _, prop := range strings.Split(value, ",") {
+				if prop = strings.TrimSpace(prop); prop != "" {
+					export.Properties[prop] = true
+				}
+			}
+		case strings.HasPrefix(key, AnnotationTypePrefix):
+			prop := strings.TrimPrefix(key, AnnotationTypePrefix)
+			if !contains(twinTypes, value) {
+				errs = append(errs, field.NotSupported(path, value, twinTypes))
+				continue
+			}
+			if export.Types == nil {
+				export.Types = make(map[string]string)
+			}
+			export.Types[prop] = value
+		case key == AnnotationLabels:
+			export.Labels = make(map[string]string)
+			for _, pair := range strings.Split(value, ",") {
+				if pair = strings.TrimSpace(pair); pair == "" {
+					continue
+				}
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					errs = append(errs, field.Invalid(path, value, "labels have to be name=value pairs separated by commas"))
+					continue
+				}
+				name := strings.TrimSpace(pair[:i])
+				if !labelName.MatchString(name) || contains(reservedLabels, name) || hasPrefix(name, reservedLabelPrefixes) {
+					errs = append(errs, field.Invalid(path, value, "label "+name+" is not a valid prometheus label name or is reserved"))
+					continue
+				}
+				export.Labels[name] = strings.TrimSpace(pair[i+1:])
+			}
+		}
+	}
+	return export, errs
+}
+
+// hasPrefix reports whether value starts with one of prefixes
+func hasPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package typ
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func fullDevice() *Device {
+	return &Device{
+		TypeMeta: metav1.TypeMeta{Kind: "Device", APIVersion: "devices.kubeedge.io/v1alpha1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sensor",
+			Namespace: "default",
+			Labels:    map[string]string{"site": "munich"},
+		},
+		Spec: DeviceSpec{
+			DeviceModelRef: &v1.LocalObjectReference{Name: "model"},
+			Protocol: ProtocolConfig{
+				OpcUA: &ProtocolConfigOpcUA{URl: "opc.tcp://plc:4840", Timeout: 10},
+				Modbus: &ProtocolConfigModbus{
+					RTU: &ProtocolConfigModbusRTU{SerialPort: "/dev/ttyS0", BaudRate: 9600},
+					TCP: &ProtocolConfigModbusTCP{IP: "10.0.0.1", Port: 502},
+				},
+				Bluetooth: &ProtocolConfigBluetooth{MACAddress: "aa:bb:cc:dd:ee:ff"},
+			},
+			NodeSelector: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{
+						Key:      "name",
+						Operator: v1.NodeSelectorOpIn,
+						Values:   []string{"edge-node"},
+					}},
+				}},
+			},
+		},
+		Status: DeviceStatus{
+			Twins: []Twin{{
+				Name:    "temperature",
+				Actual:  TwinValue{Value: "21", Metadata: map[string]string{"type": "int"}},
+				Desired: TwinValue{Value: "20", Metadata: map[string]string{"type": "int"}},
+			}},
+		},
+	}
+}
+
+func TestDeviceDeepCopyRoundTrip(t *testing.T) {
+	in := fullDevice()
+	out := in.DeepCopy()
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("copy differs from original:\n%+v\n%+v", in, out)
+	}
+
+	obj := in.DeepCopyObject()
+	if !reflect.DeepEqual(in, obj) {
+		t.Fatalf("DeepCopyObject differs from original:\n%+v\n%+v", in, obj)
+	}
+}
+
+func TestDeviceDeepCopyDoesNotAlias(t *testing.T) {
+	in := fullDevice()
+	out := in.DeepCopy()
+
+	out.ObjectMeta.Labels["site"] = "berlin"
+	out.Spec.DeviceModelRef.Name = "other"
+	out.Spec.Protocol.OpcUA.URl = "changed"
+	out.Spec.Protocol.Modbus.RTU.BaudRate = 1
+	out.Spec.Protocol.Modbus.TCP.Port = 1
+	out.Spec.Protocol.Bluetooth.MACAddress = "changed"
+	out.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0].Values[0] = "changed"
+	out.Status.Twins[0].Name = "changed"
+	out.Status.Twins[0].Actual.Metadata["type"] = "changed"
+	out.Status.Twins[0].Desired.Metadata["type"] = "changed"
+
+	if !reflect.DeepEqual(in, fullDevice()) {
+		t.Errorf("modifying the copy changed the original:\n%+v", in)
+	}
+}
+
+func TestDeviceListDeepCopyDoesNotAlias(t *testing.T) {
+	in := &DeviceList{Items: []Device{*fullDevice()}}
+	out := in.DeepCopyObject().(*DeviceList)
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("copy differs from original:\n%+v\n%+v", in, out)
+	}
+
+	out.Items[0].Status.Twins[0].Actual.Metadata["type"] = "changed"
+	out.Items[0].Spec.NodeSelector.NodeSelectorTerms = nil
+	if !reflect.DeepEqual(in.Items[0], *fullDevice()) {
+		t.Errorf("modifying the copy changed the original:\n%+v", in.Items[0])
+	}
+}
+
+func TestDeepCopyNil(t *testing.T) {
+	var dev *Device
+	if dev.DeepCopy() != nil || dev.DeepCopyObject() != nil {
+		t.Errorf("copy of nil Device is not nil")
+	}
+	var list *DeviceList
+	if list.DeepCopy() != nil || list.DeepCopyObject() != nil {
+		t.Errorf("copy of nil DeviceList is not nil")
+	}
+}
+
+func TestDeviceModelDeepCopyDoesNotAlias(t *testing.T) {
+	model := func() *DeviceModel {
+		return &DeviceModel{
+			ObjectMeta: metav1.ObjectMeta{Name: "model", Namespace: "default"},
+			Spec: DeviceModelSpec{Properties: []DeviceProperty{
+				{Name: "temperature", Type: PropertyType{Int: &PropertyTypeInt64{AccessMode: ReadOnly, Minimum: -20, Maximum: 60}}},
+				{Name: "mode", Type: PropertyType{String: &PropertyTypeString{AccessMode: ReadWrite, DefaultValue: "auto"}}},
+			}},
+		}
+	}
+	in := model()
+	out := in.DeepCopyObject().(*DeviceModel)
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("copy differs from original:\n%+v\n%+v", in, out)
+	}
+
+	out.Spec.Properties[0].Type.Int.Maximum = 100
+	out.Spec.Properties[1].Type.String.DefaultValue = "changed"
+	if !reflect.DeepEqual(in, model()) {
+		t.Errorf("modifying the copy changed the original:\n%+v", in)
+	}
+}
+
+func TestExporterConfigDeepCopyDoesNotAlias(t *testing.T) {
+	exporterConfig := func() *ExporterConfig {
+		scale, replacement := 0.1, "$1"
+		return &ExporterConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a", Namespace: "plant-a"},
+			Spec: ExporterConfigSpec{
+				Properties:     []string{"temperature"},
+				Labels:         []string{"site"},
+				Transforms:     []ExporterTransform{{Property: "temperature", Scale: &scale}},
+				RelabelConfigs: []ExporterRelabelConfig{{SourceLabels: []string{"sensor"}, TargetLabel: "property", Replacement: &replacement}},
+			},
+			Status: ExporterConfigStatus{Conditions: []ExporterConfigCondition{{Type: ExporterConfigConditionApplied, Status: v1.ConditionTrue}}},
+		}
+	}
+	in := &ExporterConfigList{Items: []ExporterConfig{*exporterConfig()}}
+	out := in.DeepCopyObject().(*ExporterConfigList)
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("copy differs from original:\n%+v\n%+v", in, out)
+	}
+
+	spec := &out.Items[0].Spec
+	spec.Properties[0] = "changed"
+	spec.Labels[0] = "changed"
+	*spec.Transforms[0].Scale = 2
+	spec.RelabelConfigs[0].SourceLabels[0] = "changed"
+	*spec.RelabelConfigs[0].Replacement = "changed"
+	out.Items[0].Status.Conditions[0].Status = v1.ConditionFalse
+	if !reflect.DeepEqual(in.Items[0], *exporterConfig()) {
+		t.Errorf("modifying the copy changed the original:\n%+v", in.Items[0])
+	}
+}
@@ -0,0 +1,4 @@
+// +k8s:deepcopy-gen=package
+
+// Package typ contains the KubeEdge device types watched by the exporter
+package typ
@@ -0,0 +1,47 @@
+package typ
+
+import (
+	"encoding/json"
+)
+
+// UnmarshalJSON decodes a ProtocolConfigOpcUA and accepts the misspelled keys userNamem and privateLey
+// which were used by older versions of the exporter
+func (in *ProtocolConfigOpcUA) UnmarshalJSON(data []byte) error {
+	type plain ProtocolConfigOpcUA
+	var legacy struct {
+		plain
+		LegacyUserName   string `json:"userNamem,omitempty"`
+		LegacyPrivateKey string `json:"privateLey,omitempty"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	*in = ProtocolConfigOpcUA(legacy.plain)
+	if in.UserName == "" {
+		in.UserName = legacy.LegacyUserName
+	}
+	if in.PrivateKey == "" {
+		in.PrivateKey = legacy.LegacyPrivateKey
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a ProtocolConfigBluetooth and accepts the key twins for the mac address
+// which was used by older versions of the exporter
+func (in *ProtocolConfigBluetooth) UnmarshalJSON(data []byte) error {
+	type plain ProtocolConfigBluetooth
+	var legacy struct {
+		plain
+		LegacyMACAddress string `json:"twins,omitempty"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	*in = ProtocolConfigBluetooth(legacy.plain)
+	if in.MACAddress == "" {
+		in.MACAddress = legacy.LegacyMACAddress
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package typ
 import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DeviceList is a list of Device objects
 type DeviceList struct {
 	metav1.TypeMeta `json:",inline"`
 	metav1.ListMeta `json:"metadata,omitempty"`
@@ -26,12 +28,12 @@ type ProtocolConfig struct {
 
 type ProtocolConfigOpcUA struct {
 	URl            string `json:"url,omitempty"`
-	UserName       string `json:"userNamem,omitempty"`
+	UserName       string `json:"userName,omitempty"`
 	Password       string `json:"password,omitempty"`
 	SecurityPolicy string `json:"securityPolicy,omitempty"`
 	SecurityMode   string `json:"securityMode,omitempty"`
 	Certificate    string `json:"certificate,omitempty"`
-	PrivateKey     string `json:"privateLey,omitempty"`
+	PrivateKey     string `json:"privateKey,omitempty"`
 	Timeout        int64  `json:"timeout,omitempty"`
 }
 
@@ -56,7 +58,7 @@ type ProtocolConfigModbusTCP struct {
 }
 
 type ProtocolConfigBluetooth struct {
-	MACAddress string `json:"twins,omitempty"`
+	MACAddress string `json:"macAddress,omitempty"`
 }
 
 type Twin struct {
@@ -74,6 +76,8 @@ type DeviceStatus struct {
 	Twins []Twin `json:"twins,omitempty"`
 }
 
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
 // Device is the Schema for the devices API
 type Device struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -83,87 +87,135 @@ type Device struct {
 	Status DeviceStatus `json:"status,omitempty"`
 }
 
-func (in *Device) DeepCopy() *Device {
-	if in == nil {
-		return nil
-	}
+// PropertyAccessMode is the access mode of a device property
+type PropertyAccessMode string
 
-	out := new(Device)
-	in.DeepCopyInto(out)
-	return out
-}
+const (
+	ReadWrite PropertyAccessMode = "ReadWrite"
+	ReadOnly  PropertyAccessMode = "ReadOnly"
+)
 
-func (in *Device) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
+type PropertyTypeInt64 struct {
+	AccessMode   PropertyAccessMode `json:"accessMode,omitempty"`
+	DefaultValue int64              `json:"defaultValue,omitempty"`
+	Minimum      int64              `json:"minimum,omitempty"`
+	Maximum      int64              `json:"maximum,omitempty"`
+	Unit         string             `json:"unit,omitempty"`
 }
 
-func (in *Device) DeepCopyInto(out *Device) {
-	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	in.Spec.DeepCopyInto(&out.Spec)
-	in.Status.DeepCopyInto(&out.Status)
+type PropertyTypeString struct {
+	AccessMode   PropertyAccessMode `json:"accessMode,omitempty"`
+	DefaultValue string             `json:"defaultValue,omitempty"`
 }
 
-func (in *DeviceSpec) DeepCopyInto(out *DeviceSpec) {
-	*out = *in
-
-	if in.DeviceModelRef != nil {
-		in, out := &in.DeviceModelRef, &out.DeviceModelRef
-		*out = new(v1.LocalObjectReference)
-		**out = **in
-	}
+// PropertyType is the type of a property; exactly one of the fields is set
+type PropertyType struct {
+	Int    *PropertyTypeInt64  `json:"int,omitempty"`
+	String *PropertyTypeString `json:"string,omitempty"`
 }
 
-func (in *DeviceStatus) DeepCopyInto(out *DeviceStatus) {
-	*out = *in
-	if in.Twins != nil {
-		in, out := &in.Twins, &out.Twins
-		*out = make([]Twin, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
+type DeviceProperty struct {
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Type        PropertyType `json:"type,omitempty"`
 }
 
-func (in *Twin) DeepCopyInto(out *Twin) {
-	*out = *in
-	in.Desired.DeepCopyInto(&out.Desired)
-	in.Actual.DeepCopyInto(&out.Actual)
+type DeviceModelSpec struct {
+	Properties []DeviceProperty `json:"properties,omitempty"`
 }
 
-func (in *TwinValue) DeepCopyInto(out *TwinValue) {
-	*out = *in
-}
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DeviceModel describes the properties of the devices which refer to it
+type DeviceModel struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-func (in *DeviceList) DeepCopyInto(out *DeviceList) {
-	*out = *in
-	out.TypeMeta = in.TypeMeta
-	out.ListMeta = in.ListMeta
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]Device, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
+	Spec DeviceModelSpec `json:"spec,omitempty"`
 }
 
-func (in *DeviceList) DeepCopy() *DeviceList {
-	if in == nil {
-		return nil
-	}
-	out := new(DeviceList)
-	in.DeepCopyInto(out)
-	return out
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DeviceModelList is a list of DeviceModel objects
+type DeviceModelList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeviceModel `json:"items"`
+}
+
+// ExporterConfigSpec selects the twins a team wants to export and how they are rendered
+type ExporterConfigSpec struct {
+	// Priority orders the ExporterConfigs; they are merged by ascending priority, then by namespace and name
+	Priority int `json:"priority,omitempty"`
+	// LabelSelector selects the devices of the namespace of the ExporterConfig by their labels
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// Properties are the exported properties of the selected devices; all properties are exported if it is empty
+	Properties []string `json:"properties,omitempty"`
+	// Labels are device labels which are added as label_<name> to the series of the namespace
+	Labels []string `json:"labels,omitempty"`
+	// Transforms and RelabelConfigs apply to the devices of the namespace after the ones of the exporter configuration
+	Transforms     []ExporterTransform     `json:"transforms,omitempty"`
+	RelabelConfigs []ExporterRelabelConfig `json:"relabelConfigs,omitempty"`
+	// MaxSeries limits the number of series of the selected devices per scrape
+	MaxSeries int `json:"maxSeries,omitempty"`
+}
+
+// ExporterTransform is a transform of the twin series, see config.Transform
+type ExporterTransform struct {
+	Model    string   `json:"model,omitempty"`
+	Property string   `json:"property,omitempty"`
+	Scale    *float64 `json:"scale,omitempty"`
+	Offset   float64  `json:"offset,omitempty"`
+	FromUnit string   `json:"fromUnit,omitempty"`
+	ToUnit   string   `json:"toUnit,omitempty"`
+	Metric   string   `json:"metric,omitempty"`
+}
+
+// ExporterRelabelConfig is a relabeling rule of the twin series, see config.RelabelConfig
+type ExporterRelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	TargetLabel  string   `json:"targetLabel,omitempty"`
+	Replacement  *string  `json:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty"`
+}
+
+// ExporterConfigConditionApplied is the condition which reports whether an ExporterConfig is applied
+const ExporterConfigConditionApplied = "Applied"
+
+// ExporterConfigCondition is a condition of an ExporterConfig
+type ExporterConfigCondition struct {
+	Type   string             `json:"type"`
+	Status v1.ConditionStatus `json:"status"`
+	// ObservedGeneration is the generation of the ExporterConfig the condition refers to
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	Reason             string      `json:"reason,omitempty"`
+	Message            string      `json:"message,omitempty"`
+}
+
+type ExporterConfigStatus struct {
+	Conditions []ExporterConfigCondition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ExporterConfig configures the export of the devices of its namespace; the ExporterConfigs of all namespaces are
+// merged into the render settings of the exporter
+type ExporterConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ExporterConfigSpec   `json:"spec,omitempty"`
+	Status ExporterConfigStatus `json:"status,omitempty"`
 }
 
-func (in *DeviceList) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ExporterConfigList is a list of ExporterConfig objects
+type ExporterConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ExporterConfig `json:"items"`
 }
@@ -0,0 +1,164 @@
+package typ
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var supportedOperators = []string{
+	string(v1.NodeSelectorOpIn),
+	string(v1.NodeSelectorOpNotIn),
+	string(v1.NodeSelectorOpExists),
+	string(v1.NodeSelectorOpDoesNotExist),
+	string(v1.NodeSelectorOpGt),
+	string(v1.NodeSelectorOpLt),
+}
+
+// ValidateDevice returns the problems of dev which prevent the exporter from interpreting it correctly
+func ValidateDevice(dev *Device) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateProtocol(&dev.Spec.Protocol, field.NewPath("spec", "protocol"))...)
+	errs = append(errs, validateNodeSelector(dev.Spec.NodeSelector, field.NewPath("spec", "nodeSelector"))...)
+	_, annotationErrs := ExportAnnotations(dev)
+	errs = append(errs, annotationErrs...)
+
+	twins := field.NewPath("status", "twins")
+	for i, twin := range dev.Status.Twins {
+		if twin.Name == "" {
+			errs = append(errs, field.Required(twins.Index(i).Child("propertyName"), "every twin needs a property name"))
+		}
+	}
+	return errs
+}
+
+func validateProtocol(protocol *ProtocolConfig, path *field.Path) field.ErrorList {
+	if protocol.OpcUA == nil && protocol.Modbus == nil && protocol.Bluetooth == nil {
+		return field.ErrorList{field.Required(path, "one of opcua, modbus or bluetooth has to be set")}
+	}
+	if protocol.Modbus != nil && protocol.Modbus.RTU == nil && protocol.Modbus.TCP == nil {
+		return field.ErrorList{field.Required(path.Child("modbus"), "one of rtu or tcp has to be set")}
+	}
+	return nil
+}
+
+func validateNodeSelector(selector *v1.NodeSelector, path *field.Path) field.ErrorList {
+	if selector == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	terms := path.Child("nodeSelectorTerms")
+	for i, term := range selector.NodeSelectorTerms {
+		expressions := terms.Index(i).Child("matchExpressions")
+		for j, expression := range term.MatchExpressions {
+			p := expressions.Index(j)
+			// KubeEdge selects nodes by their name with an empty key, which only works with In and NotIn
+			if expression.Key == "" && expression.Operator != v1.NodeSelectorOpIn && expression.Operator != v1.NodeSelectorOpNotIn {
+				errs = append(errs, field.Required(p.Child("key"), "may only be empty for operator In or NotIn"))
+			}
+			switch expression.Operator {
+			case v1.NodeSelectorOpIn, v1.NodeSelectorOpNotIn:
+				if len(expression.Values) == 0 {
+					errs = append(errs, field.Required(p.Child("values"), "must be specified for operator "+string(expression.Operator)))
+				}
+			case v1.NodeSelectorOpExists, v1.NodeSelectorOpDoesNotExist:
+				if len(expression.Values) > 0 {
+					errs = append(errs, field.Forbidden(p.Child("values"), "may not be specified for operator "+string(expression.Operator)))
+				}
+			case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+				if len(expression.Values) != 1 {
+					errs = append(errs, field.Required(p.Child("values"), "must have exactly one value for operator "+string(expression.Operator)))
+				} else if _, err := strconv.ParseInt(expression.Values[0], 10, 64); err != nil {
+					errs = append(errs, field.Invalid(p.Child("values").Index(0), expression.Values[0], "must be an integer"))
+				}
+			default:
+				errs = append(errs, field.NotSupported(p.Child("operator"), expression.Operator, supportedOperators))
+			}
+		}
+	}
+	return errs
+}
+
+// DesiredTwin returns the index of the twin property in the twins of dev, or -1; if the twin only exists in model, a
+// twin with the type of the model is appended to dev
+func DesiredTwin(dev *Device, model *DeviceModel, property string) int {
+	for i, twin := range dev.Status.Twins {
+		if twin.Name == property {
+			return i
+		}
+	}
+	prop := modelProperty(model, property)
+	if prop == nil {
+		return -1
+	}
+	valueType := "string"
+	if prop.Type.Int != nil {
+		valueType = "int"
+	}
+	dev.Status.Twins = append(dev.Status.Twins, Twin{Name: property, Desired: TwinValue{Metadata: map[string]string{"type": valueType}}})
+	return len(dev.Status.Twins) - 1
+}
+
+// ValidateDesired returns the problems of value as desired value of the i-th twin of dev; value has to match the type
+// of the twin and the range and access mode of the property in model, which may be nil
+func ValidateDesired(dev *Device, model *DeviceModel, i int, value string) field.ErrorList {
+	twin := dev.Status.Twins[i]
+	path := field.NewPath("status", "twins").Index(i).Child("desired", "value")
+
+	prop := modelProperty(model, twin.Name)
+	valueType := twin.Actual.Metadata["type"]
+	if valueType == "" {
+		valueType = twin.Desired.Metadata["type"]
+	}
+	if valueType == "" && prop != nil && prop.Type.Int != nil {
+		valueType = "int"
+	}
+
+	var errs field.ErrorList
+	switch valueType {
+	case "int":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			errs = append(errs, field.Invalid(path, value, "must be an integer"))
+		}
+	case "float", "double":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			errs = append(errs, field.Invalid(path, value, "must be a number"))
+		}
+	case "boolean", "bool":
+		if _, err := strconv.ParseBool(value); err != nil {
+			errs = append(errs, field.Invalid(path, value, "must be true or false"))
+		}
+	}
+
+	switch {
+	case prop == nil:
+	case prop.Type.Int != nil:
+		if prop.Type.Int.AccessMode == ReadOnly {
+			errs = append(errs, field.Forbidden(path, "the property is read only"))
+		}
+		min, max := prop.Type.Int.Minimum, prop.Type.Int.Maximum
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && max > min && (n < min || n > max) {
+			errs = append(errs, field.Invalid(path, value, fmt.Sprintf("must be in the range %d to %d", min, max)))
+		}
+	case prop.Type.String != nil:
+		if prop.Type.String.AccessMode == ReadOnly {
+			errs = append(errs, field.Forbidden(path, "the property is read only"))
+		}
+	}
+	return errs
+}
+
+func modelProperty(model *DeviceModel, property string) *DeviceProperty {
+	if model == nil {
+		return nil
+	}
+	for i := range model.Spec.Properties {
+		if model.Spec.Properties[i].Name == property {
+			return &model.Spec.Properties[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package typ
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestProtocolConfigDecoding(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want ProtocolConfig
+	}{
+		{
+			name: "current keys",
+			data: `{"opcua":{"userName":"user","privateKey":"key"},"bluetooth":{"macAddress":"aa:bb"}}`,
+			want: ProtocolConfig{
+				OpcUA:     &ProtocolConfigOpcUA{UserName: "user", PrivateKey: "key"},
+				Bluetooth: &ProtocolConfigBluetooth{MACAddress: "aa:bb"},
+			},
+		},
+		{
+			name: "legacy keys",
+			data: `{"opcua":{"userNamem":"user","privateLey":"key","url":"opc.tcp://plc"},"bluetooth":{"twins":"aa:bb"}}`,
+			want: ProtocolConfig{
+				OpcUA:     &ProtocolConfigOpcUA{URl: "opc.tcp://plc", UserName: "user", PrivateKey: "key"},
+				Bluetooth: &ProtocolConfigBluetooth{MACAddress: "aa:bb"},
+			},
+		},
+		{
+			name: "current keys win",
+			data: `{"opcua":{"userName":"new","userNamem":"old"},"bluetooth":{"macAddress":"new","twins":"old"}}`,
+			want: ProtocolConfig{
+				OpcUA:     &ProtocolConfigOpcUA{UserName: "new"},
+				Bluetooth: &ProtocolConfigBluetooth{MACAddress: "new"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var got ProtocolConfig
+		if err := json.Unmarshal([]byte(test.data), &got); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if *got.OpcUA != *test.want.OpcUA || *got.Bluetooth != *test.want.Bluetooth {
+			t.Errorf("%s: got %+v %+v, want %+v %+v", test.name, got.OpcUA, got.Bluetooth, test.want.OpcUA, test.want.Bluetooth)
+		}
+	}
+}
+
+func TestValidateDevice(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(dev *Device)
+		errors []string
+	}{
+		{
+			name:   "valid",
+			modify: func(dev *Device) {},
+		},
+		{
+			name: "missing property name",
+			modify: func(dev *Device) {
+				dev.Status.Twins[0].Name = ""
+			},
+			errors: []string{"status.twins[0].propertyName"},
+		},
+		{
+			name: "unknown protocol",
+			modify: func(dev *Device) {
+				dev.Spec.Protocol = ProtocolConfig{}
+			},
+			errors: []string{"spec.protocol"},
+		},
+		{
+			name: "modbus without transport",
+			modify: func(dev *Device) {
+				dev.Spec.Protocol = ProtocolConfig{Modbus: &ProtocolConfigModbus{}}
+			},
+			errors: []string{"spec.protocol.modbus"},
+		},
+		{
+			name: "invalid operator",
+			modify: func(dev *Device) {
+				dev.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0].Operator = "notIn"
+			},
+			errors: []string{"spec.nodeSelector.nodeSelectorTerms[0].matchExpressions[0].operator"},
+		},
+		{
+			name: "in without values",
+			modify: func(dev *Device) {
+				dev.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0].Values = nil
+			},
+			errors: []string{"matchExpressions[0].values"},
+		},
+		{
+			name: "empty key selecting the node by name",
+			modify: func(dev *Device) {
+				dev.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0].Key = ""
+			},
+		},
+		{
+			name: "empty key with exists",
+			modify: func(dev *Device) {
+				expression := &dev.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0]
+				expression.Key, expression.Operator, expression.Values = "", v1.NodeSelectorOpExists, nil
+			},
+			errors: []string{"matchExpressions[0].key"},
+		},
+		{
+			name: "gt with a non integer value",
+			modify: func(dev *Device) {
+				dev.Spec.NodeSelector.NodeSelectorTerms[0].MatchExpressions[0].Operator = v1.NodeSelectorOpGt
+			},
+			errors: []string{"matchExpressions[0].values[0]"},
+		},
+		{
+			name: "valid export annotations",
+			modify: func(dev *Device) {
+				dev.Annotations = map[string]string{
+					AnnotationScrape:                   "true",
+					AnnotationProperties:               "temperature, pressure",
+					AnnotationTypePrefix + "pressure":  "float",
+					AnnotationLabels:                   "site=munich,line=3",
+					"exporter.kubeedge.io/unrelated":   "x",
+					"kubectl.kubernetes.io/annotation": "x",
+				}
+			},
+		},
+		{
+			name: "invalid export annotations",
+			modify: func(dev *Device) {
+				dev.Annotations = map[string]string{
+					AnnotationScrape:                  "no",
+					AnnotationTypePrefix + "pressure": "decimal",
+					AnnotationLabels:                  "site,node=a,label_site=b,__name__=c",
+				}
+			},
+			errors: []string{"metadata.annotations[exporter.kubeedge.io/labels]", "labels]", "labels]", "labels]", "metadata.annotations[exporter.kubeedge.io/scrape]", "type.pressure]"},
+		},
+	}
+
+	for _, test := range tests {
+		dev := fullDevice()
+		test.modify(dev)
+		errs := ValidateDevice(dev)
+		if len(errs) != len(test.errors) {
+			t.Errorf("%s: got errors %v, want %v", test.name, errs, test.errors)
+			continue
+		}
+		for i, err := range errs {
+			if !strings.Contains(err.Error(), test.errors[i]) {
+				t.Errorf("%s: error %q does not mention %q", test.name, err, test.errors[i])
+			}
+		}
+	}
+}
+
+func TestValidateDesired(t *testing.T) {
+	model := &DeviceModel{Spec: DeviceModelSpec{Properties: []DeviceProperty{
+		{Name: "temperature", Type: PropertyType{Int: &PropertyTypeInt64{AccessMode: ReadWrite, Minimum: 10, Maximum: 30}}},
+		{Name: "serial", Type: PropertyType{String: &PropertyTypeString{AccessMode: ReadOnly}}},
+		{Name: "mode", Type: PropertyType{String: &PropertyTypeString{AccessMode: ReadWrite}}},
+	}}}
+	tests := []struct {
+		property string
+		value    string
+		errors   []string
+	}{
+		{"temperature", "25", nil},
+		{"temperature", "2.5", []string{"must be an integer"}},
+		{"temperature", "31", []string{"range 10 to 30"}},
+		{"serial", "x", []string{"read only"}},
+		{"mode", "eco", nil},
+	}
+	for _, test := range tests {
+		dev := fullDevice()
+		i := DesiredTwin(dev, model, test.property)
+		if i < 0 || dev.Status.Twins[i].Name != test.property {
+			t.Errorf("%s: the twin was not found", test.property)
+			continue
+		}
+		errs := ValidateDesired(dev, model, i, test.value)
+		if len(errs) != len(test.errors) {
+			t.Errorf("%s=%s: got errors %v, want %v", test.property, test.value, errs, test.errors)
+			continue
+		}
+		for j, err := range errs {
+			if !strings.Contains(err.Error(), test.errors[j]) {
+				t.Errorf("%s=%s: error %q does not mention %q", test.property, test.value, err, test.errors[j])
+			}
+		}
+	}
+
+	if i := DesiredTwin(fullDevice(), model, "unknown"); i != -1 {
+		t.Errorf("unknown twin found at %v", i)
+	}
+	dev := fullDevice()
+	dev.Status.Twins[0].Actual.Metadata["type"] = "boolean"
+	if errs := ValidateDesired(dev, nil, 0, "on"); len(errs) != 1 {
+		t.Errorf("got errors %v for a boolean twin", errs)
+	}
+}
@@ -0,0 +1,69 @@
+// Package units converts twin values between units of the same quantity
+package units
+
+import (
+	"fmt"
+	"sort"
+)
+
+// unit converts a value into the base unit of its quantity by value*factor + offset
+type unit struct {
+	quantity string
+	factor   float64
+	offset   float64
+}
+
+var units = map[string]unit{
+	"celsius":     {"temperature", 1, 0},
+	"fahrenheit":  {"temperature", 5.0 / 9, -32 * 5.0 / 9},
+	"kelvin":      {"temperature", 1, -273.15},
+	"pascal":      {"pressure", 1, 0},
+	"hectopascal": {"pressure", 100, 0},
+	"kilopascal":  {"pressure", 1000, 0},
+	"bar":         {"pressure", 100000, 0},
+	"psi":         {"pressure", 6894.757293168, 0},
+	"millimeter":  {"length", 0.001, 0},
+	"centimeter":  {"length", 0.01, 0},
+	"meter":       {"length", 1, 0},
+	"kilometer":   {"length", 1000, 0},
+	"inch":        {"length", 0.0254, 0},
+	"foot":        {"length", 0.3048, 0},
+	"milliwatt":   {"power", 0.001, 0},
+	"watt":        {"power", 1, 0},
+	"kilowatt":    {"power", 1000, 0},
+	"millisecond": {"time", 0.001, 0},
+	"second":      {"time", 1, 0},
+	"minute":      {"time", 60, 0},
+	"hour":        {"time", 3600, 0},
+	"percent":     {"ratio", 0.01, 0},
+	"ratio":       {"ratio", 1, 0},
+}
+
+// Names returns the names of the supported units
+func Names() []string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Converter returns a function converting values from the unit from into the unit to; both units have to measure
+// the same quantity
+func Converter(from string, to string) (func(float64) float64, error) {
+	f, ok := units[from]
+	if !ok {
+		return nil, fmt.Errorf("unit %q is unknown", from)
+	}
+	t, ok := units[to]
+	if !ok {
+		return nil, fmt.Errorf("unit %q is unknown", to)
+	}
+	if f.quantity != t.quantity {
+		return nil, fmt.Errorf("can not convert %s (%s) into %s (%s)", from, f.quantity, to, t.quantity)
+	}
+	return func(value float64) float64 {
+		return (value*f.factor + f.offset - t.offset) / t.factor
+	}, nil
+}
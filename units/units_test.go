@@ -0,0 +1,36 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConverter(t *testing.T) {
+	for _, c := range []struct {
+		from, to      string
+		value, result float64
+	}{
+		{"fahrenheit", "celsius", 212, 100},
+		{"celsius", "fahrenheit", -40, -40},
+		{"kelvin", "celsius", 273.15, 0},
+		{"celsius", "kelvin", 25, 298.15},
+		{"bar", "kilopascal", 1.5, 150},
+		{"inch", "centimeter", 1, 2.54},
+		{"percent", "ratio", 50, 0.5},
+	} {
+		convert, err := Converter(c.from, c.to)
+		if err != nil {
+			t.Fatalf("%s to %s: %v", c.from, c.to, err)
+		}
+		if got := convert(c.value); math.Abs(got-c.result) > 1e-9 {
+			t.Errorf("%v %s is %v %s, want %v", c.value, c.from, got, c.to, c.result)
+		}
+	}
+
+	if _, err := Converter("celsius", "bar"); err == nil {
+		t.Errorf("celsius can be converted into bar")
+	}
+	if _, err := Converter("celsius", "rankine"); err == nil {
+		t.Errorf("an unknown unit is accepted")
+	}
+}